@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	"golang.org/x/exp/maps"
+)
+
+// Backup archive file names.
+const (
+	backupConfigFile = "config.yaml"
+	backupStateFile  = "state.json"
+)
+
+// maxRestoreSize is the maximum size of a backup archive accepted by restore.
+const maxRestoreSize = 10 << 20
+
+// locoStates returns the drive states of all locos.
+func (s *deviceSets) locoStates() map[string]*devices.LocoState {
+	states := map[string]*devices.LocoState{}
+	for name, loco := range s.locoSet.Items() {
+		states[name] = loco.State()
+	}
+	return states
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, b []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// serveBackup serves a gzip compressed tarball containing the active configuration and the loco states.
+func (s *deviceSets) serveBackup(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := s.config().writeYaml(&buf); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state, err := json.MarshalIndent(s.locoStates(), "", "    ")
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"pico-cs-backup-%s.tar.gz\"", now.Format("20060102-150405")))
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	if err := writeTarFile(tw, backupConfigFile, now, buf.Bytes()); err != nil {
+		s.lg.Printf("backup: %s", err)
+		return
+	}
+	if err := writeTarFile(tw, backupStateFile, now, state); err != nil {
+		s.lg.Printf("backup: %s", err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		s.lg.Printf("backup: %s", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		s.lg.Printf("backup: %s", err)
+	}
+}
+
+// restoreResult lists the devices (<type>/<name>) changed by a restore.
+type restoreResult struct {
+	Removed []string `json:"removed"`
+	Set     []string `json:"set"`
+}
+
+func (r *restoreResult) String() string {
+	return fmt.Sprintf("removed %v set %v", r.Removed, r.Set)
+}
+
+// restore replaces the active configuration by config: devices not part of config are removed,
+// new or changed devices are added or replaced. config needs to be validated before, so that the
+// active configuration is not changed in case of invalid device configurations. As a failed device
+// change is not rolled back, the devices changed before the failure are returned in any case.
+func (s *deviceSets) restore(config *config) (*restoreResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &restoreResult{Removed: []string{}, Set: []string{}}
+
+	csNames, locoNames := maps.Keys(config.csConfigMap), maps.Keys(config.locoConfigMap)
+	sort.Strings(csNames)
+	sort.Strings(locoNames)
+	apply := func(typ, name string, fn func() error, applied *[]string) error {
+		if err := fn(); err != nil {
+			return fmt.Errorf("%s %s: %w", typ, name, err)
+		}
+		*applied = append(*applied, typ+"/"+name)
+		return nil
+	}
+
+	for _, name := range sortedKeys(s.locoSet.Items()) {
+		if _, ok := config.locoConfigMap[name]; !ok {
+			if err := apply(devices.CtLoco, name, func() error { return s.removeLoco(name) }, &result.Removed); err != nil {
+				return result, err
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.csSet.Items()) {
+		if _, ok := config.csConfigMap[name]; !ok {
+			if err := apply(devices.CtCS, name, func() error { return s.removeCS(name) }, &result.Removed); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	csMap := s.csSet.Items()
+	for _, name := range csNames {
+		csConfig := config.csConfigMap[name]
+		if cs, ok := csMap[name]; ok && reflect.DeepEqual(cs.Config(), csConfig) {
+			continue
+		}
+		if err := apply(devices.CtCS, name, func() error { return s.setCS(csConfig) }, &result.Set); err != nil {
+			return result, err
+		}
+	}
+	locoMap := s.locoSet.Items()
+	for _, name := range locoNames {
+		locoConfig := config.locoConfigMap[name]
+		if loco, ok := locoMap[name]; ok && reflect.DeepEqual(loco.Config(), locoConfig) {
+			continue
+		}
+		if err := apply(devices.CtLoco, name, func() error { return s.setLoco(locoConfig) }, &result.Set); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	sort.Strings(keys)
+	return keys
+}
+
+// restoreStates restores direction and functions of the locos via their primary command stations.
+// For safety reasons the speed is not restored.
+func (s *deviceSets) restoreStates(states map[string]*devices.LocoState) {
+	locoMap := s.locoSet.Items()
+	for name, state := range states {
+		loco, ok := locoMap[name]
+		if !ok {
+			continue
+		}
+		fcts := map[string]any{}
+		for fctName, fct := range state.Fcts {
+			if _, ok := loco.Config().Fcts[fctName]; ok {
+				fcts[fctName] = fct
+			}
+		}
+		if !s.gw.Dispatch([]string{"loco", name, "drive", "set"}, map[string]any{"dir": state.Dir, "fcts": fcts}) {
+			s.lg.Printf("restore: loco %s state skipped - no primary command station", name)
+		}
+	}
+}
+
+// serveRestore restores the configuration and the loco states of a backup tarball (POST request).
+func (s *deviceSets) serveRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		server.MethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	fsys, err := readTar(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b, err := fs.ReadFile(fsys, backupConfigFile)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config := newConfig(s.lg)
+	if err := config.parseYaml(b); err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := config.validate(); err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var states map[string]*devices.LocoState
+	if b, err := fs.ReadFile(fsys, backupStateFile); err == nil {
+		if err := json.Unmarshal(b, &states); err != nil {
+			server.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.lg.Printf("restore configuration: %d command stations %d locos", len(config.csConfigMap), len(config.locoConfigMap))
+	result, err := s.restore(config)
+	s.publishDevices()
+	if err != nil {
+		s.lg.Printf("restore failed: %s - applied: %s", err, result)
+		server.Error(w, fmt.Sprintf("%s - applied before the failure: %s", err, result), http.StatusInternalServerError)
+		return
+	}
+	s.restoreStates(states)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.deviceNames())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+)
+
+// Configuration conflict modes defining how devices defined more than once (same name) and
+// locos sharing a decoder address are handled while loading the configuration files.
+const (
+	conflictWarn  = "warn"  // log a warning - the last definition wins (default)
+	conflictError = "error" // loading the configuration fails
+	conflictSkip  = "skip"  // log a warning - the first definition wins
+)
+
+var conflictModes = []string{conflictWarn, conflictError, conflictSkip}
+
+func validateConflictMode(mode string) error {
+	switch mode {
+	case conflictWarn, conflictError, conflictSkip:
+		return nil
+	default:
+		return fmt.Errorf("invalid configuration conflict mode %s %v", mode, conflictModes)
+	}
+}
+
+// conflictAddr returns the name of a loco different from name using the decoder address addr.
+func (c *config) conflictAddr(name string, addr uint) (string, bool) {
+	for _, locoConfig := range c.locoConfigMap {
+		if locoConfig.Name != name && locoConfig.Addr == addr {
+			return locoConfig.Name, true
+		}
+	}
+	return "", false
+}
+
+// checkConflict checks a device definition at line of the current file for conflicts with the
+// devices defined before. It returns false if the definition is to be skipped.
+func (c *config) checkConflict(typ, name string, line int, locoConfig *devices.LocoConfig) (bool, error) {
+	key := typ + "/" + name
+	origin := fmt.Sprintf("line %d", line)
+	if c.file != "" {
+		origin = fmt.Sprintf("%s:%d", c.file, line)
+	}
+
+	var msg, warn string
+	if prev, ok := c.origins[key]; ok {
+		msg = fmt.Sprintf("%s %s at %s already defined at %s", typ, name, origin, prev)
+		warn = "last definition wins"
+	} else if locoConfig != nil {
+		if other, ok := c.conflictAddr(name, locoConfig.Addr); ok {
+			msg = fmt.Sprintf("loco %s at %s uses address %d of loco %s defined at %s", name, origin, locoConfig.Addr, other, c.origins[typ+"/"+other])
+			warn = "both locos loaded"
+		}
+	}
+
+	if msg != "" {
+		switch c.conflict {
+		case conflictError:
+			return false, fmt.Errorf("configuration conflict: %s", msg)
+		case conflictSkip:
+			c.lg.Printf("configuration conflict: %s - skipped", msg)
+			return false, nil
+		default:
+			c.lg.Printf("configuration conflict: %s - %s", msg, warn)
+		}
+	}
+	c.origins[key] = origin
+	return true, nil
+}
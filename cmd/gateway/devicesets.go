@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	"golang.org/x/exp/maps"
+)
+
+type deviceSets struct {
+	lg      logger.Logger
+	gw      *gateway.Gateway
+	csSet   *devices.CSSet
+	locoSet *devices.LocoSet
+	hndCh   chan *gateway.HndMsg
+	state   *stateTracker
+	mu      sync.Mutex // serializes runtime device changes
+
+	syncMode string // roster synchronization mode
+}
+
+func newDeviceSets(lg logger.Logger, gw *gateway.Gateway, csSetConfig *devices.CSSetConfig) (*deviceSets, error) {
+	csSet, err := devices.NewCSSet(lg, gw, csSetConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceSets{
+		lg:      lg,
+		gw:      gw,
+		csSet:   csSet,
+		locoSet: devices.NewLocoSet(lg, gw),
+		hndCh:   make(chan *gateway.HndMsg, gateway.DefChanSize),
+		state:   newStateTracker(),
+	}, nil
+}
+
+func (s *deviceSets) close() {
+	s.unsubscribe()
+	close(s.hndCh)
+	s.csSet.Close()
+	s.locoSet.Close()
+}
+
+// parallel calls fn for each item concurrently and returns the first error.
+func parallel[T any](items []T, fn func(item T) error) error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(items))
+	for _, item := range items {
+		wg.Add(1)
+		go func(item T) {
+			defer wg.Done()
+			errCh <- fn(item)
+		}(item)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register registers the devices of config. Command stations are opened concurrently and
+// the locos are assigned to the command stations concurrently (sequentially per command station).
+func (s *deviceSets) register(config *config) error {
+	if err := parallel(maps.Values(config.csConfigMap), func(csConfig *devices.CSConfig) error {
+		_, err := s.csSet.Add(csConfig)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	locos := make([]*devices.Loco, 0, len(config.locoConfigMap))
+	for _, locoConfig := range config.locoConfigMap {
+		loco, err := s.locoSet.Add(locoConfig)
+		if err != nil {
+			return err
+		}
+		locos = append(locos, loco)
+	}
+	if err := parallel(maps.Values(s.csSet.Items()), func(cs *devices.CS) error {
+		for _, loco := range locos {
+			if _, err := cs.AddLoco(loco); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	s.subscribe()
+	go s.cmdHandler(s.hndCh)
+	s.publishDevices()
+	return nil
+}
+
+func (s *deviceSets) registerHTTP(server *server.Server) {
+	server.HandleIndexFunc(devices.HTTPHandler)
+	server.Handle("/cs", s.csSet)
+	server.HandleFunc("/cs/", s.serveDevices(devices.CtCS, s.csSet))
+	server.Handle("/loco", s.locoSet)
+	server.HandleFunc("/loco/", s.serveDevices(devices.CtLoco, s.locoSet))
+	server.HandleFunc("/throttle/", s.locoSet.ServeThrottle)
+	server.HandleFunc("/config.yaml", s.serveConfig)
+	server.HandleFunc("/config", s.serveConfigQuery)
+	server.HandleFunc("/metrics", s.csSet.ServeMetrics)
+	server.HandleFunc("/backup", s.serveBackup)
+	server.HandleFunc("/restore", s.serveRestore)
+	server.HandleFunc("/move", s.serveMove)
+	server.HandleFunc("/state", s.serveState)
+	server.HandleFunc("/estop", s.csSet.ServeEmergencyStop)
+	server.HandlePublicFunc("/healthz", serveHealth)
+	server.HandlePublicFunc("/readyz", s.serveReady)
+	server.HandleFunc("/openapi.json", serveOpenAPI)
+}
+
+// addCS adds a command station and assigns all locos to it.
+func (s *deviceSets) addCS(config *devices.CSConfig) error {
+	cs, err := s.csSet.Add(config)
+	if err != nil {
+		return err
+	}
+	for _, loco := range s.locoSet.Items() {
+		if _, err := cs.AddLoco(loco); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addLoco adds a loco and assigns it to all command stations.
+func (s *deviceSets) addLoco(config *devices.LocoConfig) error {
+	loco, err := s.locoSet.Add(config)
+	if err != nil {
+		return err
+	}
+	for _, cs := range s.csSet.Items() {
+		if _, err := cs.AddLoco(loco); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCS closes and removes a command station.
+func (s *deviceSets) removeCS(name string) error { return s.csSet.Delete(name) }
+
+// removeLoco removes a loco from all command stations and the loco set.
+func (s *deviceSets) removeLoco(name string) error {
+	loco, ok := s.locoSet.Items()[name]
+	if !ok {
+		return fmt.Errorf("loco %s does not exist", name)
+	}
+	for _, cs := range s.csSet.Items() {
+		cs.RemoveLoco(loco)
+	}
+	return s.locoSet.Delete(name)
+}
+
+// setCS adds or replaces (last one wins) a command station.
+func (s *deviceSets) setCS(config *devices.CSConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if _, ok := s.csSet.Items()[config.Name]; ok {
+		if err := s.removeCS(config.Name); err != nil {
+			return err
+		}
+	}
+	return s.addCS(config)
+}
+
+// setLoco adds or replaces (last one wins) a loco.
+func (s *deviceSets) setLoco(config *devices.LocoConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if _, ok := s.locoSet.Items()[config.Name]; ok {
+		if err := s.removeLoco(config.Name); err != nil {
+			return err
+		}
+	}
+	return s.addLoco(config)
+}
+
+// deviceDoc represents the type and name of a device configuration document.
+type deviceDoc struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func decodeDeviceDoc(payload any) (*deviceDoc, []byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := &deviceDoc{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid document %s - object expected", b)
+	}
+	if doc.Type == "" {
+		return nil, nil, fmt.Errorf("invalid document %s - type missing", b)
+	}
+	if doc.Name == "" {
+		return nil, nil, fmt.Errorf("invalid document %s - name missing", b)
+	}
+	return doc, b, nil
+}
+
+// typedDevice returns a device command function for configuration documents of type typ, where
+// the type is implied by the command topic and might be omitted in the payload.
+func typedDevice(typ string, fn func(payload any) (any, error)) func(payload any) (any, error) {
+	return func(payload any) (any, error) {
+		m, ok := payload.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid document %v - object expected", payload)
+		}
+		if t, ok := m["type"]; ok && t != typ {
+			return nil, fmt.Errorf("invalid document type %v - %s expected", t, typ)
+		}
+		m = maps.Clone(m)
+		m["type"] = typ
+		return fn(m)
+	}
+}
+
+// deviceNames represents the names of the registered devices.
+type deviceNames struct {
+	CS   []string `json:"cs"`
+	Loco []string `json:"loco"`
+}
+
+func (s *deviceSets) deviceNames() *deviceNames {
+	names := &deviceNames{CS: maps.Keys(s.csSet.Items()), Loco: maps.Keys(s.locoSet.Items())}
+	sort.Strings(names.CS)
+	sort.Strings(names.Loco)
+	return names
+}
+
+func (s *deviceSets) subscribe() {
+	s.gw.Subscribe(s.hndCh, s, []string{"gateway", "add"}, s.addDevice)
+	s.gw.Subscribe(s.hndCh, s, []string{"gateway", "remove"}, s.removeDevice)
+	s.gw.Subscribe(s.hndCh, s, []string{"gateway", "move"}, s.moveLoco)
+	for _, typ := range []string{devices.CtCS, devices.CtLoco} {
+		s.gw.Subscribe(s.hndCh, s, []string{"admin", typ, "add"}, typedDevice(typ, s.addDevice))
+		s.gw.Subscribe(s.hndCh, s, []string{"admin", typ, "remove"}, typedDevice(typ, s.removeDevice))
+	}
+}
+
+func (s *deviceSets) unsubscribe() {
+	s.gw.Unsubscribe(s, []string{"gateway", "add"})
+	s.gw.Unsubscribe(s, []string{"gateway", "remove"})
+	s.gw.Unsubscribe(s, []string{"gateway", "move"})
+	for _, typ := range []string{devices.CtCS, devices.CtLoco} {
+		s.gw.Unsubscribe(s, []string{"admin", typ, "add"})
+		s.gw.Unsubscribe(s, []string{"admin", typ, "remove"})
+	}
+}
+
+// cmdHandler handles device management commands.
+func (s *deviceSets) cmdHandler(hndCh <-chan *gateway.HndMsg) {
+	for msg := range hndCh {
+		if _, err := msg.Fn(msg.Value); err != nil {
+			s.gw.PublishErr(msg.TopicStrs, false, err)
+			continue
+		}
+		s.publishDevices()
+	}
+}
+
+func (s *deviceSets) publishDevices() {
+	s.gw.Publish([]string{"gateway", "devices"}, true, s.deviceNames())
+	s.publishSync()
+}
+
+// addDevice adds or replaces a device via a JSON configuration document.
+func (s *deviceSets) addDevice(payload any) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, b, err := decodeDeviceDoc(payload)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeJSONDoc(doc.Type, b)
+	if err != nil {
+		return nil, err
+	}
+	switch config := config.(type) {
+	case *devices.CSConfig:
+		s.lg.Printf("add command station %s", config.Name)
+		return nil, s.setCS(config)
+	case *devices.LocoConfig:
+		s.lg.Printf("add loco %s", config.Name)
+		return nil, s.setLoco(config)
+	default:
+		return nil, fmt.Errorf("invalid configuration type %s", doc.Type)
+	}
+}
+
+// removeDevice removes a device identified by a JSON document containing type and name.
+func (s *deviceSets) removeDevice(payload any) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, _, err := decodeDeviceDoc(payload)
+	if err != nil {
+		return nil, err
+	}
+	switch doc.Type {
+	case devices.CtCS:
+		s.lg.Printf("remove command station %s", doc.Name)
+		return nil, s.removeCS(doc.Name)
+	case devices.CtLoco:
+		s.lg.Printf("remove loco %s", doc.Name)
+		return nil, s.removeLoco(doc.Name)
+	default:
+		return nil, fmt.Errorf("invalid configuration type %s", doc.Type)
+	}
+}
+
+// moveDoc represents a loco and the command station the loco is moved to.
+type moveDoc struct {
+	Loco string `json:"loco"`
+	CS   string `json:"cs"`
+}
+
+// move moves the primary control of a loco to a command station at runtime (until the loco or
+// command station configuration is changed).
+func (s *deviceSets) move(doc *moveDoc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loco, ok := s.locoSet.Items()[doc.Loco]
+	if !ok {
+		return fmt.Errorf("loco %s does not exist", doc.Loco)
+	}
+	s.lg.Printf("move loco %s to command station %s", doc.Loco, doc.CS)
+	return s.csSet.MoveLoco(loco, doc.CS)
+}
+
+// moveLoco moves a loco identified by a JSON document containing the loco and command station name.
+func (s *deviceSets) moveLoco(payload any) (any, error) {
+	doc, err := gateway.Decode[moveDoc](payload)
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.move(&doc)
+}
+
+// serveMove moves a loco (POST request with a JSON document containing the loco and command station name).
+func (s *deviceSets) serveMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		server.MethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !server.RequireJSON(w, r) {
+		return
+	}
+	doc := &moveDoc{}
+	if err := json.NewDecoder(r.Body).Decode(doc); err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.move(doc); err != nil {
+		server.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
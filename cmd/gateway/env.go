@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRegexp matches the environment variable placeholders ${VAR} and ${VAR:-default}.
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable placeholders of s. The default value is used
+// in case the variable is not set or empty.
+func expandEnv(s string) (string, error) {
+	var err error
+	s = envRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		m := envRegexp.FindStringSubmatch(placeholder)
+		if value := os.Getenv(m[1]); value != "" {
+			return value
+		}
+		if m[2] == "" && err == nil {
+			err = fmt.Errorf("environment variable %s not set", m[1])
+		}
+		return m[3]
+	})
+	return s, err
+}
+
+// expandEnvNode replaces the environment variable placeholders of all scalar values of a yaml node tree.
+func expandEnvNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && envRegexp.MatchString(node.Value) {
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		node.Tag = "" // resolve the expanded value (e.g. numbers)
+		node.Style = 0
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := expandEnvNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"golang.org/x/exp/maps"
+)
+
+type filterMatch struct {
+	csName string
+	incl   string
+	excl   string
+}
+
+func (m *filterMatch) String() string {
+	if m.excl != "" {
+		return fmt.Sprintf("%s (included by %q, excluded by %q)", m.csName, m.incl, m.excl)
+	}
+	return fmt.Sprintf("%s (included by %q)", m.csName, m.incl)
+}
+
+type locoExplanation struct {
+	primaries   []*filterMatch
+	secondaries []*filterMatch
+	excluded    []*filterMatch
+}
+
+func matchFilter(csName string, filter *devices.Filter, locoName string) (*filterMatch, bool, error) {
+	incl, excl, err := filter.Match(locoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("command station %s: %s", csName, err)
+	}
+	if incl == "" {
+		return nil, false, nil
+	}
+	return &filterMatch{csName: csName, incl: incl, excl: excl}, excl == "", nil
+}
+
+// explainLoco resolves the primary and secondary command stations of a loco like the
+// command station registration does.
+func (c *config) explainLoco(locoName string) (*locoExplanation, error) {
+	e := &locoExplanation{}
+
+	csNames := maps.Keys(c.csConfigMap)
+	sort.Strings(csNames)
+
+	for _, csName := range csNames {
+		csConfig := c.csConfigMap[csName]
+
+		// primary filter has precedence over secondary filter
+		m, ok, err := matchFilter(csName, csConfig.Primary, locoName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			e.primaries = append(e.primaries, m)
+			continue
+		}
+		if m != nil {
+			e.excluded = append(e.excluded, m)
+		}
+
+		m, ok, err = matchFilter(csName, csConfig.Secondary, locoName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			e.secondaries = append(e.secondaries, m)
+			continue
+		}
+		if m != nil {
+			e.excluded = append(e.excluded, m)
+		}
+	}
+	return e, nil
+}
+
+// explain prints the primary and secondary command station resolution of all locos.
+func (c *config) explain(w io.Writer) error {
+	locoNames := maps.Keys(c.locoConfigMap)
+	sort.Strings(locoNames)
+
+	for _, locoName := range locoNames {
+		e, err := c.explainLoco(locoName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "loco %s (address %d)\n", locoName, c.locoConfigMap[locoName].Addr)
+		switch len(e.primaries) {
+		case 0:
+			fmt.Fprintf(w, "\tprimary:   none - loco cannot be controlled\n")
+		case 1:
+			fmt.Fprintf(w, "\tprimary:   %s\n", e.primaries[0])
+		default:
+			fmt.Fprintf(w, "\tprimary:   conflict - more than one primary command station\n")
+			for _, m := range e.primaries {
+				fmt.Fprintf(w, "\t           %s\n", m)
+			}
+		}
+		for _, m := range e.secondaries {
+			fmt.Fprintf(w, "\tsecondary: %s\n", m)
+		}
+		for _, m := range e.excluded {
+			fmt.Fprintf(w, "\texcluded:  %s\n", m)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	"golang.org/x/exp/maps"
+	"gopkg.in/yaml.v3"
+)
+
+// csDoc represents a command station configuration YAML document.
+type csDoc struct {
+	Type              string `yaml:"type"`
+	*devices.CSConfig `yaml:",inline"`
+}
+
+// locoDoc represents a loco configuration YAML document.
+type locoDoc struct {
+	Type                string `yaml:"type"`
+	*devices.LocoConfig `yaml:",inline"`
+}
+
+// writeYaml writes the configuration as YAML documents (command stations first, sorted by name).
+func (c *config) writeYaml(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	csNames := maps.Keys(c.csConfigMap)
+	sort.Strings(csNames)
+	for _, name := range csNames {
+		if err := enc.Encode(&csDoc{Type: devices.CtCS, CSConfig: c.csConfigMap[name]}); err != nil {
+			return err
+		}
+	}
+	locoNames := maps.Keys(c.locoConfigMap)
+	sort.Strings(locoNames)
+	for _, name := range locoNames {
+		if err := enc.Encode(&locoDoc{Type: devices.CtLoco, LocoConfig: c.locoConfigMap[name]}); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// Export formats.
+const (
+	exportYAML = "yaml"
+	exportJSON = "json"
+)
+
+// writeJSON writes the configuration as one JSON document (command stations and locos sorted by name).
+func (c *config) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.syncDoc())
+}
+
+// write writes the configuration in export format.
+func (c *config) write(w io.Writer, format string) error {
+	switch format {
+	case exportYAML:
+		return c.writeYaml(w)
+	case exportJSON:
+		return c.writeJSON(w)
+	default:
+		return fmt.Errorf("invalid export format %s (%s or %s)", format, exportYAML, exportJSON)
+	}
+}
+
+// config returns the currently active device configuration.
+func (s *deviceSets) config() *config {
+	config := newConfig(s.lg)
+	for name, cs := range s.csSet.Items() {
+		config.csConfigMap[name] = cs.Config()
+	}
+	for name, loco := range s.locoSet.Items() {
+		config.locoConfigMap[name] = loco.Config()
+	}
+	return config
+}
+
+// serveConfig serves the currently active device configuration as YAML.
+func (s *deviceSets) serveConfig(w http.ResponseWriter, r *http.Request) {
+	s.serveConfigFormat(w, exportYAML)
+}
+
+// serveConfigQuery serves the currently active device configuration in the format of
+// query parameter format (default YAML).
+func (s *deviceSets) serveConfigQuery(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportYAML
+	}
+	s.serveConfigFormat(w, format)
+}
+
+func (s *deviceSets) serveConfigFormat(w http.ResponseWriter, format string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch format {
+	case exportYAML:
+		w.Header().Set("Content-Type", "application/yaml")
+	case exportJSON:
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		server.Error(w, fmt.Sprintf("invalid format %s (%s or %s)", format, exportYAML, exportJSON), http.StatusBadRequest)
+		return
+	}
+	if err := s.config().write(w, format); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"embed"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -11,13 +12,17 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pico-cs/mqtt-gateway/internal/devices"
 	"github.com/pico-cs/mqtt-gateway/internal/gateway"
 	"github.com/pico-cs/mqtt-gateway/internal/logger"
 	"github.com/pico-cs/mqtt-gateway/internal/server"
 
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 )
@@ -32,11 +37,19 @@ const (
 const (
 	envHTTPHost      = "HTTP-HOST"
 	envHTTPPort      = "HTTP-PORT"
+	envHTTPUsername  = "HTTP-USERNAME"
+	envHTTPPassword  = "HTTP-PASSWORD"
+	envHTTPToken     = "HTTP-TOKEN"
+	envHTTPBasePath  = "HTTP-BASE-PATH"
 	envMQTTTopicRoot = "MQTT-TOPIC-ROOT"
 	envMQTTHost      = "MQTT-HOST"
 	envMQTTPort      = "MQTT-PORT"
 	envMQTTUsername  = "MQTT-USERNAME"
 	envMQTTPassword  = "MQTT-PASSWORD"
+	envMQTTSASKey    = "MQTT-SAS-KEY"
+	envMQTTBrokers   = "MQTT-BROKERS"
+	envMQTTProxy     = "MQTT-PROXY"
+	envMQTTStatus    = "MQTT-STATUS-TOPIC"
 )
 
 func lookupEnv(name, def string) string {
@@ -50,12 +63,64 @@ func addStringVarFlag(p *string, name, env, def, usage string) {
 	flag.StringVar(p, name, lookupEnv(env, def), fmt.Sprintf("%s (environment variable: %s)", usage, env))
 }
 
-var jamlExts = []string{".yaml", ".yml"}
+// parseClasses parses the comma separated class=value lists of the QoS and retain flags.
+func parseClasses(qos, retain string) (map[string]gateway.ClassConfig, error) {
+	classes := map[string]gateway.ClassConfig{}
+	parse := func(s string, fn func(config *gateway.ClassConfig, value string) error) error {
+		if s == "" {
+			return nil
+		}
+		for _, item := range strings.Split(s, ",") {
+			class, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return fmt.Errorf("invalid topic class item %s (class=value)", item)
+			}
+			config := classes[class]
+			if err := fn(&config, value); err != nil {
+				return fmt.Errorf("topic class %s: %s", class, err)
+			}
+			classes[class] = config
+		}
+		return nil
+	}
+	if err := parse(qos, func(config *gateway.ClassConfig, value string) error {
+		v, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return err
+		}
+		b := byte(v)
+		config.QoS = &b
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := parse(retain, func(config *gateway.ClassConfig, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		config.Retain = &v
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// configExts are the file extensions of configuration files. JSON files are parsed by the YAML
+// parser as well (JSON is a subset of YAML 1.2), so that one JSON document per file is supported.
+// TOML files (one document per file) are converted to YAML.
+var configExts = []string{".yaml", ".yml", ".json", tomlExt}
 
 type config struct {
 	lg            logger.Logger
 	csConfigMap   map[string]*devices.CSConfig
 	locoConfigMap map[string]*devices.LocoConfig
+	templates     map[string]*yaml.Node // loco templates by name
+	secret        []byte                // secret of encrypted configuration values
+	conflict      string                // conflict mode
+	origins       map[string]string     // definition locations by <type>/<name>
+	file          string                // file loaded
 }
 
 func newConfig(lg logger.Logger) *config {
@@ -63,126 +128,422 @@ func newConfig(lg logger.Logger) *config {
 		lg:            lg,
 		csConfigMap:   map[string]*devices.CSConfig{},
 		locoConfigMap: map[string]*devices.LocoConfig{},
+		templates:     map[string]*yaml.Node{},
+		conflict:      conflictWarn,
+		origins:       map[string]string{},
 	}
 }
 
-func (c *config) parseYaml(b []byte) error {
-	cd := yaml.NewDecoder(bytes.NewBuffer(b))
-	dd := yaml.NewDecoder(bytes.NewBuffer(b))
+// clone returns a copy of the configuration maps.
+func (c *config) clone() *config {
+	return &config{
+		lg:            c.lg,
+		csConfigMap:   maps.Clone(c.csConfigMap),
+		locoConfigMap: maps.Clone(c.locoConfigMap),
+		templates:     maps.Clone(c.templates),
+		secret:        c.secret,
+		conflict:      c.conflict,
+		origins:       maps.Clone(c.origins),
+	}
+}
+
+func (c *config) parseYaml(b []byte) error { return c.parseYamlInclude(b, nil) }
+
+// parseYamlInclude parses the documents of a configuration file loading included files via include.
+func (c *config) parseYamlInclude(b []byte, include includeFn) error {
+	dec := yaml.NewDecoder(bytes.NewBuffer(b))
 
 	for {
-		var m map[string]any
+		var node yaml.Node
 
-		err := cd.Decode(&m)
+		err := dec.Decode(&node)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			return err
 		}
+		if err := expandEnvNode(&node); err != nil {
+			return err
+		}
+		if err := decryptNode(&node, c.secret); err != nil {
+			return err
+		}
+
+		if len(node.Content) == 0 {
+			continue // empty document
+		}
+		doc := node.Content[0]
+		if doc.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: invalid document - mapping expected", doc.Line)
+		}
 
-		typ, ok := m["type"]
-		if !ok {
-			return fmt.Errorf("invalid document %v - type missing", m)
+		_, typNode := mappingValue(doc, "type")
+		if includeKey, _ := mappingValue(doc, includeField); includeKey != nil && typNode == nil {
+			if include == nil {
+				return fmt.Errorf("line %d: include not supported", includeKey.Line)
+			}
+			var incl includeDoc
+			if err := decodeStrict(doc, &incl); err != nil {
+				return err
+			}
+			for _, pattern := range incl.Include {
+				if err := include(pattern); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if typNode == nil {
+			return fmt.Errorf("line %d: type missing", doc.Line)
 		}
-		if _, ok := m["name"]; !ok {
-			return fmt.Errorf("invalid document %v - name missing", m)
+		_, nameNode := mappingValue(doc, "name")
+		if nameNode == nil {
+			return fmt.Errorf("line %d: name missing", doc.Line)
 		}
 
-		switch typ {
+		switch typNode.Value {
 		case devices.CtCS:
 			csConfig := devices.NewCSConfig()
-			if err := dd.Decode(csConfig); err != nil {
+			if err := decodeStrict(doc, csConfig, "type"); err != nil {
 				return err
 			}
+			if ok, err := c.checkConflict(devices.CtCS, csConfig.Name, nameNode.Line, nil); !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			c.csConfigMap[csConfig.Name] = csConfig
 		case devices.CtLoco:
-			locoConfig := devices.NewLocoConfig()
-			if err := dd.Decode(locoConfig); err != nil {
+			locoConfig, err := decodeLoco(doc, c.templates)
+			if err != nil {
 				return err
 			}
+			if ok, err := c.checkConflict(devices.CtLoco, locoConfig.Name, nameNode.Line, locoConfig); !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			c.locoConfigMap[locoConfig.Name] = locoConfig
+		case ctTemplate:
+			if err := decodeTemplate(doc); err != nil {
+				return err
+			}
+			c.templates[nameNode.Value] = doc
 		default:
-			return fmt.Errorf("invalid configuration %v", m)
+			return fmt.Errorf("line %d: invalid type %s", typNode.Line, typNode.Value)
 		}
 	}
 	return nil
 }
 
+// validate validates the command station and loco configurations.
+func (c *config) validate() error {
+	for _, csConfig := range c.csConfigMap {
+		if err := csConfig.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, locoConfig := range c.locoConfigMap {
+		if err := locoConfig.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJSONDoc decodes a JSON encoded device configuration of type typ.
+func decodeJSONDoc(typ string, b []byte) (any, error) {
+	var config any
+	switch typ {
+	case devices.CtCS:
+		config = devices.NewCSConfig()
+	case devices.CtLoco:
+		config = devices.NewLocoConfig()
+	default:
+		return nil, fmt.Errorf("invalid configuration type %s", typ)
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseJSONDoc parses a JSON encoded device configuration of type typ.
+func (c *config) parseJSONDoc(typ string, b []byte) error {
+	config, err := decodeJSONDoc(typ, b)
+	if err != nil {
+		return err
+	}
+	switch config := config.(type) {
+	case *devices.CSConfig:
+		c.csConfigMap[config.Name] = config
+	case *devices.LocoConfig:
+		c.locoConfigMap[config.Name] = config
+	}
+	return nil
+}
+
+// mqttConfigWait is the quiet period waiting for further retained configuration messages.
+const mqttConfigWait = 2 * time.Second
+
+// loadMQTT loads the device configurations stored as retained messages in topics
+// <topic root>/config/<device type>/<device name>.
+func (c *config) loadMQTT(gw *gateway.Gateway) error {
+	msgs, err := gw.Retained([]string{"config"}, mqttConfigWait)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		topic := strings.Join(msg.TopicStrs, "/")
+		if len(msg.TopicStrs) != 3 {
+			c.lg.Printf("...skipped %s", topic)
+			continue
+		}
+		if err := c.parseJSONDoc(msg.TopicStrs[1], msg.Payload); err != nil {
+			c.lg.Printf("...error loading %s: %s", topic, err)
+			return err
+		}
+		c.lg.Printf("...loaded %s", topic)
+	}
+	return nil
+}
+
 func (c *config) load(fsys fs.FS, path string) error {
+	loaded := map[string]bool{}
 	return fs.WalkDir(fsys, path, func(subPath string, d fs.DirEntry, err error) error {
 		if err != nil || d.IsDir() {
 			return nil
 		}
 
-		if !slices.Contains(jamlExts, filepath.Ext(d.Name())) {
+		if !slices.Contains(configExts, filepath.Ext(d.Name())) {
 			c.lg.Printf("...skipped %s", subPath)
 			return nil
 		}
+		return c.loadFile(fsys, subPath, loaded)
+	})
+}
 
-		b, err := fs.ReadFile(fsys, subPath)
-		if err != nil {
-			c.lg.Printf("...%s %s", subPath, err)
+// loadFile loads a configuration file and the files included by the file. Each file is loaded
+// only once, so that files included before are skipped by the directory scan.
+func (c *config) loadFile(fsys fs.FS, name string, loaded map[string]bool) error {
+	if loaded[name] {
+		c.lg.Printf("...skipped %s (already loaded)", name)
+		return nil
+	}
+	loaded[name] = true
+	defer func(file string) { c.file = file }(c.file)
+	c.file = name
+
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		c.lg.Printf("...%s %s", name, err)
+		return err
+	}
+	if filepath.Ext(name) == tomlExt {
+		if b, err = tomlToYaml(b); err != nil {
+			c.lg.Printf("...error loading %s: %s", name, err)
 			return err
 		}
+	}
 
-		if err := c.parseYaml(b); err != nil {
-			c.lg.Printf("...error loading %s: %s", subPath, err)
+	include := func(pattern string) error {
+		files, err := includeFiles(fsys, name, pattern)
+		if err != nil {
 			return err
 		}
-		c.lg.Printf("...loaded %s", subPath)
+		for _, file := range files {
+			if err := c.loadFile(fsys, file, loaded); err != nil {
+				return err
+			}
+		}
 		return nil
-	})
+	}
+	if err := c.parseYamlInclude(b, include); err != nil {
+		c.lg.Printf("...error loading %s: %s", name, err)
+		return err
+	}
+	c.lg.Printf("...loaded %s", name)
+	return nil
 }
 
-type deviceSets struct {
-	csSet   *devices.CSSet
-	locoSet *devices.LocoSet
-}
+// loadConfig loads the embedded and the external configuration files.
+func loadConfig(lg logger.Logger, externConfigDir string, secret []byte, conflict string) (*config, *remoteConfig, error) {
+	lg.Printf("load embedded configuration files")
+	config := newConfig(lg)
+	config.secret = secret
+	config.conflict = conflict
+	if err := config.load(embedFsys, embedConfigDir); err != nil {
+		return nil, nil, err
+	}
 
-func newDeviceSets(lg logger.Logger, gw *gateway.Gateway) *deviceSets {
-	return &deviceSets{
-		csSet:   devices.NewCSSet(lg, gw),
-		locoSet: devices.NewLocoSet(lg),
+	if externConfigDir == "" {
+		return config, nil, nil
 	}
+
+	lg.Printf("load external configuration files at %s", externConfigDir)
+	externFsys, remote, err := externFS(externConfigDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := config.load(externFsys, "."); err != nil {
+		return nil, nil, err
+	}
+	return config, remote, nil
 }
 
-func (s *deviceSets) close() {
-	s.csSet.Close()
-	s.locoSet.Close()
+// commands defines the gateway sub-commands (no sub-command: run the gateway).
+var commands = map[string]func(lg *log.Logger, args []string) error{
+	"encrypt":  encryptCmd,
+	"explain":  explainCmd,
+	"export":   exportCmd,
+	"import":   importCmd,
+	"lint":     lintCmd,
+	"validate": validateCmd,
+	"version":  versionCmd,
 }
 
-func (s *deviceSets) register(config *config) error {
-	for _, csConfig := range config.csConfigMap {
-		if _, err := s.csSet.Add(csConfig); err != nil {
+func encryptCmd(lg *log.Logger, args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s encrypt [flags] value...\n\nPrints the encrypted configuration values (ENC[...]) to be used in configuration files.\nThe passphrase can be provided via environment variable %s.\n\n", os.Args[0], envConfigPassphrase)
+		fs.PrintDefaults()
+	}
+	configKeyFile := fs.String("configKeyFile", "", "key file encrypting the configuration values")
+	fs.Parse(args)
+
+	secret, err := loadSecret(*configKeyFile)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("configuration key file or passphrase (environment variable %s) required", envConfigPassphrase)
+	}
+	for _, value := range fs.Args() {
+		s, err := encryptValue(secret, value)
+		if err != nil {
 			return err
 		}
+		fmt.Println(s)
+	}
+	return nil
+}
+
+func explainCmd(lg *log.Logger, args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s explain [flags]\n\nPrints the primary and secondary command station resolution of each loco.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	externConfigDir := fs.String("configDir", "", "configuration directory or http(s) URL")
+	configKeyFile := fs.String("configKeyFile", "", "key file decrypting encrypted configuration values (default: passphrase of environment variable "+envConfigPassphrase+")")
+	fs.Parse(args)
+
+	secret, err := loadSecret(*configKeyFile)
+	if err != nil {
+		return err
 	}
-	for _, locoConfig := range config.locoConfigMap {
-		if _, err := s.locoSet.Add(locoConfig); err != nil {
+	config, _, err := loadConfig(lg, *externConfigDir, secret, conflictWarn)
+	if err != nil {
+		return err
+	}
+	return config.explain(os.Stdout)
+}
+
+func exportCmd(lg *log.Logger, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags]\n\nPrints the effective configuration (embedded and external configuration files merged, defaults applied).\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	externConfigDir := fs.String("configDir", "", "configuration directory or http(s) URL")
+	format := fs.String("format", exportYAML, "output format (yaml: YAML documents, json: one JSON document)")
+	configKeyFile := fs.String("configKeyFile", "", "key file decrypting encrypted configuration values (default: passphrase of environment variable "+envConfigPassphrase+")")
+	fs.Parse(args)
+
+	secret, err := loadSecret(*configKeyFile)
+	if err != nil {
+		return err
+	}
+	config, _, err := loadConfig(lg, *externConfigDir, secret, conflictWarn)
+	if err != nil {
+		return err
+	}
+	return config.write(os.Stdout, *format)
+}
+
+func importCmd(lg *log.Logger, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] file...\n\nPrints the loco configurations imported from the files as YAML documents.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", importZ21, "import file format (z21: z21 app export file .z21 or .z21loco)")
+	fs.Parse(args)
+
+	if *format != importZ21 {
+		return fmt.Errorf("invalid import format %s", *format)
+	}
+	config := newConfig(lg)
+	for _, filename := range fs.Args() {
+		locoConfigs, err := importZ21Locos(filename)
+		if err != nil {
 			return err
 		}
-	}
-	for _, cs := range s.csSet.Items() {
-		for _, loco := range s.locoSet.Items() {
-			if _, err := cs.AddLoco(loco); err != nil {
-				return err
+		for _, locoConfig := range locoConfigs {
+			if err := locoConfig.Validate(); err != nil {
+				return fmt.Errorf("%s: %s", filename, err)
 			}
+			config.locoConfigMap[locoConfig.Name] = locoConfig
 		}
 	}
-	return nil
+	return config.writeYaml(os.Stdout)
 }
 
-func (s *deviceSets) registerHTTP(server *server.Server) {
-	server.HandleFunc("/", devices.HTTPHandler)
-	server.Handle("/cs", s.csSet)
-	server.Handle("/loco", s.locoSet)
-	for name, cs := range s.csSet.Items() {
-		server.Handle(fmt.Sprintf("/cs/%s", name), cs)
+func lintCmd(lg *log.Logger, args []string) error { return lintConfig("lint", lg, args) }
+
+// validateCmd is an alias of the lint sub-command.
+func validateCmd(lg *log.Logger, args []string) error { return lintConfig("validate", lg, args) }
+
+// lintConfig checks the configuration files without connecting to the MQTT broker or command stations.
+func lintConfig(name string, lg *log.Logger, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\nChecks the embedded and external configuration files and reports findings (exit code 1 on errors).\n\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+	externConfigDir := fs.String("configDir", "", "configuration directory or http(s) URL")
+	format := fs.String("format", lintFormatText, "output format (text, json)")
+	configKeyFile := fs.String("configKeyFile", "", "key file decrypting encrypted configuration values (default: passphrase of environment variable "+envConfigPassphrase+")")
+	fs.Parse(args)
+
+	secret, err := loadSecret(*configKeyFile)
+	if err != nil {
+		return err
 	}
-	for name, loco := range s.locoSet.Items() {
-		server.Handle(fmt.Sprintf("/loco/%s", name), loco)
+	l := newLinter()
+	l.secret = secret
+	if err := l.lint(embedFsys, embedConfigDir); err != nil {
+		return err
 	}
+	if *externConfigDir != "" {
+		externFsys, _, err := externFS(*externConfigDir)
+		if err != nil {
+			return err
+		}
+		if err := l.lint(externFsys, "."); err != nil {
+			return err
+		}
+	}
+	if err := l.write(os.Stdout, *format); err != nil {
+		return err
+	}
+	if l.hasErrors() {
+		os.Exit(1)
+	}
+	return nil
 }
 
 func main() {
@@ -195,47 +556,200 @@ func main() {
 		}
 	}
 
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			check(cmd(lg, os.Args[2:]))
+			return
+		}
+	}
+
 	httpConfig := &server.Config{}
 	mqttConfig := &gateway.Config{}
+	csSetConfig := devices.NewCSSetConfig()
 
 	addStringVarFlag(&httpConfig.Host, "httpHost", envHTTPHost, server.DefaultHost, "HTTP host")
 	addStringVarFlag(&httpConfig.Port, "httpPort", envHTTPPort, server.DefaultPort, "HTTP port")
+	addStringVarFlag(&httpConfig.Username, "httpUsername", envHTTPUsername, "", "HTTP basic authentication user name (empty: no basic authentication)")
+	addStringVarFlag(&httpConfig.Password, "httpPassword", envHTTPPassword, "", "HTTP basic authentication password")
+	addStringVarFlag(&httpConfig.Token, "httpToken", envHTTPToken, "", "HTTP API token (empty: no API token authentication)")
+	addStringVarFlag(&httpConfig.BasePath, "httpBasePath", envHTTPBasePath, "", "path prefix of all HTTP endpoints for reverse proxy path based routing (e.g. /pico-cs - empty: none)")
+	flag.BoolVar(&httpConfig.AccessLog, "httpAccessLog", false, "log each HTTP request (method, path, status, duration, response size and remote address)")
+	var httpOrigins string
+	flag.StringVar(&httpOrigins, "httpOrigins", "", "comma separated list of origin hosts (host[:port]) allowed to send state changing requests and to connect to the WebSocket endpoint besides the gateway host (empty: same origin only)")
+	flag.StringVar(&httpConfig.WebRoot, "webRoot", "", "directory of static files (e.g. custom control panels) served at / instead of the index page")
 	addStringVarFlag(&mqttConfig.TopicRoot, "mqttTopicRoot", envMQTTTopicRoot, gateway.DefaultTopicRoot, "MQTT topic root")
 	addStringVarFlag(&mqttConfig.Host, "mqttHost", envMQTTHost, gateway.DefaultHost, "MQTT host")
 	addStringVarFlag(&mqttConfig.Port, "mqttPort", envMQTTPort, gateway.DefaultPort, "MQTT port")
 	addStringVarFlag(&mqttConfig.Username, "mqttUsername", envMQTTUsername, "", "MQTT username")
 	addStringVarFlag(&mqttConfig.Password, "mqttPassword", envMQTTPassword, "", "MQTT password")
-
-	externConfigDir := flag.String("configDir", "", "configuration directory")
+	addStringVarFlag(&mqttConfig.Proxy, "mqttProxy", envMQTTProxy, "", "MQTT proxy URL (http://[user:password@]host:port for HTTP CONNECT or socks5://[user:password@]host:port)")
+	addStringVarFlag(&mqttConfig.StatusTopic, "mqttStatusTopic", envMQTTStatus, "", "MQTT availability topic below the topic root (e.g. gateway/status) published retained as online and set as last will offline (empty: disabled)")
+	flag.BoolVar(&mqttConfig.MQTT5, "mqtt5", false, "connect via MQTT 5 instead of MQTT 3.1.1 (enables message expiry, user properties and command responses to MQTT 5 response topics)")
+	flag.DurationVar(&mqttConfig.MessageExpiry, "mqttMessageExpiry", 0, "MQTT 5 message expiry interval of published messages (0: no expiry)")
+	var mqttUserProperties string
+	flag.StringVar(&mqttUserProperties, "mqttUserProperties", "", "comma separated list of MQTT 5 user properties (key=value) added to published messages")
+	var mqttQoS, mqttRetain string
+	flag.StringVar(&mqttQoS, "mqttQoS", "", "comma separated list of MQTT QoS by topic class (class=qos, classes: cs, loco, error, io - e.g. io=0)")
+	flag.StringVar(&mqttRetain, "mqttRetain", "", "comma separated list of MQTT retain flags by topic class (class=true|false, classes: cs, loco, error, io - e.g. io=false)")
+	var mqttBrokers string
+	addStringVarFlag(&mqttBrokers, "mqttBrokers", envMQTTBrokers, "", "comma separated list of MQTT broker addresses (host[:port] or URL) tried in order - overwrites MQTT host and port")
+
+	flag.DurationVar(&mqttConfig.PublishFlush, "mqttPublishFlush", 0, "flush interval batching MQTT messages (0: publish each message immediately)")
+	flag.StringVar(&mqttConfig.ClientID, "mqttClientID", "", "MQTT client id (required by cloud profiles - Azure IoT Hub: device id)")
+	flag.StringVar(&mqttConfig.Profile, "mqttProfile", gateway.ProfileNone, "MQTT broker compatibility profile (aws: AWS IoT Core, azure: Azure IoT Hub)")
+	flag.StringVar(&mqttConfig.TLSCAFile, "mqttTLSCA", "", "MQTT TLS CA certificate file (PEM)")
+	flag.StringVar(&mqttConfig.TLSCertFile, "mqttTLSCert", "", "MQTT TLS client certificate file (PEM)")
+	flag.StringVar(&mqttConfig.TLSKeyFile, "mqttTLSKey", "", "MQTT TLS client key file (PEM)")
+	addStringVarFlag(&mqttConfig.SASKey, "mqttSASKey", envMQTTSASKey, "", "Azure IoT Hub device shared access key (base64)")
+	flag.DurationVar(&mqttConfig.SASTokenTTL, "mqttSASTokenTTL", gateway.DefaultSASTokenTTL, "validity of the generated Azure IoT Hub SAS tokens")
+	flag.StringVar(&mqttConfig.SparkplugGroup, "sparkplugGroup", "", "Sparkplug B group id publishing the device metrics as Sparkplug B edge node (empty: disabled)")
+	flag.StringVar(&mqttConfig.SparkplugNode, "sparkplugNode", "", "Sparkplug B edge node id (default: MQTT topic root)")
+
+	externConfigDir := flag.String("configDir", "", "configuration directory or http(s) URL (tarball or directory index)")
+	configKeyFile := flag.String("configKeyFile", "", "key file decrypting encrypted configuration values (default: passphrase of environment variable "+envConfigPassphrase+")")
+	configWatch := flag.Bool("configWatch", false, "watch the local configuration directory and apply configuration file changes at runtime")
+	configRefresh := flag.Duration("configRefresh", 0, "refresh interval checking remote configuration changes (ETag based, 0: disabled)")
+	configConflict := flag.String("configConflict", conflictWarn, "handling of devices defined more than once and locos sharing an address (warn: last definition wins, error or skip: first definition wins)")
+	configMQTT := flag.Bool("configMQTT", false, "load device configurations from retained <topic root>/config/<device type>/<device name> topics")
+	flag.StringVar(&csSetConfig.StopOnClose, "stopOnClose", devices.StopNone, "stop all primary locos on gateway shutdown (none, emergency or ramp)")
+	flag.DurationVar(&csSetConfig.StopRamp, "stopRamp", devices.DefaultStopRamp, "duration of a ramped loco stop")
+	flag.UintVar(&csSetConfig.MaxSpeedRate, "maxSpeedRate", 0, "default maximum loco speed change in speed steps per second (0: unlimited)")
+	flag.DurationVar(&csSetConfig.WatchdogTimeout, "watchdogTimeout", 0, "MQTT broker connection loss duration after which the watchdog stops the locos (0: disabled)")
+	flag.StringVar(&csSetConfig.WatchdogStop, "watchdogStop", devices.StopNone, "stop mode applied by the watchdog to all locos (none, emergency or ramp)")
+	flag.BoolVar(&csSetConfig.WatchdogPowerOff, "watchdogPowerOff", false, "disable track power by the watchdog")
+	flag.DurationVar(&csSetConfig.PingInterval, "pingInterval", devices.DefaultPingInterval, "command station keepalive ping interval (0: disabled)")
+	flag.DurationVar(&csSetConfig.StatsInterval, "statsInterval", devices.DefaultStatsInterval, "command statistics publishing interval (0: disabled)")
+	flag.DurationVar(&csSetConfig.AlertOfflineTimeout, "alertOfflineTimeout", 0, "command station offline duration raising an alert (0: disabled)")
+	flag.Float64Var(&csSetConfig.AlertTempMax, "alertTempMax", 0, "command station temperature in degree Celsius above which an alert is raised (0: disabled)")
+	flag.UintVar(&csSetConfig.AlertQueueLevel, "alertQueueLevel", 0, "command queue fill level in percent raising an alert (0: disabled)")
+	flag.DurationVar(&csSetConfig.AlertInterval, "alertInterval", devices.DefaultAlertInterval, "interval checking the temperature and command queue alert rules")
+	flag.BoolVar(&csSetConfig.Failover, "failover", false, "promote an available secondary command station of the locos of an unavailable primary command station")
+	flag.DurationVar(&csSetConfig.GetCacheTTL, "getCacheTTL", 0, "duration the results of get commands are cached serving repeated gets from cache (0: disabled)")
+	odometerFile := flag.String("odometerFile", "", "file the loco odometers are persisted to (empty: not persisted)")
+	odometerInterval := flag.Duration("odometerInterval", devices.DefaultOdometerInterval, "interval publishing and persisting the loco odometers (0: disabled)")
+	mdns := flag.Bool("mdns", false, "advertise the HTTP API via mDNS (zeroconf) on the LAN")
+	mdnsInstance := flag.String("mdnsInstance", server.DefaultInstance, "mDNS service instance name")
+	webhookConfig := flag.String("webhookConfig", "", "YAML file configuring webhooks triggered by alerts and events (empty: no webhooks)")
+	exportConfig := flag.Bool("exportConfig", false, "write the loaded device configuration as YAML to stdout and exit")
+	syncMode := flag.String("syncMode", syncNone, "roster synchronization mode (primary: publish the device configuration, backup: mirror it to syncDir)")
+	syncDir := flag.String("syncDir", ".", "directory the backup writes the synchronized roster to (take over: use it as configDir)")
 
 	flag.Parse()
 
+	lg.Printf("pico-cs mqtt-gateway %s", newBuildInfo())
+
+	if httpOrigins != "" {
+		httpConfig.Origins = strings.Split(httpOrigins, ",")
+	}
+	if mqttBrokers != "" {
+		mqttConfig.Brokers = strings.Split(mqttBrokers, ",")
+	}
+	if mqttUserProperties != "" {
+		mqttConfig.UserProperties = map[string]string{}
+		for _, item := range strings.Split(mqttUserProperties, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				check(fmt.Errorf("invalid MQTT user property %s (key=value)", item))
+			}
+			mqttConfig.UserProperties[key] = value
+		}
+	}
+	classes, err := parseClasses(mqttQoS, mqttRetain)
+	check(err)
+	mqttConfig.Classes = classes
+	check(checkSyncMode(*syncMode))
+	secret, err := loadSecret(*configKeyFile)
+	check(err)
+	check(validateConflictMode(*configConflict))
+	check(httpConfig.Validate())
+
 	gw, err := gateway.New(lg, mqttConfig)
 	check(err)
 	defer gw.Close()
 
+	if *webhookConfig != "" {
+		webhooks, err := loadWebhooks(lg, *webhookConfig, secret)
+		check(err)
+		defer webhooks.close()
+		gw.OnPublish(webhooks.notify)
+	}
+
+	if *syncMode == syncBackup {
+		lg.Printf("backup mode: mirror roster to %s", *syncDir)
+		mirror := newRosterMirror(lg, gw, *syncDir)
+		mirror.start()
+		defer mirror.close()
+		check(gw.Listen())
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		<-sig
+		return
+	}
+
+	// mDNS advertisement
+	var advertiser *server.Advertiser
+	if *mdns {
+		advertiser = server.NewAdvertiser(lg, *mdnsInstance)
+		defer advertiser.Close()
+	}
+
 	// http server
 	server := server.New(lg, httpConfig)
 	defer server.Close()
 
-	lg.Printf("load embedded configuration files")
-	config := newConfig(lg)
-	check(config.load(embedFsys, embedConfigDir))
-
-	if *externConfigDir != "" {
-		lg.Printf("load external configuration files at %s", *externConfigDir)
-		externFsys := os.DirFS(*externConfigDir)
-		check(config.load(externFsys, "."))
+	config, remote, err := loadConfig(lg, *externConfigDir, secret, *configConflict)
+	check(err)
+	fileConfig := config.clone()
+	if *configMQTT {
+		lg.Printf("load configurations from retained MQTT topics")
+		check(config.loadMQTT(gw))
+	}
+	if *exportConfig {
+		check(config.writeYaml(os.Stdout))
+		return
 	}
 
 	// register devices
-	deviceSets := newDeviceSets(lg, gw)
+	deviceSets, err := newDeviceSets(lg, gw, csSetConfig)
+	check(err)
+	deviceSets.syncMode = *syncMode
+	gw.OnPublish(deviceSets.state.update)
 	defer deviceSets.close()
+	if *odometerInterval > 0 {
+		check(deviceSets.locoSet.TrackOdometers(*odometerFile, *odometerInterval))
+	}
 	check(deviceSets.register(config))
+	gw.Publish(infoTopicStrs, true, newBuildInfo())
+	reloader := newConfigReloader(lg, deviceSets, secret, fileConfig)
+	if remote != nil && *configRefresh > 0 {
+		remote.watch(lg, *configRefresh, func(fsys fs.FS) {
+			lg.Printf("remote configuration %s changed", *externConfigDir)
+			reloader.reload(fsys)
+		})
+	}
+	if *configWatch {
+		if remote != nil || *externConfigDir == "" {
+			check(fmt.Errorf("configWatch requires a local configuration directory (parameter configDir)"))
+		}
+		check(reloader.watchDir(*externConfigDir))
+	}
 	deviceSets.registerHTTP(server)
+	server.HandleFunc("/debug/subscriptions", gw.ServeSubscriptions)
+	server.HandleFunc("/version", serveVersion)
+	wsHub := newWSHub(lg, gw, httpConfig)
+	defer wsHub.Close()
+	server.Handle("/ws", wsHub)
+	sseHub := newSSEHub(lg, gw)
+	defer sseHub.Close()
+	server.Handle("/events", sseHub)
 
 	// start http server listen and serve
 	check(server.ListenAndServe())
+	if advertiser != nil {
+		check(advertiser.AdvertiseHTTP(server.Addr(), []string{"path=" + httpConfig.BasePath + "/", "topicRoot=" + mqttConfig.TopicRoot}))
+	}
 
 	// start gateway listening
 	check(gw.Listen())
@@ -1,8 +1,21 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
 )
 
 type loggerWrapper struct {
@@ -23,12 +36,667 @@ func testLoad(t *testing.T) {
 	}
 }
 
+func testExplain(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	config := newConfig(logger)
+	externFsys := os.DirFS("config_examples")
+	if err := config.load(externFsys, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := config.explainLoco("br18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.primaries) != 1 || e.primaries[0].csName != "cs02" {
+		t.Fatalf("invalid primaries %v - expected cs02", e.primaries)
+	}
+	if len(e.secondaries) != 1 || e.secondaries[0].csName != "cs01" {
+		t.Fatalf("invalid secondaries %v - expected cs01", e.secondaries)
+	}
+}
+
+func testLint(t *testing.T) {
+	l := newLinter()
+	if err := l.lint(os.DirFS("config_examples"), "."); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.findings) != 0 {
+		t.Fatalf("unexpected findings %v", l.findings)
+	}
+
+	l.lintYaml("test.yaml", []byte("type: loco\nname: br01\nadrr: 3\n"))
+	rules := []string{}
+	for _, f := range l.findings {
+		rules = append(rules, f.Rule)
+	}
+	if !reflect.DeepEqual(rules, []string{ruleUnknownField, ruleDuplicateName}) {
+		t.Fatalf("invalid rules %v", rules)
+	}
+	if l.findings[0].Line != 3 {
+		t.Fatalf("invalid line %d - expected 3", l.findings[0].Line)
+	}
+}
+
+func testExport(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	config := newConfig(logger)
+	if err := config.load(os.DirFS("config_examples"), "."); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := config.writeYaml(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := newConfig(logger)
+	if err := exported.parseYaml(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.csConfigMap, exported.csConfigMap) || !reflect.DeepEqual(config.locoConfigMap, exported.locoConfigMap) {
+		t.Fatalf("exported configuration differs\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := config.write(&buf, exportJSON); err != nil {
+		t.Fatal(err)
+	}
+	var doc syncDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := doc.config(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.csConfigMap, exported.csConfigMap) || !reflect.DeepEqual(config.locoConfigMap, exported.locoConfigMap) {
+		t.Fatalf("exported JSON configuration differs\n%s", buf.String())
+	}
+}
+
+func testBackup(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	config := newConfig(logger)
+	if err := config.load(os.DirFS("config_examples"), "."); err != nil {
+		t.Fatal(err)
+	}
+
+	deviceSets, err := newDeviceSets(logger, nil, nil) // locos only - no command station connection
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, locoConfig := range config.locoConfigMap {
+		if err := deviceSets.addLoco(locoConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	deviceSets.serveBackup(w, httptest.NewRequest(http.MethodGet, "/backup", nil))
+
+	fsys, err := readTar(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(fsys, backupConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newConfig(logger)
+	if err := restored.parseYaml(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.locoConfigMap, restored.locoConfigMap) {
+		t.Fatalf("backup configuration differs\n%s", b)
+	}
+	if _, err := fs.ReadFile(fsys, backupStateFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRestore(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	deviceSets, err := newDeviceSets(logger, nil, nil) // no command station connection
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"br01", "br02", "br03"} {
+		locoConfig := devices.NewLocoConfig()
+		locoConfig.Name = name
+		if err := deviceSets.addLoco(locoConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := newConfig(logger)
+	if err := config.parseYaml([]byte("type: loco\nname: br01\naddr: 1\n---\ntype: loco\nname: br04\naddr: 4\n---\ntype: cs\nname: cs01\nport: /dev/notexisting\n")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := deviceSets.restore(config)
+	if err == nil {
+		t.Fatal("command station connection error not detected")
+	}
+	if !reflect.DeepEqual(result.Removed, []string{"loco/br02", "loco/br03"}) || len(result.Set) != 0 {
+		t.Fatalf("invalid applied devices %s", result)
+	}
+}
+
+func testLoadRemote(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("config_examples")))
+	defer ts.Close()
+
+	fsys, remote, err := externFS(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := newConfig(logger)
+	if err := config.load(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.csConfigMap) != 2 || len(config.locoConfigMap) != 2 {
+		t.Fatalf("invalid number of configurations cs %d loco %d - expected 2 each", len(config.csConfigMap), len(config.locoConfigMap))
+	}
+	if _, err := remote.fetch(); remote.etag != "" && err != errNotModified {
+		t.Fatalf("expected error %s - got %v", errNotModified, err)
+	}
+}
+
+func testLoadZip(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("roster/br01.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("type: loco\nname: br01\naddr: 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	fsys, _, err := externFS(ts.URL + "/roster.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := newConfig(logger)
+	if err := config.load(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.locoConfigMap["br01"]; !ok {
+		t.Fatal("loco br01 not loaded")
+	}
+}
+
+func testSync(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	config := newConfig(logger)
+	if err := config.load(os.DirFS("config_examples"), "."); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	m := newRosterMirror(logger, nil, dir)
+	if _, err := m.write(config.syncDoc()); err != nil {
+		t.Fatal(err)
+	}
+
+	synced := newConfig(logger)
+	if err := synced.load(os.DirFS(dir), "."); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.csConfigMap, synced.csConfigMap) || !reflect.DeepEqual(config.locoConfigMap, synced.locoConfigMap) {
+		t.Fatal("synchronized configuration differs")
+	}
+}
+
+func testSyncPartial(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	// retained document of another gateway version missing the default fields
+	var doc syncDoc
+	if err := json.Unmarshal([]byte(`{"cs": [{"name": "cs01", "port": "/dev/ttyACM0"}, {"name": "cs02", "port": "/dev/ttyACM1", "primary": null, "power": null}], "loco": [{"name": "br01", "addr": 1}]}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	config, err := doc.config(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, csConfig := range config.csConfigMap {
+		if csConfig.Primary == nil || csConfig.Secondary == nil || csConfig.Power == nil {
+			t.Fatalf("command station %s: missing defaults %+v", name, csConfig)
+		}
+	}
+	if locoConfig := config.locoConfigMap["br01"]; locoConfig == nil || locoConfig.Fcts == nil {
+		t.Fatalf("loco br01: missing defaults %+v", locoConfig)
+	}
+
+	if err := json.Unmarshal([]byte(`{"cs": [42]}`), &doc); err == nil {
+		t.Fatal("invalid command station document not detected")
+	}
+}
+
+func testEncrypted(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	secret := []byte("passphrase")
+	host, err := encryptValue(secret, "10.10.10.42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := encryptValue(secret, "4242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := []byte("type: cs\nname: cs01\nhost: " + host + "\nport: " + port + "\n")
+
+	if err := newConfig(logger).parseYaml(doc); err == nil {
+		t.Fatal("missing secret not detected")
+	}
+
+	config := newConfig(logger)
+	config.secret = secret
+	if err := config.parseYaml(doc); err != nil {
+		t.Fatal(err)
+	}
+	if csConfig := config.csConfigMap["cs01"]; csConfig.Host != "10.10.10.42" || csConfig.Port != "4242" {
+		t.Fatalf("invalid decrypted host %s port %s", csConfig.Host, csConfig.Port)
+	}
+
+	config = newConfig(logger)
+	config.secret = []byte("wrong")
+	if err := config.parseYaml(doc); err == nil {
+		t.Fatal("invalid secret not detected")
+	}
+}
+
+func testWebhook(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	bodyCh := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodyCh <- string(b)
+	}))
+	defer ts.Close()
+
+	w, err := newWebhooks(logger, []*webhookConfig{{URL: ts.URL, Events: []string{"alert", "cs/+/mte"}, Template: `{{.Topic}} {{.Value.severity}}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	w.notify([]string{"loco", "br01", "speed"}, 42) // no match
+	w.notify([]string{"alert"}, map[string]any{"severity": "critical"})
+	if body := <-bodyCh; body != "alert critical" {
+		t.Fatalf("invalid body %s", body)
+	}
+}
+
+func testLoadFormats(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	files := map[string]string{
+		"br01.json": `{"type": "loco", "name": "br01", "addr": 1, "maxSpeed": 100, "fcts": {"light": {"no": 0}}}`,
+		"br02.toml": "type = \"loco\"\nname = \"br02\"\naddr = 2\nmaxSpeed = 100\n\n[fcts.light]\nno = 0\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := newConfig(logger)
+	if err := config.load(os.DirFS(dir), "."); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"br01", "br02"} {
+		locoConfig, ok := config.locoConfigMap[name]
+		if !ok {
+			t.Fatalf("loco %s not loaded", name)
+		}
+		if locoConfig.Addr != uint(i+1) || locoConfig.MaxSpeed != 100 || locoConfig.Fcts["light"].No != 0 {
+			t.Fatalf("invalid loco configuration %v", locoConfig)
+		}
+	}
+}
+
+func testReload(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	prev := newConfig(logger)
+	if err := prev.parseYaml([]byte("type: loco\nname: br01\naddr: 1\n---\ntype: loco\nname: br02\naddr: 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	deviceSets, err := newDeviceSets(logger, nil, nil) // locos only - no command station connection
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, locoConfig := range prev.locoConfigMap {
+		if err := deviceSets.addLoco(locoConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime := newConfig(logger) // added at runtime (e.g. via MQTT)
+	if err := runtime.parseYaml([]byte("type: loco\nname: br03\naddr: 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := deviceSets.addLoco(runtime.locoConfigMap["br03"]); err != nil {
+		t.Fatal(err)
+	}
+
+	config := newConfig(logger)
+	if err := config.parseYaml([]byte("type: loco\nname: br01\naddr: 10\n---\ntype: loco\nname: br04\naddr: 4\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := deviceSets.reload(prev, config); err != nil {
+		t.Fatal(err)
+	}
+	// applying the changes again (e.g. after a failed reload) skips the removed devices
+	if err := deviceSets.reload(prev, config); err != nil {
+		t.Fatal(err)
+	}
+
+	names := deviceSets.deviceNames().Loco
+	if !reflect.DeepEqual(names, []string{"br01", "br03", "br04"}) {
+		t.Fatalf("invalid locos %v", names)
+	}
+	if addr := deviceSets.locoSet.Items()["br01"].Config().Addr; addr != 10 {
+		t.Fatalf("invalid address %d - expected 10", addr)
+	}
+}
+
+func testEnv(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	t.Setenv("CS_HOST", "192.168.1.10")
+	t.Setenv("CS_PORT", "")
+
+	config := newConfig(logger)
+	if err := config.parseYaml([]byte("type: cs\nname: cs01\nhost: ${CS_HOST}\nport: ${CS_PORT:-4242}\n")); err != nil {
+		t.Fatal(err)
+	}
+	csConfig := config.csConfigMap["cs01"]
+	if csConfig.Host != "192.168.1.10" || csConfig.Port != "4242" {
+		t.Fatalf("invalid command station configuration %v", csConfig)
+	}
+
+	if err := newConfig(logger).parseYaml([]byte("type: cs\nname: cs02\nhost: ${CS_UNDEFINED}\n")); err == nil {
+		t.Fatal("undefined environment variable not detected")
+	}
+}
+
+func testTemplate(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	const doc = `type: template
+name: steam
+maxSpeed: 100
+fcts:
+  light:
+    no: 0
+  whistle:
+    no: 2
+---
+type: loco
+name: br01
+addr: 1
+template: steam
+maxSpeed: 80
+fcts:
+  whistle:
+    no: 3
+`
+	config := newConfig(logger)
+	if err := config.parseYaml([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	locoConfig := config.locoConfigMap["br01"]
+	if locoConfig.MaxSpeed != 80 || locoConfig.Fcts["light"].No != 0 || locoConfig.Fcts["whistle"].No != 3 {
+		t.Fatalf("invalid loco configuration %v", locoConfig)
+	}
+
+	l := newLinter()
+	l.lintYaml("test.yaml", []byte(doc+"---\ntype: loco\nname: br02\naddr: 2\ntemplate: diesel\n"))
+	if len(l.findings) != 1 || l.findings[0].Rule != ruleTemplate {
+		t.Fatalf("invalid findings %v", l.findings)
+	}
+}
+
+func testInclude(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	files := map[string]string{
+		"a.yaml":              "include: [common/*.yaml]\n---\ntype: loco\nname: br01\naddr: 10\ntemplate: steam\n",
+		"common/steam.yaml":   "type: template\nname: steam\nmaxSpeed: 100\n",
+		"common/roster.yaml":  "type: loco\nname: br01\naddr: 1\n",
+		"common/readme.txt":   "not included",
+		"common/nested/x.yml": "type: loco\nname: br02\naddr: 2\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := os.DirFS(dir)
+
+	config := newConfig(logger)
+	if err := config.load(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	if locoConfig := config.locoConfigMap["br01"]; locoConfig.Addr != 10 || locoConfig.MaxSpeed != 100 {
+		t.Fatalf("invalid loco configuration %v", locoConfig)
+	}
+	if _, ok := config.locoConfigMap["br02"]; !ok {
+		t.Fatal("loco br02 not loaded")
+	}
+
+	l := newLinter()
+	if err := l.lint(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range l.findings {
+		if f.Rule != ruleDuplicateName { // br01 is redefined
+			t.Fatalf("unexpected finding %s", f)
+		}
+	}
+}
+
+func testStrict(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	tests := []struct {
+		doc  string
+		line string
+	}{
+		{"type: loco\nname: br01\nadrr: 3\n", "line 3: unknown field adrr"},
+		{"type: loco\nname: br01\nfcts:\n  light:\n    nr: 0\n", "line 5: unknown field nr"},
+		{"type: loco\nname: br01\naddr: three\n", "line 3:"},
+		{"type: loco\naddr: 3\n", "line 1: name missing"},
+		{"name: br01\naddr: 3\n", "line 1: type missing"},
+	}
+	for _, test := range tests {
+		err := newConfig(logger).parseYaml([]byte(test.doc))
+		if err == nil || !strings.Contains(err.Error(), test.line) {
+			t.Fatalf("document %q: expected error %q - got %v", test.doc, test.line, err)
+		}
+	}
+}
+
+func testConflict(t *testing.T) {
+	logger := &loggerWrapper{T: t}
+
+	docs := []string{
+		"type: loco\nname: br01\naddr: 1\n---\ntype: loco\nname: br01\naddr: 2\n", // duplicate name
+		"type: loco\nname: br01\naddr: 1\n---\ntype: loco\nname: br02\naddr: 1\n", // duplicate address
+	}
+	tests := []struct {
+		mode  string
+		err   bool
+		addrs []uint // addresses of br01 and br02 (0: not loaded)
+	}{
+		{conflictWarn, false, []uint{2, 1}},
+		{conflictError, true, nil},
+		{conflictSkip, false, []uint{1, 0}},
+	}
+	for _, test := range tests {
+		for i, doc := range docs {
+			config := newConfig(logger)
+			config.conflict = test.mode
+			err := config.parseYaml([]byte(doc))
+			if test.err {
+				if err == nil {
+					t.Fatalf("mode %s document %d: conflict not detected", test.mode, i)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := []string{"br01", "br02"}[i]
+			var addr uint
+			if locoConfig, ok := config.locoConfigMap[name]; ok {
+				addr = locoConfig.Addr
+			}
+			if addr != test.addrs[i] {
+				t.Fatalf("mode %s document %d: invalid address %d - expected %d", test.mode, i, addr, test.addrs[i])
+			}
+		}
+	}
+}
+
+func testImportZ21(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, z21DBFile)
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE vehicles (id INTEGER PRIMARY KEY, name TEXT, type INTEGER, address INTEGER, max_speed INTEGER)",
+		"CREATE TABLE functions (id INTEGER PRIMARY KEY, vehicle_id INTEGER, function INTEGER, image_name TEXT)",
+		"INSERT INTO vehicles VALUES (1, 'BR 218 001', 0, 218, 140), (2, 'Wagon', 1, 0, 0)",
+		"INSERT INTO functions (vehicle_id, function, image_name) VALUES (1, 0, 'light'), (1, 1, 'sound'), (1, 2, 'main_beam'), (1, 3, '')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	b, err := os.ReadFile(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportFile := filepath.Join(dir, "br218.z21loco")
+	f, err := os.Create(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("export/1/" + z21DBFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	locoConfigs, err := importZ21Locos(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locoConfigs) != 1 {
+		t.Fatalf("invalid number of locos %d - expected 1", len(locoConfigs))
+	}
+	locoConfig := locoConfigs[0]
+	fcts := map[string]devices.LocoFctConfig{"light": {No: 0}, "sound": {No: 1}, "light2": {No: 2}, "f3": {No: 3}}
+	if locoConfig.Name != "br-218-001" || locoConfig.Addr != 218 || locoConfig.ScaleSpeed != 140 || !reflect.DeepEqual(locoConfig.Fcts, fcts) {
+		t.Fatalf("invalid loco configuration %v", locoConfig)
+	}
+}
+
+func testOpenAPI(t *testing.T) {
+	var spec struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/loco/{loco}", "/loco/{loco}/drive", "/cs/{cs}", "/config", "/readyz", "/state", "/version"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Fatalf("path %s not specified", path)
+		}
+	}
+}
+
+func testState(t *testing.T) {
+	state := newStateTracker()
+	state.update([]string{"cs", "cs01", "enabled"}, true)
+	state.update([]string{"cs", "cs01", "gpio1", "turnout"}, "thrown")
+	state.update([]string{"cs", "cs01", "enabled"}, false)
+	state.update([]string{"loco", "br01", "speed"}, 42)
+
+	want := map[string]any{"enabled": false, "gpio1/turnout": "thrown"}
+	if got := state.csState("cs01"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid command station state %v - expected %v", got, want)
+	}
+	if got := state.csState("br01"); len(got) != 0 {
+		t.Fatalf("unexpected command station state %v", got)
+	}
+}
+
 func TestConfig(t *testing.T) {
 	tests := []struct {
 		name string
 		fct  func(t *testing.T)
 	}{
 		{"load", testLoad},
+		{"explain", testExplain},
+		{"lint", testLint},
+		{"export", testExport},
+		{"backup", testBackup},
+		{"restore", testRestore},
+		{"loadRemote", testLoadRemote},
+		{"loadZip", testLoadZip},
+		{"sync", testSync},
+		{"syncPartial", testSyncPartial},
+		{"webhook", testWebhook},
+		{"encrypted", testEncrypted},
+		{"reload", testReload},
+		{"loadFormats", testLoadFormats},
+		{"env", testEnv},
+		{"template", testTemplate},
+		{"include", testInclude},
+		{"strict", testStrict},
+		{"conflict", testConflict},
+		{"importZ21", testImportZ21},
+		{"openAPI", testOpenAPI},
+		{"state", testState},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// readyDoc represents the readiness details of the gateway.
+type readyDoc struct {
+	Ready  bool            `json:"ready"`
+	Broker bool            `json:"broker"` // connected to the broker
+	CS     map[string]bool `json:"cs"`     // command station availability by name
+}
+
+func writeHealthDoc(w http.ResponseWriter, ok bool, doc any) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+// serveHealth reports that the gateway process is alive.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealthDoc(w, true, map[string]string{"status": "ok"})
+}
+
+// serveReady reports whether the gateway is connected to the broker and all command stations are
+// available (status 503 otherwise).
+func (s *deviceSets) serveReady(w http.ResponseWriter, r *http.Request) {
+	doc := &readyDoc{Broker: s.gw.Connected(), CS: map[string]bool{}}
+	doc.Ready = doc.Broker
+	for name, cs := range s.csSet.Items() {
+		available := cs.Available()
+		doc.CS[name] = available
+		doc.Ready = doc.Ready && available
+	}
+	writeHealthDoc(w, doc.Ready, doc)
+}
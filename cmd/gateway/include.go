@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// includeField is the field of an include document listing the configuration files to be included.
+const includeField = "include"
+
+// includeDoc represents an include document.
+type includeDoc struct {
+	// included files relative to the directory of the including file (glob patterns supported)
+	Include []string `yaml:"include"`
+}
+
+// includeFn loads an included configuration file.
+type includeFn func(pattern string) error
+
+// includeFiles returns the files matching the include pattern of an including file in lexical order.
+func includeFiles(fsys fs.FS, file, pattern string) ([]string, error) {
+	pattern = path.Join(path.Dir(file), pattern)
+	if !fs.ValidPath(pattern) {
+		return nil, fmt.Errorf("include %s: invalid path", pattern)
+	}
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("include %s: no such file", pattern)
+	}
+	return files, nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Lint finding severities.
+const (
+	sevError   = "error"
+	sevWarning = "warning"
+)
+
+// Lint rule ids.
+const (
+	ruleYAMLSyntax    = "yaml-syntax"
+	ruleTOMLSyntax    = "toml-syntax"
+	ruleMissingType   = "missing-type"
+	ruleMissingName   = "missing-name"
+	ruleUnknownType   = "unknown-type"
+	ruleUnknownField  = "unknown-field"
+	ruleInvalidValue  = "invalid-value"
+	ruleInvalidConfig = "invalid-config"
+	ruleDuplicateName = "duplicate-name"
+	ruleDuplicateAddr = "duplicate-address"
+	ruleEncrypted     = "encrypted-value"
+	ruleEnvVar        = "env-var"
+	ruleTemplate      = "unknown-template"
+	ruleInclude       = "include"
+)
+
+// A finding represents a lint finding.
+type finding struct {
+	File     string `json:"file"`
+	Document int    `json:"document"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+func (f *finding) String() string {
+	return fmt.Sprintf("%s:%d:%d: document %d: %s: %s [%s]", f.File, f.Line, f.Column, f.Document, f.Severity, f.Message, f.Rule)
+}
+
+type location struct {
+	file     string
+	document int
+	line     int
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("%s:%d (document %d)", l.file, l.line, l.document)
+}
+
+// A linter checks configuration files and collects findings.
+type linter struct {
+	findings  []*finding
+	names     map[string]location // key: <type>/<name>
+	addrs     map[uint]location
+	templates map[string]*yaml.Node // loco templates by name
+	secret    []byte                // secret of encrypted configuration values
+	fsys      fs.FS                 // file system of included files
+	linted    map[string]bool       // linted files
+}
+
+func newLinter() *linter {
+	return &linter{names: map[string]location{}, addrs: map[uint]location{}, templates: map[string]*yaml.Node{}, linted: map[string]bool{}}
+}
+
+func (l *linter) add(loc location, node *yaml.Node, severity, rule, format string, a ...any) {
+	f := &finding{File: loc.file, Document: loc.document, Line: loc.line, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, a...)}
+	if node != nil {
+		f.Line, f.Column = node.Line, node.Column
+	}
+	l.findings = append(l.findings, f)
+}
+
+// addErr adds a finding for a yaml error message extracting the line number if available.
+func (l *linter) addErr(loc location, severity, rule, msg string) {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+		loc.line = line
+		_, msg, _ = strings.Cut(msg, ": ")
+	}
+	l.add(loc, nil, severity, rule, "%s", msg)
+}
+
+func (l *linter) hasErrors() bool {
+	for _, f := range l.findings {
+		if f.Severity == sevError {
+			return true
+		}
+	}
+	return false
+}
+
+// lint lints all configuration files in path.
+func (l *linter) lint(fsys fs.FS, path string) error {
+	l.fsys = fsys
+	return fs.WalkDir(fsys, path, func(subPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !slices.Contains(configExts, filepath.Ext(d.Name())) {
+			return nil
+		}
+		return l.lintFile(subPath)
+	})
+}
+
+// lintFile lints a configuration file in the order it is loaded (included files are linted
+// at the include document).
+func (l *linter) lintFile(file string) error {
+	if l.linted[file] {
+		return nil
+	}
+	l.linted[file] = true
+	b, err := fs.ReadFile(l.fsys, file)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(file) == tomlExt {
+		if b, err = tomlToYaml(b); err != nil { // lines refer to the converted YAML document
+			l.add(location{file: file}, nil, sevError, ruleTOMLSyntax, "%s", err)
+			return nil
+		}
+	}
+	l.lintYaml(file, b)
+	return nil
+}
+
+// lintInclude lints the files included by an include document.
+func (l *linter) lintInclude(loc location, node *yaml.Node) {
+	if err := expandEnvNode(node); err != nil {
+		l.addErr(loc, sevError, ruleEnvVar, err.Error())
+		return
+	}
+	var doc includeDoc
+	if err := node.Decode(&doc); err != nil {
+		l.add(loc, node, sevError, ruleInvalidValue, "%s", err)
+		return
+	}
+	if l.fsys == nil {
+		l.add(loc, node, sevError, ruleInclude, "include not supported")
+		return
+	}
+	for _, pattern := range doc.Include {
+		files, err := includeFiles(l.fsys, loc.file, pattern)
+		if err != nil {
+			l.add(loc, node, sevError, ruleInclude, "%s", err)
+			continue
+		}
+		for _, file := range files {
+			if err := l.lintFile(file); err != nil {
+				l.add(loc, node, sevError, ruleInclude, "%s", err)
+			}
+		}
+	}
+}
+
+func (l *linter) lintYaml(file string, b []byte) {
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	for document := 1; ; document++ {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			return
+		}
+		loc := location{file: file, document: document}
+		if err != nil {
+			l.addErr(loc, sevError, ruleYAMLSyntax, err.Error())
+			return // decoder cannot recover
+		}
+		l.lintDocument(loc, &node)
+	}
+}
+
+// mappingValue returns the key and value node of key in a mapping node.
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func (l *linter) lintDocument(loc location, doc *yaml.Node) {
+	if len(doc.Content) == 0 {
+		return // empty document
+	}
+	node := doc.Content[0]
+	loc.line = node.Line
+	if node.Kind != yaml.MappingNode {
+		l.add(loc, node, sevError, ruleInvalidValue, "invalid document - mapping expected")
+		return
+	}
+
+	_, typNode := mappingValue(node, "type")
+	if includeKey, _ := mappingValue(node, includeField); includeKey != nil && typNode == nil {
+		l.lintInclude(loc, node)
+		return
+	}
+	if typNode == nil {
+		l.add(loc, node, sevError, ruleMissingType, "type missing")
+		return
+	}
+	_, nameNode := mappingValue(node, "name")
+	if nameNode == nil {
+		l.add(loc, node, sevError, ruleMissingName, "name missing")
+		return
+	}
+
+	if err := expandEnvNode(node); err != nil {
+		l.addErr(loc, sevError, ruleEnvVar, err.Error())
+		return
+	}
+	if err := decryptNode(node, l.secret); err != nil {
+		l.addErr(loc, sevError, ruleEncrypted, err.Error())
+		return
+	}
+
+	var config any
+	allowed := []string{"type"}
+	switch typNode.Value {
+	case devices.CtCS:
+		config = devices.NewCSConfig()
+	case devices.CtLoco:
+		config = devices.NewLocoConfig()
+		allowed = append(allowed, templateField)
+		if _, tplNode := mappingValue(node, templateField); tplNode != nil {
+			tpl, ok := l.templates[tplNode.Value]
+			if !ok {
+				l.add(loc, tplNode, sevError, ruleTemplate, "template %s not found (templates need to be defined before use)", tplNode.Value)
+				return
+			}
+			if err := tpl.Decode(config); err != nil {
+				return // reported at template
+			}
+		}
+	case ctTemplate:
+		config = devices.NewLocoConfig()
+	default:
+		l.add(loc, typNode, sevError, ruleUnknownType, "unknown type %s", typNode.Value)
+		return
+	}
+
+	l.checkFields(loc, node, reflect.TypeOf(config), allowed...)
+
+	if err := node.Decode(config); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				l.addErr(loc, sevError, ruleInvalidValue, msg)
+			}
+		} else {
+			l.add(loc, node, sevError, ruleInvalidValue, "%s", err)
+		}
+		return
+	}
+
+	key := typNode.Value + "/" + nameNode.Value
+	if prev, ok := l.names[key]; ok {
+		l.add(loc, nameNode, sevWarning, ruleDuplicateName, "%s %s already defined at %s - last definition wins", typNode.Value, nameNode.Value, prev)
+	}
+	l.names[key] = location{file: loc.file, document: loc.document, line: nameNode.Line}
+
+	if typNode.Value == ctTemplate {
+		l.templates[nameNode.Value] = node
+		return // template fields are validated with the locos using the template
+	}
+
+	switch config := config.(type) {
+	case *devices.CSConfig:
+		if err := config.Validate(); err != nil {
+			l.add(loc, nameNode, sevError, ruleInvalidConfig, "%s", err)
+		}
+	case *devices.LocoConfig:
+		if err := config.Validate(); err != nil {
+			l.add(loc, nameNode, sevError, ruleInvalidConfig, "%s", err)
+		}
+		addrKey, addrNode := mappingValue(node, "addr")
+		if addrNode != nil {
+			if prev, ok := l.addrs[config.Addr]; ok {
+				l.add(loc, addrKey, sevWarning, ruleDuplicateAddr, "loco %s address %d already used at %s", config.Name, config.Addr, prev)
+			}
+			l.addrs[config.Addr] = location{file: loc.file, document: loc.document, line: addrKey.Line}
+		}
+	}
+}
+
+// yamlFieldName returns the yaml field name of a struct field.
+func yamlFieldName(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("yaml"); ok {
+		if name, _, _ := strings.Cut(tag, ","); name != "" {
+			return name
+		}
+	}
+	return strings.ToLower(field.Name)
+}
+
+// decodeStrict decodes a configuration document node into v. Other than node.Decode unknown
+// fields (e.g. typos) are reported as error with their line number. The allowed fields
+// are accepted but not decoded.
+func decodeStrict(node *yaml.Node, v any, allowed ...string) error {
+	l := newLinter()
+	l.checkFields(location{}, node, reflect.TypeOf(v), allowed...)
+	if len(l.findings) != 0 {
+		msgs := make([]string, len(l.findings))
+		for i, f := range l.findings {
+			msgs[i] = fmt.Sprintf("line %d: %s", f.Line, f.Message)
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return node.Decode(v)
+}
+
+// checkFields checks recursively if all mapping keys of node are fields of typ.
+func (l *linter) checkFields(loc location, node *yaml.Node, typ reflect.Type, allowed ...string) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return // type errors are reported by decoding
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < typ.NumField(); i++ {
+			if field := typ.Field(i); field.IsExported() {
+				fields[yamlFieldName(field)] = field.Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldTyp, ok := fields[key.Value]
+			if !ok {
+				if !slices.Contains(allowed, key.Value) {
+					l.add(loc, key, sevError, ruleUnknownField, "unknown field %s", key.Value)
+				}
+				continue
+			}
+			l.checkFields(loc, value, fieldTyp)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			l.checkFields(loc, node.Content[i], typ.Elem())
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			l.checkFields(loc, item, typ.Elem())
+		}
+	}
+}
+
+// Lint output formats.
+const (
+	lintFormatText = "text"
+	lintFormatJSON = "json"
+)
+
+func (l *linter) write(w io.Writer, format string) error {
+	switch format {
+	case lintFormatText:
+		for _, f := range l.findings {
+			fmt.Fprintln(w, f)
+		}
+		return nil
+	case lintFormatJSON:
+		findings := l.findings
+		if findings == nil {
+			findings = []*finding{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(findings)
+	default:
+		return fmt.Errorf("invalid lint format %s", format)
+	}
+}
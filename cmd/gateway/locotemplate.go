@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"gopkg.in/yaml.v3"
+)
+
+// ctTemplate is the configuration type of loco templates defining reusable loco configuration
+// fields (e.g. a function mapping shared by several locos).
+const ctTemplate = "template"
+
+// templateField is the loco configuration field referencing a template.
+const templateField = "template"
+
+// decodeTemplate decodes a loco template document to check its fields.
+func decodeTemplate(node *yaml.Node) error {
+	return decodeStrict(node, devices.NewLocoConfig(), "type")
+}
+
+// decodeLoco decodes a loco configuration document. In case the document references a template
+// the template fields are decoded first, so that the loco fields override the template fields
+// (maps like the function mapping are merged by key).
+func decodeLoco(node *yaml.Node, templates map[string]*yaml.Node) (*devices.LocoConfig, error) {
+	locoConfig := devices.NewLocoConfig()
+	if _, tplNode := mappingValue(node, templateField); tplNode != nil {
+		tpl, ok := templates[tplNode.Value]
+		if !ok {
+			return nil, fmt.Errorf("line %d: template %s not found", tplNode.Line, tplNode.Value)
+		}
+		if err := tpl.Decode(locoConfig); err != nil {
+			return nil, err
+		}
+	}
+	if err := decodeStrict(node, locoConfig, "type", templateField); err != nil {
+		return nil, err
+	}
+	return locoConfig, nil
+}
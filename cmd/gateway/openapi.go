@@ -0,0 +1,35 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+)
+
+// openAPISpec is the OpenAPI 3 specification of the HTTP API.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// serveOpenAPI serves the OpenAPI specification of the HTTP API. A configured base path is
+// provided as server URL.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	basePath := server.BasePath(r)
+	if basePath == "" {
+		w.Write(openAPISpec)
+		return
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	spec["servers"] = []map[string]string{{"url": basePath}}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(spec)
+}
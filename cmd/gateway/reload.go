@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// reloadDelay is the quiet period after a configuration file change before the configuration is reloaded
+// (editors usually write a file with several file system operations).
+const reloadDelay = 500 * time.Millisecond
+
+// reload applies the changes between the previous and the reloaded configuration: removed devices are torn down,
+// new or changed devices are added or replaced. Devices added at runtime (e.g. via MQTT) are kept.
+func (s *deviceSets) reload(prev, config *config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// devices of prev might have been removed already by a failed reload applied again
+	locoMap, csMap := s.locoSet.Items(), s.csSet.Items()
+	for name := range prev.locoConfigMap {
+		if _, ok := config.locoConfigMap[name]; !ok && locoMap[name] != nil {
+			s.lg.Printf("reload: remove loco %s", name)
+			if err := s.removeLoco(name); err != nil {
+				return err
+			}
+		}
+	}
+	for name := range prev.csConfigMap {
+		if _, ok := config.csConfigMap[name]; !ok && csMap[name] != nil {
+			s.lg.Printf("reload: remove command station %s", name)
+			if err := s.removeCS(name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, csConfig := range config.csConfigMap {
+		if prevConfig, ok := prev.csConfigMap[name]; ok && reflect.DeepEqual(prevConfig, csConfig) {
+			continue
+		}
+		s.lg.Printf("reload: set command station %s", name)
+		if err := s.setCS(csConfig); err != nil {
+			return err
+		}
+	}
+	for name, locoConfig := range config.locoConfigMap {
+		if prevConfig, ok := prev.locoConfigMap[name]; ok && reflect.DeepEqual(prevConfig, locoConfig) {
+			continue
+		}
+		s.lg.Printf("reload: set loco %s", name)
+		if err := s.setLoco(locoConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A configReloader reloads the configuration files and applies the changes to the device sets.
+type configReloader struct {
+	lg         logger.Logger
+	deviceSets *deviceSets
+	secret     []byte
+	mu         sync.Mutex
+	prev       *config // configuration loaded from files
+}
+
+func newConfigReloader(lg logger.Logger, deviceSets *deviceSets, secret []byte, config *config) *configReloader {
+	return &configReloader{lg: lg, deviceSets: deviceSets, secret: secret, prev: config}
+}
+
+// reload loads the embedded and the external configuration files of fsys and applies the changes.
+func (r *configReloader) reload(fsys fs.FS) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lg.Printf("reload configuration files")
+	config := newConfig(r.lg)
+	config.secret = r.secret
+	config.conflict = r.prev.conflict
+	if err := config.load(embedFsys, embedConfigDir); err != nil {
+		r.lg.Printf("reload: %s", err)
+		return
+	}
+	if err := config.load(fsys, "."); err != nil {
+		r.lg.Printf("reload: %s - configuration not changed", err)
+		return
+	}
+	if err := config.validate(); err != nil {
+		r.lg.Printf("reload: %s - configuration not changed", err)
+		return
+	}
+	err := r.deviceSets.reload(r.prev, config)
+	r.deviceSets.publishDevices()
+	if err != nil {
+		// keep the previous configuration, so that the changes are applied again on the next reload
+		r.lg.Printf("reload: %s - configuration partially changed", err)
+		return
+	}
+	r.prev = config
+}
+
+// watchDir watches the local configuration directory (including sub-directories) and reloads
+// the configuration on changes.
+func (r *configReloader) watchDir(dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return watcher.Add(path)
+	}); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) {
+					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+						watcher.Add(event.Name) // ignore error
+					}
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() { r.reload(os.DirFS(dir)) })
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.lg.Printf("watch configuration directory %s: %s", dir, err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"golang.org/x/exp/slices"
+)
+
+// isURL returns true if the configuration location is a http(s) URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// remoteTimeout is the timeout for fetching remote configuration files.
+const remoteTimeout = 30 * time.Second
+
+var tarExts = []string{".tar", ".tar.gz", ".tgz"}
+
+func isTar(s string) bool {
+	for _, ext := range tarExts {
+		if strings.HasSuffix(s, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+const zipExt = ".zip"
+
+// externFS returns the file system of the external configuration directory which is whether
+// a local directory or a http(s) URL. In case of an URL the remote configuration is returned as well.
+func externFS(dir string) (fs.FS, *remoteConfig, error) {
+	if !isURL(dir) {
+		return os.DirFS(dir), nil, nil
+	}
+	remote := newRemoteConfig(dir)
+	fsys, err := remote.fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+	return fsys, remote, nil
+}
+
+// remoteConfig fetches configuration files from a http(s) URL which is whether
+// - a tarball (.tar, .tar.gz or .tgz) containing the configuration files,
+// - a zip archive (.zip) containing the configuration files or
+// - a directory index (HTML page) linking to the configuration files.
+type remoteConfig struct {
+	url    string
+	etag   string
+	client *http.Client
+}
+
+func newRemoteConfig(url string) *remoteConfig {
+	return &remoteConfig{url: url, client: &http.Client{Timeout: remoteTimeout}}
+}
+
+// errNotModified is returned by fetch if the remote configuration was not modified.
+var errNotModified = errors.New("remote configuration not modified")
+
+func (c *remoteConfig) get(url, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, errNotModified
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+}
+
+// fetch fetches the configuration files and returns them as file system. If the configuration
+// was fetched before, the request is conditional (ETag) and errNotModified is returned
+// if the configuration did not change.
+func (c *remoteConfig) fetch() (fs.FS, error) {
+	resp, err := c.get(c.url, c.etag)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var fsys fstest.MapFS
+	switch urlPath := resp.Request.URL.Path; {
+	case isTar(urlPath):
+		fsys, err = readTar(resp.Body)
+	case strings.HasSuffix(urlPath, zipExt):
+		fsys, err = readZip(resp.Body)
+	default:
+		fsys, err = c.readIndex(resp.Request.URL, resp.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.etag = resp.Header.Get("ETag")
+	return fsys, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readTar reads the regular files of a (optionally gzip compressed) tarball.
+func readTar(r io.Reader) (fstest.MapFS, error) {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	fsys := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid tar file name %s", hdr.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		fsys[name] = &fstest.MapFile{Data: b, Mode: 0444, ModTime: hdr.ModTime}
+	}
+}
+
+// readZip reads the regular files of a zip archive.
+func readZip(r io.Reader) (fstest.MapFS, error) {
+	b, err := io.ReadAll(r) // zip needs random access
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := fstest.MapFS{}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(f.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid zip file name %s", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		fsys[name] = &fstest.MapFile{Data: data, Mode: 0444, ModTime: f.Modified}
+	}
+	return fsys, nil
+}
+
+var hrefRe = regexp.MustCompile(`href\s*=\s*["']([^"']+)["']`)
+
+func (c *remoteConfig) readIndex(base *url.URL, r io.Reader) (fstest.MapFS, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := fstest.MapFS{}
+	for _, m := range hrefRe.FindAllSubmatch(b, -1) {
+		ref, err := url.Parse(string(m[1]))
+		if err != nil {
+			continue
+		}
+		fileURL := base.ResolveReference(ref)
+		name := path.Base(fileURL.Path)
+		if !slices.Contains(configExts, path.Ext(name)) {
+			continue
+		}
+		resp, err := c.get(fileURL.String(), "")
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		fsys[name] = &fstest.MapFile{Data: data, Mode: 0444}
+	}
+	return fsys, nil
+}
+
+// watch periodically checks the remote configuration for changes.
+func (c *remoteConfig) watch(lg logger.Logger, interval time.Duration, changed func(fsys fs.FS)) {
+	go func() {
+		for range time.Tick(interval) {
+			fsys, err := c.fetch()
+			switch err {
+			case nil:
+				changed(fsys)
+			case errNotModified:
+				// nothing to do
+			default:
+				lg.Printf("refresh remote configuration %s: %s", c.url, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+)
+
+// maxDeviceDocSize is the maximum size of a device configuration document accepted by the REST API.
+const maxDeviceDocSize = 1 << 20
+
+// serveDevices returns a handler adding (POST), adding or replacing (PUT) and removing (DELETE)
+// devices of type typ (path: /<type>/<device name>). All other requests are served by next.
+func (s *deviceSets) serveDevices(typ string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' })
+		switch {
+		case len(parts) != 2:
+			next.ServeHTTP(w, r)
+		case r.Method == http.MethodPost || r.Method == http.MethodPut:
+			s.serveSetDevice(w, r, typ, parts[1])
+		case r.Method == http.MethodDelete:
+			s.serveRemoveDevice(w, r, typ, parts[1])
+		default:
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// exists returns true if a device of type typ and name exists.
+func (s *deviceSets) exists(typ, name string) bool {
+	if typ == devices.CtCS {
+		_, ok := s.csSet.Items()[name]
+		return ok
+	}
+	_, ok := s.locoSet.Items()[name]
+	return ok
+}
+
+// serveSetDevice adds (POST) or adds or replaces (PUT) a device via a JSON configuration document.
+func (s *deviceSets) serveSetDevice(w http.ResponseWriter, r *http.Request, typ, name string) {
+	if !server.RequireJSON(w, r) {
+		return
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDeviceDocSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			server.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config, err := decodeJSONDoc(typ, b)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var docName *string
+	var validate func() error
+	switch config := config.(type) {
+	case *devices.CSConfig:
+		docName, validate = &config.Name, config.Validate
+	case *devices.LocoConfig:
+		docName, validate = &config.Name, config.Validate
+	}
+	if *docName == "" {
+		*docName = name
+	}
+	if *docName != name {
+		server.Error(w, fmt.Sprintf("name %s does not match path name %s", *docName, name), http.StatusBadRequest)
+		return
+	}
+	if err := validate(); err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	exists := s.exists(typ, name)
+	if exists && r.Method == http.MethodPost {
+		s.mu.Unlock()
+		server.Error(w, fmt.Sprintf("%s %s already exists", typ, name), http.StatusConflict)
+		return
+	}
+	s.lg.Printf("http: set %s %s", typ, name)
+	switch config := config.(type) {
+	case *devices.CSConfig:
+		err = s.setCS(config)
+	case *devices.LocoConfig:
+		err = s.setLoco(config)
+	}
+	s.mu.Unlock()
+	s.publishDevices()
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if exists {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(config)
+}
+
+// serveRemoveDevice removes a device.
+func (s *deviceSets) serveRemoveDevice(w http.ResponseWriter, r *http.Request, typ, name string) {
+	s.mu.Lock()
+	if !s.exists(typ, name) {
+		s.mu.Unlock()
+		server.NotFound(w, r)
+		return
+	}
+	s.lg.Printf("http: remove %s %s", typ, name)
+	var err error
+	if typ == devices.CtCS {
+		err = s.removeCS(name)
+	} else {
+		err = s.removeLoco(name)
+	}
+	s.mu.Unlock()
+	s.publishDevices()
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Encrypted configuration values are stored as ENC[<base64(salt|nonce|ciphertext)>], the AES-256-GCM
+// key is derived from the secret (key file content or passphrase) by scrypt.
+const (
+	encPrefix = "ENC["
+	encSuffix = "]"
+)
+
+const (
+	envConfigPassphrase = "CONFIG-PASSPHRASE"
+)
+
+const (
+	encSaltSize = 16
+	encKeySize  = 32
+	// scrypt parameters
+	encN = 1 << 15
+	encR = 8
+	encP = 1
+)
+
+var errNoSecret = errors.New("encrypted value found - configuration key file or passphrase required")
+
+// loadSecret returns the secret of the encrypted configuration values (key file content
+// or passphrase provided via environment, nil if none is available).
+func loadSecret(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", keyFile)
+		}
+		return b, nil
+	}
+	if passphrase, ok := os.LookupEnv(envConfigPassphrase); ok && passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, encPrefix) && strings.HasSuffix(s, encSuffix)
+}
+
+func encAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, encN, encR, encP, encKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue encrypts a configuration value.
+func encryptValue(secret []byte, value string) (string, error) {
+	salt := make([]byte, encSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := encAEAD(secret, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b := append(salt, nonce...)
+	b = aead.Seal(b, nonce, []byte(value), nil)
+	return encPrefix + base64.StdEncoding.EncodeToString(b) + encSuffix, nil
+}
+
+// decryptValue decrypts an encrypted configuration value.
+func decryptValue(secret []byte, s string) (string, error) {
+	if secret == nil {
+		return "", errNoSecret
+	}
+	b, err := base64.StdEncoding.DecodeString(s[len(encPrefix) : len(s)-len(encSuffix)])
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %s", err)
+	}
+	if len(b) < encSaltSize {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	aead, err := encAEAD(secret, b[:encSaltSize])
+	if err != nil {
+		return "", err
+	}
+	b = b[encSaltSize:]
+	if len(b) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	value, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value - invalid key or passphrase")
+	}
+	return string(value), nil
+}
+
+// decryptNode replaces all encrypted scalar values of a yaml node tree by the decrypted values.
+func decryptNode(node *yaml.Node, secret []byte) error {
+	if node.Kind == yaml.ScalarNode && isEncrypted(node.Value) {
+		value, err := decryptValue(secret, node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		node.Tag = "" // resolve the decrypted value (e.g. numbers)
+		node.Style = 0
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := decryptNode(child, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
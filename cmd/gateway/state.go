@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	"golang.org/x/exp/maps"
+)
+
+// A stateTracker keeps the last published event value of each command station property
+// (e.g. enabled, temp or IO values), so that the state is known without querying the devices.
+type stateTracker struct {
+	mu sync.RWMutex
+	cs map[string]map[string]any // key: command station name, property topic (e.g. temp or gpio1/turnout)
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{cs: map[string]map[string]any{}}
+}
+
+// update is the gateway publish callback (see Gateway.OnPublish) updating the state.
+func (t *stateTracker) update(topicStrs []string, value any) {
+	if len(topicStrs) < 3 || topicStrs[0] != devices.CtCS {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := topicStrs[1]
+	if t.cs[name] == nil {
+		t.cs[name] = map[string]any{}
+	}
+	t.cs[name][strings.Join(topicStrs[2:], "/")] = value
+}
+
+func (t *stateTracker) csState(name string) map[string]any {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.cs[name] == nil {
+		return map[string]any{}
+	}
+	return maps.Clone(t.cs[name])
+}
+
+// stateDoc represents the current known state of the devices.
+type stateDoc struct {
+	Loco map[string]*devices.LocoState `json:"loco"`
+	CS   map[string]map[string]any     `json:"cs"`
+}
+
+// serveState serves the current known state of all locos and command stations as JSON document.
+func (s *deviceSets) serveState(w http.ResponseWriter, r *http.Request) {
+	doc := &stateDoc{Loco: map[string]*devices.LocoState{}, CS: map[string]map[string]any{}}
+	for name, loco := range s.locoSet.Items() {
+		doc.Loco[name] = loco.State()
+	}
+	for name := range s.csSet.Items() {
+		doc.CS[name] = s.state.csState(name)
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// Roster synchronization modes.
+const (
+	syncNone    = ""
+	syncPrimary = "primary" // publish the active configuration on the coordination topic
+	syncBackup  = "backup"  // mirror the configuration published on the coordination topic to disk
+)
+
+func checkSyncMode(mode string) error {
+	switch mode {
+	case syncNone, syncPrimary, syncBackup:
+		return nil
+	default:
+		return fmt.Errorf("invalid sync mode %s (primary or backup)", mode)
+	}
+}
+
+// syncTopicStrs is the coordination topic (below topic root) the roster is synchronized on.
+var syncTopicStrs = []string{"gateway", "sync"}
+
+// syncFile is the name of the configuration file the backup writes the synchronized roster to.
+const syncFile = "roster.yaml"
+
+// syncDoc represents the roster and device configuration exchanged on the coordination topic.
+type syncDoc struct {
+	CS   []*devices.CSConfig   `json:"cs"`
+	Loco []*devices.LocoConfig `json:"loco"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface decoding the device configurations
+// like configuration files, so that fields missing in the document are set to their defaults.
+func (d *syncDoc) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		CS   []json.RawMessage `json:"cs"`
+		Loco []json.RawMessage `json:"loco"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	d.CS, d.Loco = make([]*devices.CSConfig, 0, len(raw.CS)), make([]*devices.LocoConfig, 0, len(raw.Loco))
+	for _, b := range raw.CS {
+		config, err := decodeJSONDoc(devices.CtCS, b)
+		if err != nil {
+			return err
+		}
+		d.CS = append(d.CS, config.(*devices.CSConfig))
+	}
+	for _, b := range raw.Loco {
+		config, err := decodeJSONDoc(devices.CtLoco, b)
+		if err != nil {
+			return err
+		}
+		d.Loco = append(d.Loco, config.(*devices.LocoConfig))
+	}
+	return nil
+}
+
+// syncDoc returns the synchronization document of the configuration (sorted by name).
+func (c *config) syncDoc() *syncDoc {
+	doc := &syncDoc{CS: []*devices.CSConfig{}, Loco: []*devices.LocoConfig{}}
+	for _, csConfig := range c.csConfigMap {
+		doc.CS = append(doc.CS, csConfig)
+	}
+	for _, locoConfig := range c.locoConfigMap {
+		doc.Loco = append(doc.Loco, locoConfig)
+	}
+	sort.Slice(doc.CS, func(i, j int) bool { return doc.CS[i].Name < doc.CS[j].Name })
+	sort.Slice(doc.Loco, func(i, j int) bool { return doc.Loco[i].Name < doc.Loco[j].Name })
+	return doc
+}
+
+// config returns the validated configuration of the synchronization document.
+func (d *syncDoc) config(lg logger.Logger) (*config, error) {
+	config := newConfig(lg)
+	for _, csConfig := range d.CS {
+		if err := csConfig.Validate(); err != nil {
+			return nil, err
+		}
+		config.csConfigMap[csConfig.Name] = csConfig
+	}
+	for _, locoConfig := range d.Loco {
+		if err := locoConfig.Validate(); err != nil {
+			return nil, err
+		}
+		config.locoConfigMap[locoConfig.Name] = locoConfig
+	}
+	return config, nil
+}
+
+// publishSync publishes the active configuration on the coordination topic (primary sync mode only).
+func (s *deviceSets) publishSync() {
+	if s.syncMode != syncPrimary {
+		return
+	}
+	s.gw.Publish(syncTopicStrs, true, s.config().syncDoc())
+}
+
+// rosterMirror is a backup instance writing the roster received on the coordination topic
+// to a configuration file, so that the backup can take over by using dir as configuration directory.
+type rosterMirror struct {
+	lg    logger.Logger
+	gw    *gateway.Gateway
+	dir   string
+	hndCh chan *gateway.HndMsg
+}
+
+func newRosterMirror(lg logger.Logger, gw *gateway.Gateway, dir string) *rosterMirror {
+	return &rosterMirror{lg: lg, gw: gw, dir: dir, hndCh: make(chan *gateway.HndMsg, gateway.DefChanSize)}
+}
+
+func (m *rosterMirror) start() {
+	m.gw.Subscribe(m.hndCh, m, syncTopicStrs, gateway.Typed(m.write))
+	go m.cmdHandler()
+}
+
+func (m *rosterMirror) close() {
+	m.gw.Unsubscribe(m, syncTopicStrs)
+	close(m.hndCh)
+}
+
+func (m *rosterMirror) cmdHandler() {
+	for msg := range m.hndCh {
+		if _, err := msg.Fn(msg.Value); err != nil {
+			m.gw.PublishErr(msg.TopicStrs, false, err)
+		}
+	}
+}
+
+// write writes the synchronized roster atomically to the configuration file.
+func (m *rosterMirror) write(doc *syncDoc) (any, error) {
+	config, err := doc.config(m.lg)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(m.dir, syncFile+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // no-op after successful rename
+	if err := config.writeYaml(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(m.dir, syncFile)
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return nil, err
+	}
+	m.lg.Printf("sync: wrote %d command stations %d locos to %s", len(doc.CS), len(doc.Loco), filename)
+	return nil, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// tomlExt is the file extension of TOML configuration files.
+const tomlExt = ".toml"
+
+// tomlToYaml converts a TOML encoded device configuration document to YAML, so that TOML files are
+// parsed like YAML files (same type and name discriminator, field names and encrypted values).
+func tomlToYaml(b []byte) ([]byte, error) {
+	var m map[string]any
+	if _, err := toml.Decode(string(b), &m); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(m)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+)
+
+// Build information set via linker flags, e.g.
+// go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+var infoTopicStrs = []string{"gateway", "info"}
+
+// buildInfo represents the version and build information of the gateway.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// newBuildInfo returns the build information set via linker flags - commit and build date default
+// to the version control information embedded by the go command.
+func newBuildInfo() *buildInfo {
+	info := &buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range bi.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = setting.Value
+		case setting.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = setting.Value
+		}
+	}
+	return info
+}
+
+func (i *buildInfo) String() string {
+	return fmt.Sprintf("version %s commit %s build date %s %s", i.Version, i.Commit, i.BuildDate, i.GoVersion)
+}
+
+// serveVersion serves the build information as JSON document.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newBuildInfo()); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func versionCmd(lg *log.Logger, args []string) error {
+	fmt.Println(newBuildInfo())
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// Webhook delivery parameters.
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookRetries    = 5
+	webhookBackoff    = time.Second // doubled on each retry
+	webhookMaxBackoff = time.Minute
+	webhookQueueSize  = 100
+)
+
+// defaultWebhookTemplate is the default body template of a webhook.
+const defaultWebhookTemplate = `{"topic": {{json .Topic}}, "value": {{.JSON}}, "time": {{json .Time}}}`
+
+// webhookConfig represents the configuration of a webhook.
+type webhookConfig struct {
+	// webhook URL
+	URL string `yaml:"url"`
+	// HTTP method (default POST)
+	Method string `yaml:"method"`
+	// HTTP request headers (e.g. authorization)
+	Headers map[string]string `yaml:"headers"`
+	// event topic filters without topic root (MQTT wildcards '+' and '#' supported), e.g. alert or cs/+/mte
+	Events []string `yaml:"events"`
+	// body template (go text/template, data: .Topic, .Value, .JSON and .Time)
+	Template string `yaml:"template"`
+}
+
+// webhookData represents the data a webhook body template is executed with.
+type webhookData struct {
+	// event topic without topic root
+	Topic string
+	// event value (decoded JSON - e.g. .Value.severity for alerts)
+	Value any
+	// event value as JSON
+	JSON string
+	// event time
+	Time time.Time
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type webhook struct {
+	config  *webhookConfig
+	tpl     *template.Template
+	filters [][]string
+}
+
+func newWebhook(config *webhookConfig) (*webhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook: url missing")
+	}
+	if len(config.Events) == 0 {
+		return nil, fmt.Errorf("webhook %s: events missing", config.URL)
+	}
+	text := config.Template
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	tpl, err := template.New(config.URL).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: %s", config.URL, err)
+	}
+	filters := make([][]string, len(config.Events))
+	for i, event := range config.Events {
+		filters[i] = strings.Split(event, "/")
+	}
+	return &webhook{config: config, tpl: tpl, filters: filters}, nil
+}
+
+// topicMatch returns true if the topic levels match the topic filter levels.
+func topicMatch(filter, topicStrs []string) bool {
+	for i, level := range filter {
+		switch {
+		case level == "#":
+			return true
+		case i >= len(topicStrs):
+			return false
+		case level != "+" && level != topicStrs[i]:
+			return false
+		}
+	}
+	return len(filter) == len(topicStrs)
+}
+
+func (h *webhook) match(topicStrs []string) bool {
+	for _, filter := range h.filters {
+		if topicMatch(filter, topicStrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *webhook) body(topicStrs []string, value any, t time.Time) ([]byte, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	data := &webhookData{Topic: strings.Join(topicStrs, "/"), JSON: string(b), Time: t}
+	if err := json.Unmarshal(b, &data.Value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := h.tpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type webhookMsg struct {
+	hook      *webhook
+	topicStrs []string
+	value     any
+	time      time.Time
+}
+
+// webhooks sends events to the configured webhooks (in order, retrying failed requests with exponential backoff).
+type webhooks struct {
+	lg     logger.Logger
+	hooks  []*webhook
+	client *http.Client
+	msgCh  chan *webhookMsg
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// loadWebhooks loads the webhook configurations of a YAML file (list of webhook configurations).
+func loadWebhooks(lg logger.Logger, filename string, secret []byte) (*webhooks, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, fmt.Errorf("webhook file %s: %s", filename, err)
+	}
+	if err := expandEnvNode(&node); err != nil {
+		return nil, fmt.Errorf("webhook file %s: %s", filename, err)
+	}
+	if err := decryptNode(&node, secret); err != nil {
+		return nil, fmt.Errorf("webhook file %s: %s", filename, err)
+	}
+	var configs []*webhookConfig
+	if len(node.Content) != 0 {
+		if err := decodeStrict(node.Content[0], &configs); err != nil {
+			return nil, fmt.Errorf("webhook file %s: %s", filename, err)
+		}
+	}
+	return newWebhooks(lg, configs)
+}
+
+func newWebhooks(lg logger.Logger, configs []*webhookConfig) (*webhooks, error) {
+	w := &webhooks{
+		lg:     lg,
+		client: &http.Client{Timeout: webhookTimeout},
+		msgCh:  make(chan *webhookMsg, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, config := range configs {
+		hook, err := newWebhook(config)
+		if err != nil {
+			return nil, err
+		}
+		w.hooks = append(w.hooks, hook)
+	}
+	w.wg.Add(1)
+	go w.sender()
+	return w, nil
+}
+
+// close stops the sender (queued events are dropped).
+func (w *webhooks) close() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+// notify queues an event for all matching webhooks (gateway publish callback - does not block).
+func (w *webhooks) notify(topicStrs []string, value any) {
+	now := time.Now()
+	for _, hook := range w.hooks {
+		if !hook.match(topicStrs) {
+			continue
+		}
+		select {
+		case w.msgCh <- &webhookMsg{hook: hook, topicStrs: topicStrs, value: value, time: now}:
+		default:
+			w.lg.Printf("webhook %s: queue full - event %s dropped", hook.config.URL, strings.Join(topicStrs, "/"))
+		}
+	}
+}
+
+func (w *webhooks) sender() {
+	defer w.wg.Done()
+	for {
+		var msg *webhookMsg
+		select {
+		case <-w.done:
+			return
+		case msg = <-w.msgCh:
+		}
+		body, err := msg.hook.body(msg.topicStrs, msg.value, msg.time)
+		if err != nil {
+			w.lg.Printf("webhook %s: %s", msg.hook.config.URL, err)
+			continue
+		}
+		backoff := webhookBackoff
+		for i := 0; ; i++ {
+			err := w.send(msg.hook.config, body)
+			if err == nil {
+				break
+			}
+			if i == webhookRetries {
+				w.lg.Printf("webhook %s: %s - giving up", msg.hook.config.URL, err)
+				break
+			}
+			w.lg.Printf("webhook %s: %s - retry in %s", msg.hook.config.URL, err, backoff)
+			select {
+			case <-w.done:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > webhookMaxBackoff {
+				backoff = webhookMaxBackoff
+			}
+		}
+	}
+}
+
+func (w *webhooks) send(config *webhookConfig, body []byte) error {
+	method := config.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
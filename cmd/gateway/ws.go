@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+)
+
+// newWSHub returns a WebSocket hub streaming the gateway events and dispatching the command
+// frames like messages received by the broker (including the inbound middleware).
+func newWSHub(lg logger.Logger, gw *gateway.Gateway, httpConfig *server.Config) *server.WSHub {
+	hub := server.NewWSHub(lg, httpConfig, func(cmd *server.Command) error {
+		if cmd.Value == nil {
+			cmd.Value = json.RawMessage("null") // commands like toggle do not need a value
+		}
+		ok, err := gw.DispatchInbound(strings.Split(cmd.Topic, "/"), cmd.Value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no handler subscribed to topic %s", cmd.Topic)
+		}
+		return nil
+	})
+	gw.OnPublish(func(topicStrs []string, value any) {
+		hub.Publish(strings.Join(topicStrs, "/"), value)
+	})
+	return hub
+}
+
+// newSSEHub returns a Server-Sent Events hub streaming the gateway events.
+func newSSEHub(lg logger.Logger, gw *gateway.Gateway) *server.SSEHub {
+	hub := server.NewSSEHub(lg)
+	gw.OnPublish(func(topicStrs []string, value any) {
+		hub.Publish(strings.Join(topicStrs, "/"), value)
+	})
+	return hub
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"golang.org/x/exp/slices"
+
+	_ "modernc.org/sqlite" // SQLite driver reading the z21 app loco database
+)
+
+// z21 app export files (.z21 layout and .z21loco loco export) are zip archives containing the
+// SQLite loco database and the loco images.
+const (
+	importZ21 = "z21"
+	z21DBFile = "Loco.sqlite"
+)
+
+// z21 vehicle type of locos.
+const z21TypeLoco = 0
+
+// z21FctNames maps z21 app function icons to function names. Icons not contained in the map
+// are used as function name.
+var z21FctNames = map[string]string{
+	"light":          "light",
+	"main_beam":      "light",
+	"back_light":     "backlight",
+	"cabin_light":    "cablight",
+	"interior_light": "interior",
+	"sound":          "sound",
+	"engine":         "sound",
+	"horn_high":      "horn",
+	"horn_low":       "horn2",
+	"whistle_long":   "whistle",
+	"whistle_short":  "whistle2",
+	"bell":           "bell",
+	"couple":         "coupler",
+	"uncouple":       "coupler",
+	"shunting":       "shunt-mode",
+	"steam":          "steam",
+	"smoke":          "steam",
+	"fan":            "fan",
+	"mute":           "mute",
+}
+
+// z21Name converts a z21 app name into a topic level name (lower case letters, digits and '-').
+func z21Name(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, s)
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "-")
+	}
+	return strings.Trim(s, "-")
+}
+
+// z21FctName returns the function name of a z21 function icon. Function names already used by
+// the loco or reserved are extended by the function number.
+func z21FctName(icon string, no uint, fcts map[string]devices.LocoFctConfig) string {
+	name, ok := z21FctNames[icon]
+	if !ok {
+		name = z21Name(icon)
+	}
+	if name == "" {
+		return fmt.Sprintf("f%d", no)
+	}
+	if _, ok := fcts[name]; ok || slices.Contains(devices.ReservedFctNames, name) {
+		return fmt.Sprintf("%s%d", name, no)
+	}
+	return name
+}
+
+// extractZ21DB extracts the loco database of a z21 app export file into a temporary file.
+func extractZ21DB(filename string) (string, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if path.Base(f.Name) != z21DBFile {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		tmp, err := os.CreateTemp("", "z21-*.sqlite")
+		if err != nil {
+			return "", err
+		}
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			os.Remove(tmp.Name())
+			return "", err
+		}
+		return tmp.Name(), nil
+	}
+	return "", fmt.Errorf("%s: %s not found", filename, z21DBFile)
+}
+
+// importZ21Locos imports the locos of a z21 app export file.
+func importZ21Locos(filename string) ([]*devices.LocoConfig, error) {
+	dbFile, err := extractZ21DB(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dbFile)
+
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name, address, max_speed FROM vehicles WHERE type = ? ORDER BY id", z21TypeLoco)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locos := map[int64]*devices.LocoConfig{}
+	var ids []int64
+	for rows.Next() {
+		var (
+			id       int64
+			name     string
+			addr     uint
+			maxSpeed float64
+		)
+		if err := rows.Scan(&id, &name, &addr, &maxSpeed); err != nil {
+			return nil, err
+		}
+		locoConfig := devices.NewLocoConfig()
+		if locoConfig.Name = z21Name(name); locoConfig.Name == "" {
+			locoConfig.Name = fmt.Sprintf("loco%d", addr)
+		}
+		locoConfig.Addr = addr
+		locoConfig.ScaleSpeed = maxSpeed
+		locos[id] = locoConfig
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	fctRows, err := db.Query("SELECT vehicle_id, function, image_name FROM functions ORDER BY vehicle_id, function")
+	if err != nil {
+		return nil, err
+	}
+	defer fctRows.Close()
+	for fctRows.Next() {
+		var (
+			id   int64
+			no   uint
+			icon string
+		)
+		if err := fctRows.Scan(&id, &no, &icon); err != nil {
+			return nil, err
+		}
+		locoConfig, ok := locos[id]
+		if !ok {
+			continue // no loco
+		}
+		locoConfig.Fcts[z21FctName(icon, no, locoConfig.Fcts)] = devices.LocoFctConfig{No: no}
+	}
+	if err := fctRows.Err(); err != nil {
+		return nil, err
+	}
+
+	locoConfigs := make([]*devices.LocoConfig, len(ids))
+	for i, id := range ids {
+		locoConfigs[i] = locos[id]
+	}
+	return locoConfigs, nil
+}
@@ -0,0 +1,104 @@
+package devices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// DefaultAlertInterval is the default interval the temperature and command queue alert rules are checked.
+const DefaultAlertInterval = 10 * time.Second
+
+// alertTempHysteresis is the temperature drop in degree Celsius below the maximum temperature clearing the alert.
+const alertTempHysteresis = 2.0
+
+func (cs *CS) alertID(rule string) string { return "cs/" + cs.name() + "/" + rule }
+
+// offlineAlert starts (offline) or stops (online) the offline alert timer (cs.connMu needs to be locked).
+func (cs *CS) offlineAlert(connected bool) {
+	timeout := cs.setConfig.AlertOfflineTimeout
+	if timeout == 0 {
+		return
+	}
+	if connected {
+		if cs.offlineTimer != nil {
+			cs.offlineTimer.Stop()
+			cs.offlineTimer = nil
+		}
+		cs.gw.ClearAlert(cs.alertID("offline"))
+		return
+	}
+	if cs.offlineTimer == nil {
+		cs.offlineTimer = time.AfterFunc(timeout, func() {
+			cs.gw.RaiseAlert(cs.alertID("offline"), gateway.SeverityCritical, fmt.Sprintf("command station %s offline for more than %s", cs.name(), timeout))
+		})
+	}
+}
+
+// clearAlerts stops the offline alert timer and clears all alerts of the command station.
+func (cs *CS) clearAlerts() {
+	cs.connMu.Lock()
+	if cs.offlineTimer != nil {
+		cs.offlineTimer.Stop()
+		cs.offlineTimer = nil
+	}
+	cs.connMu.Unlock()
+	for _, rule := range []string{"offline", "temp", "queue"} {
+		cs.gw.ClearAlert(cs.alertID(rule))
+	}
+}
+
+// alertMonitor checks the temperature and the command queue alert rules periodically.
+func (cs *CS) alertMonitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.done:
+			return
+		case <-ticker.C:
+		}
+		if cs.setConfig.AlertTempMax > 0 {
+			cs.checkTemp(cs.setConfig.AlertTempMax)
+		}
+		if cs.setConfig.AlertQueueLevel > 0 {
+			cs.checkQueue(cs.setConfig.AlertQueueLevel)
+		}
+	}
+}
+
+func (cs *CS) checkTemp(max float64) {
+	temp, err := cs.client.Temp()
+	if err != nil {
+		return // handled by offline alert
+	}
+	switch {
+	case temp > max:
+		cs.gw.RaiseAlert(cs.alertID("temp"), gateway.SeverityWarning, fmt.Sprintf("command station %s temperature %.1f°C above %.1f°C", cs.name(), temp, max))
+	case temp < max-alertTempHysteresis:
+		cs.gw.ClearAlert(cs.alertID("temp"))
+	}
+}
+
+// queueFill returns the fill level in percent of the fullest command queue (command handler or worker queues).
+func (cs *CS) queueFill() uint {
+	fill := len(cs.hndCh) * 100 / cap(cs.hndCh)
+	for _, ch := range cs.workers {
+		if f := len(ch) * 100 / cap(ch); f > fill {
+			fill = f
+		}
+	}
+	return uint(fill)
+}
+
+func (cs *CS) checkQueue(level uint) {
+	fill := cs.queueFill()
+	switch {
+	case fill >= level:
+		cs.gw.RaiseAlert(cs.alertID("queue"), gateway.SeverityWarning, fmt.Sprintf("command station %s command queue %d%% full (limit %d%%)", cs.name(), fill, level))
+	case fill < level/2:
+		cs.gw.ClearAlert(cs.alertID("queue"))
+	}
+}
@@ -0,0 +1,23 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+func TestQueueFill(t *testing.T) {
+	cs := &CS{hndCh: make(chan *gateway.HndMsg, 10)}
+	cs.hndCh <- &gateway.HndMsg{}
+	if fill := cs.queueFill(); fill != 10 {
+		t.Fatalf("command handler queue fill %d - expected 10", fill)
+	}
+
+	cs.workers = []chan *gateway.HndMsg{make(chan *gateway.HndMsg, 4), make(chan *gateway.HndMsg, 4)}
+	for i := 0; i < 3; i++ {
+		cs.workers[1] <- &gateway.HndMsg{}
+	}
+	if fill := cs.queueFill(); fill != 75 {
+		t.Fatalf("worker queue fill %d - expected 75", fill)
+	}
+}
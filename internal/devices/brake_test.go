@@ -0,0 +1,32 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBrake(t *testing.T) {
+	cs := &CS{}
+	cs.slew = newSlewLimiter(cs, 100)
+	loco := &Loco{config: &LocoConfig{Name: "br01", Decel: 20}}
+
+	if rate := loco.config.brakeRate(); rate != DefaultBrakeRate {
+		t.Fatalf("brake rate %d - expected default %d", rate, DefaultBrakeRate)
+	}
+	for _, payload := range []string{"0", "1001"} {
+		if _, err := cs.brakeLoco(loco)(json.RawMessage(payload)); err == nil {
+			t.Fatalf("invalid brake rate %s not detected", payload)
+		}
+	}
+
+	if rate := cs.slew.locoRate(loco, 100, 0); rate != 20 {
+		t.Fatalf("rate %d - expected deceleration rate 20", rate)
+	}
+	cs.slew.brakes[loco] = 200 // active brake overrides the deceleration rate
+	if rate := cs.slew.locoRate(loco, 100, 0); rate != 200 {
+		t.Fatalf("rate %d - expected brake rate 200", rate)
+	}
+	if next, done := next(100, 0, 200); next != 80 || done {
+		t.Fatalf("next braking speed %d %t - expected 80 false", next, done)
+	}
+}
@@ -0,0 +1,167 @@
+package devices
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// Button events.
+const (
+	ButtonPressed = "pressed" // short press
+	ButtonLong    = "long"    // long press
+	ButtonDouble  = "double"  // double click
+)
+
+// Button timing defaults.
+const (
+	DefaultLongPress   = 800 // ms
+	DefaultDoubleClick = 300 // ms
+)
+
+const maxButtonTiming = 5000 // ms
+
+// CSButtonConfig represents configuration data for a panel button connected to a command station input IO.
+type CSButtonConfig struct {
+	// button is pressed on low level (e.g. button switching to ground with pull-up resistor)
+	ActiveLow bool `json:"activeLow" yaml:"activeLow"`
+	// duration in milliseconds a press is held down to be a long press (default 800)
+	LongPress uint `json:"longPress" yaml:"longPress"`
+	// maximum duration in milliseconds between two presses to be a double click (default 300)
+	DoubleClick uint `json:"doubleClick" yaml:"doubleClick"`
+}
+
+func (c *CSButtonConfig) longPress() time.Duration {
+	if c.LongPress == 0 {
+		return DefaultLongPress * time.Millisecond
+	}
+	return time.Duration(c.LongPress) * time.Millisecond
+}
+
+func (c *CSButtonConfig) doubleClick() time.Duration {
+	if c.DoubleClick == 0 {
+		return DefaultDoubleClick * time.Millisecond
+	}
+	return time.Duration(c.DoubleClick) * time.Millisecond
+}
+
+func (c *CSButtonConfig) validate() error {
+	if c.LongPress > maxButtonTiming || c.DoubleClick > maxButtonTiming {
+		return fmt.Errorf("invalid button timing (range 0..%dms)", maxButtonTiming)
+	}
+	return nil
+}
+
+// A buttonDetector detects short press, long press and double click events of a button
+// based on the input state changes of the IO.
+type buttonDetector struct {
+	config  *CSButtonConfig
+	emit    func(event string)
+	mu      sync.Mutex
+	pressed bool
+	long    bool        // long press already emitted for current press
+	longT   *time.Timer // long press timer (running while pressed)
+	clickT  *time.Timer // double click timer (running after a short press)
+}
+
+func newButtonDetector(config *CSButtonConfig, emit func(event string)) *buttonDetector {
+	return &buttonDetector{config: config, emit: emit}
+}
+
+// update processes an input state change.
+func (d *buttonDetector) update(state bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pressed := state != d.config.ActiveLow
+	if pressed == d.pressed {
+		return
+	}
+	d.pressed = pressed
+
+	if pressed {
+		d.long = false
+		var t *time.Timer
+		t = time.AfterFunc(d.config.longPress(), func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.longT != t {
+				return // stopped after firing
+			}
+			d.longT = nil
+			d.long = true
+			if d.clickT != nil { // long press after a short press
+				d.clickT.Stop()
+				d.clickT = nil
+			}
+			d.emit(ButtonLong)
+		})
+		d.longT = t
+		return
+	}
+
+	if d.longT != nil {
+		d.longT.Stop()
+		d.longT = nil
+	}
+	if d.long {
+		return
+	}
+	if d.clickT != nil {
+		d.clickT.Stop()
+		d.clickT = nil
+		d.emit(ButtonDouble)
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d.config.doubleClick(), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.clickT != t {
+			return // stopped after firing
+		}
+		d.clickT = nil
+		d.emit(ButtonPressed)
+	})
+	d.clickT = t
+}
+
+// stop stops the detector timers.
+func (d *buttonDetector) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.longT != nil {
+		d.longT.Stop()
+		d.longT = nil
+	}
+	if d.clickT != nil {
+		d.clickT.Stop()
+		d.clickT = nil
+	}
+}
+
+// newButtons returns the button detectors by GPIO of the button IOs.
+func (cs *CS) newButtons(gw *gateway.Gateway) map[uint][]*buttonDetector {
+	m := map[uint][]*buttonDetector{}
+	for name, io := range cs.config.IOs {
+		if io.Button == nil {
+			continue
+		}
+		topicStrs := []string{"cs", cs.name(), name, "button"}
+		gpio := io.GPIO
+		m[gpio] = append(m[gpio], newButtonDetector(io.Button, func(event string) {
+			gw.Publish(topicStrs, false, event)
+		}))
+	}
+	return m
+}
+
+func (cs *CS) stopButtons() {
+	for _, detectors := range cs.buttons {
+		for _, d := range detectors {
+			d.stop()
+		}
+	}
+}
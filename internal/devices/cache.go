@@ -0,0 +1,70 @@
+package devices
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxGetCacheTTL is the upper limit of the get command cache TTL.
+const maxGetCacheTTL = 10 * time.Second
+
+type getCacheEntry struct {
+	device  string
+	value   any
+	expires time.Time
+}
+
+// A getCache caches the results of get commands for a short time, so that repeated get commands
+// (e.g. of aggressively polling dashboards) do not load the command station connection.
+// The entries of a device are invalidated by any other command addressed to the device and by
+// device state changes of the command station itself (e.g. speed ramps or emergency stops).
+type getCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]getCacheEntry // key: command topic
+}
+
+func newGetCache(ttl time.Duration) *getCache {
+	return &getCache{ttl: ttl, entries: map[string]getCacheEntry{}}
+}
+
+func isGetCmd(topicStrs []string) bool {
+	return len(topicStrs) > 0 && topicStrs[len(topicStrs)-1] == "get"
+}
+
+func (c *getCache) get(topicStrs []string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[strings.Join(topicStrs, "/")]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *getCache) put(topicStrs []string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for topic, entry := range c.entries { // purge expired entries
+		if now.After(entry.expires) {
+			delete(c.entries, topic)
+		}
+	}
+	c.entries[strings.Join(topicStrs, "/")] = getCacheEntry{device: cmdDevice(topicStrs), value: value, expires: now.Add(c.ttl)}
+}
+
+// invalidate removes the entries of the device a command is addressed to.
+func (c *getCache) invalidate(topicStrs []string) { c.invalidateDevice(cmdDevice(topicStrs)) }
+
+// invalidateDevice removes the entries of a device (e.g. loco/<loco name>).
+func (c *getCache) invalidateDevice(device string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, entry := range c.entries {
+		if entry.device == device {
+			delete(c.entries, topic)
+		}
+	}
+}
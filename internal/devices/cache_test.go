@@ -0,0 +1,51 @@
+package devices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetCache(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	c := newGetCache(ttl)
+
+	speedGet := []string{"loco", "br01", "speed", "get"}
+	dirGet := []string{"loco", "br01", "dir", "get"}
+	otherGet := []string{"loco", "br02", "speed", "get"}
+	c.put(speedGet, 42)
+	c.put(dirGet, true)
+	c.put(otherGet, 7)
+
+	if value, ok := c.get(speedGet); !ok || value != 42 {
+		t.Fatalf("cached value %v %t - expected 42", value, ok)
+	}
+
+	c.invalidate([]string{"loco", "br01", "speed", "set"}) // command addressed to the device
+	if _, ok := c.get(speedGet); ok {
+		t.Fatal("speed entry not invalidated by command")
+	}
+	if _, ok := c.get(dirGet); ok {
+		t.Fatal("direction entry not invalidated by command")
+	}
+	if _, ok := c.get(otherGet); !ok {
+		t.Fatal("entry of other device invalidated")
+	}
+
+	c.put(speedGet, 42)
+	c.invalidateDevice("loco/br01") // state change of the command station (e.g. emergency stop)
+	if _, ok := c.get(speedGet); ok {
+		t.Fatal("speed entry not invalidated by state change")
+	}
+
+	c.put([]string{"pb", "loco", "br02", "speed", "get"}, 7) // protocol buffer topic of the same device
+	c.invalidateDevice("loco/br02")
+	if _, ok := c.get([]string{"pb", "loco", "br02", "speed", "get"}); ok {
+		t.Fatal("protocol buffer entry not invalidated")
+	}
+
+	c.put(speedGet, 42)
+	time.Sleep(2 * ttl)
+	if _, ok := c.get(speedGet); ok {
+		t.Fatal("expired entry returned")
+	}
+}
@@ -2,7 +2,9 @@ package devices
 
 import (
 	"fmt"
+	"math"
 	"regexp"
+	"time"
 
 	"github.com/pico-cs/go-client/client"
 	"github.com/pico-cs/mqtt-gateway/internal/gateway"
@@ -83,10 +85,141 @@ func NewFilter() *Filter {
 
 func (f *Filter) filter() (*filter, error) { return newFilter(f.Incls, f.Excls) }
 
+func matchFirst(items []string, name string) (string, error) {
+	for _, item := range items {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			return "", err
+		}
+		if re.MatchString(name) {
+			return item, nil
+		}
+	}
+	return "", nil
+}
+
+// Match returns the first including and the first excluding regular expression matching
+// the device name (empty string if no regular expression does match).
+// The device is included by the filter if incl is not empty and excl is empty.
+func (f *Filter) Match(name string) (incl, excl string, err error) {
+	if incl, err = matchFirst(f.Incls, name); err != nil {
+		return "", "", err
+	}
+	if excl, err = matchFirst(f.Excls, name); err != nil {
+		return "", "", err
+	}
+	return incl, excl, nil
+}
+
+// Stop modes.
+const (
+	StopNone      = "none"
+	StopEmergency = "emergency"
+	StopRamp      = "ramp"
+)
+
+// DefaultStopRamp is the default duration of a ramped stop.
+const DefaultStopRamp = 2 * time.Second
+
+// DefaultPingInterval is the default interval of the command station keepalive ping.
+const DefaultPingInterval = 10 * time.Second
+
+// DefaultStatsInterval is the default interval of publishing the command statistics.
+const DefaultStatsInterval = time.Minute
+
+// CSSetConfig represents configuration data for a command station set.
+type CSSetConfig struct {
+	// stop mode applied to all primary locos on closing a command station (none, emergency or ramp)
+	StopOnClose string
+	// duration of a ramped stop
+	StopRamp time.Duration
+	// default maximum speed change of a loco in speed steps per second (0: unlimited)
+	MaxSpeedRate uint
+	// MQTT broker connection loss duration after which the watchdog is triggered (0: watchdog disabled)
+	WatchdogTimeout time.Duration
+	// stop mode applied by the watchdog to all primary locos (none, emergency or ramp)
+	WatchdogStop string
+	// disable track power (main track DCC output) by the watchdog
+	WatchdogPowerOff bool
+	// interval of the command station keepalive ping (0: disabled)
+	PingInterval time.Duration
+	// interval of publishing the command statistics (0: disabled)
+	StatsInterval time.Duration
+	// duration a command station needs to be offline to raise an alert (0: disabled)
+	AlertOfflineTimeout time.Duration
+	// command station temperature in degree Celsius above which an alert is raised (0: disabled)
+	AlertTempMax float64
+	// command queue fill level in percent at which an alert is raised (0: disabled)
+	AlertQueueLevel uint
+	// interval the temperature and command queue alert rules are checked
+	AlertInterval time.Duration
+	// duration the results of get commands are cached (0: disabled)
+	GetCacheTTL time.Duration
+	// promote an available secondary command station of the primary locos in case the primary command
+	// station becomes unavailable
+	Failover bool
+}
+
+// NewCSSetConfig returns a new CSSetConfig instance.
+func NewCSSetConfig() *CSSetConfig {
+	return &CSSetConfig{StopOnClose: StopNone, StopRamp: DefaultStopRamp, WatchdogStop: StopNone, PingInterval: DefaultPingInterval, StatsInterval: DefaultStatsInterval, AlertInterval: DefaultAlertInterval}
+}
+
+func checkStopMode(mode string) error {
+	switch mode {
+	case StopNone, StopEmergency, StopRamp:
+		return nil
+	default:
+		return fmt.Errorf("invalid stop mode %s (none, emergency or ramp)", mode)
+	}
+}
+
+func (c *CSSetConfig) validate() error {
+	if err := checkStopMode(c.StopOnClose); err != nil {
+		return fmt.Errorf("CSSetConfig stop on close: %s", err)
+	}
+	if err := checkStopMode(c.WatchdogStop); err != nil {
+		return fmt.Errorf("CSSetConfig watchdog stop: %s", err)
+	}
+	if c.StatsInterval < 0 {
+		return fmt.Errorf("CSSetConfig invalid stats interval %s (needs to be greater or equal zero)", c.StatsInterval)
+	}
+	if c.PingInterval < 0 {
+		return fmt.Errorf("CSSetConfig invalid ping interval %s (needs to be greater or equal zero)", c.PingInterval)
+	}
+	if c.WatchdogTimeout < 0 {
+		return fmt.Errorf("CSSetConfig invalid watchdog timeout %s (needs to be greater or equal zero)", c.WatchdogTimeout)
+	}
+	if c.StopRamp < 0 {
+		return fmt.Errorf("CSSetConfig invalid stop ramp %s (needs to be greater or equal zero)", c.StopRamp)
+	}
+	if c.AlertOfflineTimeout < 0 {
+		return fmt.Errorf("CSSetConfig invalid alert offline timeout %s (needs to be greater or equal zero)", c.AlertOfflineTimeout)
+	}
+	if c.AlertQueueLevel > 100 {
+		return fmt.Errorf("CSSetConfig invalid alert queue level %d (range 0..100)", c.AlertQueueLevel)
+	}
+	if c.GetCacheTTL < 0 || c.GetCacheTTL > maxGetCacheTTL {
+		return fmt.Errorf("CSSetConfig invalid get cache TTL %s (range 0..%s)", c.GetCacheTTL, maxGetCacheTTL)
+	}
+	if (c.AlertTempMax > 0 || c.AlertQueueLevel > 0) && c.AlertInterval <= 0 {
+		return fmt.Errorf("CSSetConfig invalid alert interval %s (needs to be greater zero)", c.AlertInterval)
+	}
+	return nil
+}
+
 // CSIOConfig represents configuration data for a command station IO.
 type CSIOConfig struct {
 	// command station GPIO
 	GPIO uint `json:"gpio"`
+	// output IO controllable via command topics
+	Out bool `json:"out"`
+	// IO type (empty: digital GPIO, solenoid: solenoid turnout drive controlled via turnout topics)
+	Type string `json:"type"`
+	// solenoid configuration (type solenoid only)
+	Solenoid CSSolenoidConfig `json:"solenoid"`
+	// panel button detecting short press, long press and double click events (input IOs only, nil: no button)
+	Button *CSButtonConfig `json:"button"`
 }
 
 // CSConfig represents configuration data for a command station.
@@ -95,7 +228,8 @@ type CSConfig struct {
 	Name string `json:"name"`
 	// pico_w host in case of WiFi TCP/IP connection
 	Host string `json:"host"`
-	// TCP/IP port (WiFi) or serial port (serial over USB)
+	// TCP/IP port (WiFi), serial port (serial over USB) or
+	// bluetooth serial connection string bt://<mac>[/<RFCOMM channel>]
 	Port string `json:"port"`
 	// filter of devices for which this command station should be a primary device
 	Primary *Filter `json:"primary"`
@@ -103,6 +237,38 @@ type CSConfig struct {
 	Secondary *Filter `json:"secondary"`
 	// command station IO mapping (key is used in topic)
 	IOs map[string]CSIOConfig `json:"ios"`
+	// output patterns (e.g. crossing flashers) controllable via <topic root>/cs/<name>/<pattern name>/set
+	Patterns map[string]CSPatternConfig `json:"patterns"`
+	// light signals controllable via <topic root>/signal/<signal name>/aspect/set
+	Signals map[string]CSSignalConfig `json:"signals"`
+	// track power (main track DCC output) handling
+	Power *CSPowerConfig `json:"power"`
+	// maximum speed change of a loco in speed steps per second (0: command station set default)
+	MaxSpeedRate uint `json:"maxSpeedRate" yaml:"maxSpeedRate"`
+	// number of command workers (default 1) - the commands of a device (e.g. a loco) are executed in order by
+	// the same worker; as the command station round-trips are executed one at a time the workers isolate the
+	// devices from a blocking command of another device only (no pipelining)
+	Workers uint `json:"workers"`
+}
+
+// maxWorkers is the upper limit of command workers of a command station.
+const maxWorkers = 16
+
+func (c *CSConfig) workers() uint {
+	if c.Workers == 0 {
+		return 1
+	}
+	return c.Workers
+}
+
+// CSPowerConfig represents the track power (main track DCC output) configuration of a command station.
+type CSPowerConfig struct {
+	// enable track power after the command station was opened
+	EnableOnStart bool `json:"enableOnStart" yaml:"enableOnStart"`
+	// disable track power before the command station is closed
+	DisableOnClose bool `json:"disableOnClose" yaml:"disableOnClose"`
+	// disable track power on MQTT broker connection loss
+	DisableOnBrokerLoss bool `json:"disableOnBrokerLoss" yaml:"disableOnBrokerLoss"`
 }
 
 // NewCSConfig returns a new CSConfig instance.
@@ -111,22 +277,68 @@ func NewCSConfig() *CSConfig {
 		Primary:   NewFilter(),
 		Secondary: NewFilter(),
 		IOs:       map[string]CSIOConfig{},
+		Patterns:  map[string]CSPatternConfig{},
+		Signals:   map[string]CSSignalConfig{},
+		Power:     &CSPowerConfig{},
 	}
 }
 
-func (c *CSConfig) validate() error {
+// Validate validates the command station configuration.
+func (c *CSConfig) Validate() error {
 	if err := gateway.CheckLevelName(c.Name); err != nil {
 		return fmt.Errorf("CSConfig name %s: %s", c.Name, err)
 	}
+	if c.Primary == nil { // e.g. "primary: null"
+		c.Primary = NewFilter()
+	}
+	if c.Secondary == nil {
+		c.Secondary = NewFilter()
+	}
+	if _, err := c.Primary.filter(); err != nil {
+		return fmt.Errorf("CSConfig name %s: primary filter: %s", c.Name, err)
+	}
+	if _, err := c.Secondary.filter(); err != nil {
+		return fmt.Errorf("CSConfig name %s: secondary filter: %s", c.Name, err)
+	}
+	if c.Power == nil { // e.g. "power: null"
+		c.Power = &CSPowerConfig{}
+	}
+	if c.Host == "" && isRFCOMM(c.Port) {
+		if _, err := parseRFCOMMAddr(c.Port); err != nil {
+			return fmt.Errorf("CSConfig name %s: port: %s", c.Name, err)
+		}
+	}
+	if err := c.validateIOs(); err != nil {
+		return fmt.Errorf("CSConfig name %s: %s", c.Name, err)
+	}
+	if err := c.validatePatterns(); err != nil {
+		return fmt.Errorf("CSConfig name %s: %s", c.Name, err)
+	}
+	if err := c.validateSignals(); err != nil {
+		return fmt.Errorf("CSConfig name %s: %s", c.Name, err)
+	}
+	if c.Workers > maxWorkers {
+		return fmt.Errorf("CSConfig name %s: invalid workers %d (range 0..%d)", c.Name, c.Workers, maxWorkers)
+	}
 	return nil
 }
 
+// isSerial returns true in case of a serial over USB connection.
+func (c *CSConfig) isSerial() bool { return c.Host == "" && !isRFCOMM(c.Port) }
+
 func (c *CSConfig) conn() (client.Conn, error) {
-	if c.Host != "" { // TCP connection
+	switch {
+	case c.Host != "": // TCP connection
 		return client.NewTCPClient(c.Host, c.Port)
+	case isRFCOMM(c.Port): // bluetooth serial connection
+		addr, err := parseRFCOMMAddr(c.Port)
+		if err != nil {
+			return nil, err
+		}
+		return newRFCOMMConn(addr)
+	default: // serial connection
+		return client.NewSerial(c.Port)
 	}
-	// serial connection
-	return client.NewSerial(c.Port)
 }
 
 // LocoFctConfig represents configuration data for a loco function.
@@ -141,29 +353,120 @@ type LocoConfig struct {
 	Name string `json:"name"`
 	// loco decoder address
 	Addr uint `json:"addr"`
+	// maximum speed (range 0..126) a throttle value of 1.0 is mapped to (default 0: 126)
+	MaxSpeed uint `json:"maxSpeed" yaml:"maxSpeed"`
+	// exponent of the throttle curve mapping throttle values to speed (default 1.0: linear)
+	Curve float64 `json:"curve"`
 	// loco function mapping (key is used in topic)
 	Fcts map[string]LocoFctConfig `json:"fcts"`
+	// scale speed in km/h at maximum speed step 126 estimating the driven distance (0: no distance estimation)
+	ScaleSpeed float64 `json:"scaleSpeed" yaml:"scaleSpeed"`
+	// maintenance intervals (key is the maintenance name)
+	Maintenance map[string]LocoMaintenanceConfig `json:"maintenance"`
+	// braking rate of the brake command in speed steps per second (default 40)
+	BrakeRate uint `json:"brakeRate" yaml:"brakeRate"`
+	// acceleration rate in speed steps per second (default: command station maxSpeedRate)
+	Accel uint `json:"accel"`
+	// deceleration rate in speed steps per second (default: command station maxSpeedRate)
+	Decel uint `json:"decel"`
+	// groups of mutually exclusive functions (enabling a function disables the other functions of the group)
+	ExclFcts [][]string `json:"exclFcts" yaml:"exclFcts"`
+	// functions switched automatically on direction change (nil: no automatic switching)
+	DirLights *LocoDirLightsConfig `json:"dirLights" yaml:"dirLights"`
+	// speed steps of the decoder (14, 28 or 128 - default 128): speed values of the speed and velocity
+	// topics are given in the speed step range of the loco (0..14, 0..28 or 0..126)
+	SpeedSteps uint `json:"speedSteps" yaml:"speedSteps"`
 }
 
 // NewLocoConfig returns a new LocoConfig instance.
 func NewLocoConfig() *LocoConfig {
-	return &LocoConfig{Fcts: map[string]LocoFctConfig{}}
+	return &LocoConfig{MaxSpeed: maxSpeed, Curve: 1, Fcts: map[string]LocoFctConfig{}, Maintenance: map[string]LocoMaintenanceConfig{}, ExclFcts: [][]string{}}
+}
+
+// throttleSpeed maps a throttle value (range 0.0..1.0) to a speed via curve and max speed.
+func (c *LocoConfig) throttleSpeed(throttle float64) speed127 {
+	max, curve := c.MaxSpeed, c.Curve
+	if max == 0 {
+		max = maxSpeed
+	}
+	if curve == 0 {
+		curve = 1
+	}
+	return speed127(math.Round(float64(max) * math.Pow(throttle, curve)))
+}
+
+// DefaultBrakeRate is the default braking rate in speed steps per second.
+const DefaultBrakeRate = 40
+
+// maxBrakeRate is the maximum braking rate in speed steps per second.
+const maxBrakeRate = 1000
+
+func (c *LocoConfig) brakeRate() uint {
+	if c.BrakeRate == 0 {
+		return DefaultBrakeRate
+	}
+	return c.BrakeRate
+}
+
+// momentum returns the acceleration or deceleration rate changing the loco speed towards target
+// (default: rate).
+func (c *LocoConfig) momentum(speed, target speed127, rate uint) uint {
+	switch {
+	case target > speed && c.Accel != 0:
+		return c.Accel
+	case target < speed && c.Decel != 0:
+		return c.Decel
+	default:
+		return rate
+	}
 }
 
 // ReservedFctNames is the list of reserved function names which cannot be used in loco configurations.
-var ReservedFctNames = []string{"dir", "speed"}
+var ReservedFctNames = []string{"dir", "speed", "velocity", "drive", "odometer", "maintenance", "shunt", "pair", "primary", "fg0", "fg1", "fg2", "fg3", "fg4"}
 
 // make sure, that reserved names cannot be changed.
 var reservedFctNames = slices.Clone(ReservedFctNames)
 
-func (c *LocoConfig) validate() error {
+// Validate validates the loco configuration.
+func (c *LocoConfig) Validate() error {
 	if err := gateway.CheckLevelName(c.Name); err != nil {
 		return fmt.Errorf("LocoConfig name %s: %s", c.Name, err)
 	}
+	if c.MaxSpeed > maxSpeed {
+		return fmt.Errorf("LocoConfig name %s: invalid max speed %d (range 0..%d)", c.Name, c.MaxSpeed, maxSpeed)
+	}
+	if err := c.validateSpeedSteps(); err != nil {
+		return fmt.Errorf("LocoConfig name %s: %s", c.Name, err)
+	}
+	if c.Curve < 0 {
+		return fmt.Errorf("LocoConfig name %s: invalid curve %f (needs to be greater or equal zero)", c.Name, c.Curve)
+	}
+	if c.ScaleSpeed < 0 {
+		return fmt.Errorf("LocoConfig name %s: invalid scale speed %f (needs to be greater or equal zero)", c.Name, c.ScaleSpeed)
+	}
+	for name, mConfig := range c.Maintenance {
+		if mConfig.Hours < 0 || mConfig.Km < 0 || (mConfig.Hours == 0 && mConfig.Km == 0) {
+			return fmt.Errorf("LocoConfig name %s: maintenance %s: invalid interval (hours or km need to be greater zero)", c.Name, name)
+		}
+	}
 	for name := range c.Fcts {
 		if slices.Contains(reservedFctNames, name) {
 			return fmt.Errorf("LocoConfig name %s: function name %s is reserved", c.Name, name)
 		}
 	}
+	if c.BrakeRate > maxBrakeRate {
+		return fmt.Errorf("LocoConfig name %s: invalid brake rate %d (range 0..%d)", c.Name, c.BrakeRate, maxBrakeRate)
+	}
+	if c.Accel > maxBrakeRate || c.Decel > maxBrakeRate {
+		return fmt.Errorf("LocoConfig name %s: invalid acceleration or deceleration rate (range 0..%d)", c.Name, maxBrakeRate)
+	}
+	if err := c.validateExclFcts(); err != nil {
+		return fmt.Errorf("LocoConfig name %s: %s", c.Name, err)
+	}
+	if c.DirLights != nil {
+		if err := c.DirLights.validate(c.Fcts); err != nil {
+			return fmt.Errorf("LocoConfig name %s: dirLights: %s", c.Name, err)
+		}
+	}
 	return nil
 }
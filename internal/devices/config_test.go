@@ -0,0 +1,24 @@
+package devices
+
+import "testing"
+
+func TestThrottleSpeed(t *testing.T) {
+	tests := []struct {
+		config   *LocoConfig
+		throttle float64
+		speed    speed127
+	}{
+		{NewLocoConfig(), 1, maxSpeed},
+		{NewLocoConfig(), 0.5, 63},
+		{NewLocoConfig(), 0, 0},
+		{&LocoConfig{MaxSpeed: 100, Curve: 2}, 0.5, 25},
+		{&LocoConfig{MaxSpeed: 100, Curve: 2}, 1, 100},
+		{&LocoConfig{}, 1, maxSpeed}, // zero max speed and curve: defaults
+		{&LocoConfig{}, 0.5, 63},
+	}
+	for _, test := range tests {
+		if speed := test.config.throttleSpeed(test.throttle); speed != test.speed {
+			t.Fatalf("max speed %d curve %f throttle %f: speed %d - expected %d", test.config.MaxSpeed, test.config.Curve, test.throttle, speed, test.speed)
+		}
+	}
+}
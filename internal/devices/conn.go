@@ -0,0 +1,132 @@
+package devices
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pico-cs/go-client/client"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// reopenInterval is the polling interval waiting for a detached serial device to reappear.
+const reopenInterval = time.Second
+
+// errDetached is returned by writes while the serial device is detached.
+var errDetached = errors.New("serial device detached")
+
+// A reconnConn is a serial connection reopening the serial port after the device
+// was detached (USB hot-plug) and reattached. Reads are blocked until the device is
+// reattached, so that the client does not notice the interruption.
+type reconnConn struct {
+	lg       logger.Logger
+	portName string // empty: auto-detection
+	onState  func(attached bool)
+
+	mu      sync.RWMutex
+	conn    client.Conn // nil while detached
+	closed  bool
+	closeCh chan struct{}
+}
+
+func newReconnConn(lg logger.Logger, portName string, conn client.Conn, onState func(attached bool)) *reconnConn {
+	return &reconnConn{lg: lg, portName: portName, onState: onState, conn: conn, closeCh: make(chan struct{})}
+}
+
+func (c *reconnConn) name() string {
+	if c.portName == "" {
+		return "(auto-detection)"
+	}
+	return c.portName
+}
+
+func (c *reconnConn) current() (client.Conn, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn, c.closed
+}
+
+// Read implements the client.Conn interface.
+func (c *reconnConn) Read(p []byte) (int, error) {
+	for {
+		conn, closed := c.current()
+		if closed {
+			return 0, io.EOF
+		}
+		n, err := conn.Read(p)
+		if err == nil {
+			return n, nil
+		}
+		if _, closed := c.current(); closed {
+			return n, err
+		}
+		c.lg.Printf("serial device %s detached: %s", c.name(), err)
+		go c.onState(false)
+		if !c.reopen() {
+			return 0, io.EOF
+		}
+		go c.onState(true) // cannot be called synchronously as the client reader is blocked
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// reopen polls for the serial device to reappear and reopens the connection.
+// reopen returns false if the connection was closed in the meantime.
+func (c *reconnConn) reopen() bool {
+	c.mu.Lock()
+	c.conn.Close() // ignore error
+	c.conn = nil
+	c.mu.Unlock()
+
+	for {
+		select {
+		case <-c.closeCh:
+			return false
+		case <-time.After(reopenInterval):
+		}
+		conn, err := client.NewSerial(c.portName)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return false
+		}
+		c.conn = conn
+		c.mu.Unlock()
+		c.lg.Printf("serial device %s reattached", c.name())
+		return true
+	}
+}
+
+// Write implements the client.Conn interface.
+func (c *reconnConn) Write(p []byte) (int, error) {
+	conn, closed := c.current()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	if conn == nil {
+		return 0, errDetached
+	}
+	return conn.Write(p)
+}
+
+// Close implements the client.Conn interface.
+func (c *reconnConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.closeCh)
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
@@ -3,36 +3,102 @@ package devices
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pico-cs/go-client/client"
 	"github.com/pico-cs/mqtt-gateway/internal/gateway"
 	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
 	"golang.org/x/exp/maps"
 )
 
 // CSSet represents a set of command stations.
 type CSSet struct {
-	lg    logger.Logger
-	gw    *gateway.Gateway
-	csMap map[string]*CS
+	lg        logger.Logger
+	gw        *gateway.Gateway
+	config    *CSSetConfig
+	connHooks *hookList[func(cs *CS, connected bool)]
+	mu        sync.RWMutex
+	csMap     map[string]*CS
 }
 
 // NewCSSet creates new command station set instance.
-func NewCSSet(lg logger.Logger, gw *gateway.Gateway) *CSSet {
+func NewCSSet(lg logger.Logger, gw *gateway.Gateway, config *CSSetConfig) (*CSSet, error) {
+	if config == nil {
+		config = NewCSSetConfig()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
 	if lg == nil {
 		lg = logger.Null
 	}
-	return &CSSet{lg: lg, gw: gw, csMap: make(map[string]*CS)}
+	s := &CSSet{lg: lg, gw: gw, config: config, connHooks: &hookList[func(cs *CS, connected bool)]{}, csMap: make(map[string]*CS)}
+	if config.Failover {
+		s.connHooks.add(func(cs *CS, connected bool) {
+			if !connected {
+				go s.failover(cs) // hook must not block
+			}
+		})
+	}
+	return s, nil
 }
 
+// OnCSConnected registers a callback function called whenever the connection state of a command station
+// of the set changes (command station opened or closed, serial device detached or reattached, keepalive
+// ping failed or succeeded again). The callback functions are called asynchronously in the order of the
+// connection state changes of a command station and without any lock of the set held, so that they are
+// free to call the set methods (e.g. Items). A blocking callback function delays the subsequent calls.
+func (s *CSSet) OnCSConnected(fn func(cs *CS, connected bool)) { s.connHooks.add(fn) }
+
 // Items returns a command station map.
-func (s *CSSet) Items() map[string]*CS { return maps.Clone(s.csMap) }
+func (s *CSSet) Items() map[string]*CS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.csMap)
+}
+
+// EmergencyStop sends an emergency stop to all locos of all command stations. The command stations are
+// stopped concurrently and directly (not queued like commands received by the broker).
+func (s *CSSet) EmergencyStop() {
+	var wg sync.WaitGroup
+	for _, cs := range s.Items() {
+		wg.Add(1)
+		go func(cs *CS) {
+			defer wg.Done()
+			cs.emergencyStop()(nil)
+		}(cs)
+	}
+	wg.Wait()
+}
+
+// ServeEmergencyStop executes an emergency stop of all command stations (POST only).
+func (s *CSSet) ServeEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		server.MethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !server.RequireJSON(w, r) {
+		return
+	}
+	s.lg.Printf("http emergency stop requested by %s", r.RemoteAddr)
+	s.EmergencyStop()
+	w.WriteHeader(http.StatusNoContent)
+}
 
 // Add adds a command station via a command station configuration.
 func (s *CSSet) Add(config *CSConfig) (*CS, error) {
-	cs, err := newCS(s.lg, config, s.gw)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.csMap[config.Name]; ok {
+		return nil, fmt.Errorf("command station %s already exists", config.Name)
+	}
+	cs, err := newCS(s.lg, config, s.config, s.connHooks, s.gw)
 	if err != nil {
 		return nil, err
 	}
@@ -40,11 +106,36 @@ func (s *CSSet) Add(config *CSConfig) (*CS, error) {
 	return cs, nil
 }
 
-// Close closes all command stations.
+// Delete closes and removes a command station.
+func (s *CSSet) Delete(name string) error {
+	s.mu.Lock()
+	cs, ok := s.csMap[name]
+	delete(s.csMap, name)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("command station %s does not exist", name)
+	}
+	return cs.close()
+}
+
+// Close closes all command stations concurrently (stopping locos might take a while).
 func (s *CSSet) Close() error {
-	var lastErr error
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(s.csMap))
 	for _, cs := range s.csMap {
-		if err := cs.close(); err != nil {
+		wg.Add(1)
+		go func(cs *CS) {
+			defer wg.Done()
+			errs <- cs.close()
+		}(cs)
+	}
+	wg.Wait()
+	close(errs)
+	var lastErr error
+	for err := range errs {
+		if err != nil {
 			lastErr = err
 		}
 	}
@@ -111,37 +202,82 @@ func (s *CSSet) idxTplExecute(w io.Writer) error {
 
 // ServeHTTP implements the http.Handler interface.
 func (s *CSSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	data := csTplData{CSMap: map[string]csTpl{}}
-	for name, cs := range s.csMap {
+	// path: /cs[/<command station name>]
+	parts := splitPath(r.URL.Path)
+	csMap := s.Items()
+	if len(parts) > 1 {
+		cs, ok := csMap[parts[1]]
+		switch {
+		case !ok:
+			server.NotFound(w, r)
+		case len(parts) == 2:
+			cs.ServeHTTP(w, r)
+		case len(parts) == 3 && parts[2] == "stats":
+			cs.serveStats(w, r)
+		default:
+			server.NotFound(w, r)
+		}
+		return
+	}
+
+	data := csTplData{tplData: tplData{BasePath: server.BasePath(r)}, CSMap: map[string]csTpl{}}
+	for name, cs := range csMap {
 		data.CSMap[name] = csTpl{
+			Available:   cs.Available(),
 			Primaries:   cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) }),
 			Secondaries: cs.filterLocos(func(loco *Loco) bool { return loco.isSecondary(cs) }),
 		}
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if err := csIdxTpl.Execute(w, data); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
+	executeTemplate(w, csIdxTpl, data)
 }
 
 // A CS represents a command station.
 type CS struct {
 	lg        logger.Logger
 	config    *CSConfig
+	setConfig *CSSetConfig
+	slew      *slewLimiter
 	gw        *gateway.Gateway
 	primary   *filter
 	secondary *filter
 	hndCh     chan *gateway.HndMsg
+	workers   []chan *gateway.HndMsg // command worker queues (nil: commands are executed by the command handler)
 	wg        *sync.WaitGroup
 	client    *client.Client
+	mu        sync.RWMutex
 	locos     map[string]*Loco
+
+	watchdogMu sync.Mutex
+	watchdog   *time.Timer // broker connection loss watchdog
+
+	rampMu   sync.Mutex
+	rampStop chan struct{} // closed to cancel the running slow stop ramp (nil: no ramp running)
+	rampWg   sync.WaitGroup
+
+	done chan struct{} // closed on close
+
+	stats *cmdStats
+
+	cache *getCache // nil: get command cache disabled
+
+	ioNames  map[uint][]string          // IO names by GPIO
+	turnouts map[string]bool            // turnout positions by IO name (true: thrown) - accessed by the command worker of the command station only
+	buttons  map[uint][]*buttonDetector // button detectors by GPIO
+	pulses   *coilPulses                // pending solenoid coil pulses
+
+	connMu       sync.Mutex
+	connected    bool
+	offlineTimer *time.Timer // offline alert timer
+	connHooks    *hookList[func(cs *CS, connected bool)]
+	connEvents   eventQueue[bool] // connection state changes the connection hooks are called for
+	speedHooks   hookList[func(loco *Loco, speed uint)]
 }
 
 // newCS returns a new command station instance.
-func newCS(lg logger.Logger, config *CSConfig, gw *gateway.Gateway) (*CS, error) {
-	if err := config.validate(); err != nil {
+func newCS(lg logger.Logger, config *CSConfig, setConfig *CSSetConfig, connHooks *hookList[func(cs *CS, connected bool)], gw *gateway.Gateway) (*CS, error) {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
@@ -157,13 +293,35 @@ func newCS(lg logger.Logger, config *CSConfig, gw *gateway.Gateway) (*CS, error)
 	cs := &CS{
 		lg:        lg,
 		config:    config,
+		setConfig: setConfig,
 		gw:        gw,
 		primary:   primary,
 		secondary: secondary,
 		hndCh:     make(chan *gateway.HndMsg, gateway.DefChanSize),
 		wg:        new(sync.WaitGroup),
 		locos:     map[string]*Loco{},
+		done:      make(chan struct{}),
+		stats:     newCmdStats(),
+		connHooks: connHooks,
+		ioNames:   config.ioNames(),
+		turnouts:  map[string]bool{},
+		pulses:    newCoilPulses(),
 	}
+	cs.buttons = cs.newButtons(gw)
+	if n := config.workers(); n > 1 {
+		cs.workers = make([]chan *gateway.HndMsg, n)
+		for i := range cs.workers {
+			cs.workers[i] = make(chan *gateway.HndMsg, gateway.DefChanSize)
+		}
+	}
+	if setConfig.GetCacheTTL > 0 {
+		cs.cache = newGetCache(setConfig.GetCacheTTL)
+	}
+	maxSpeedRate := config.MaxSpeedRate
+	if maxSpeedRate == 0 {
+		maxSpeedRate = setConfig.MaxSpeedRate
+	}
+	cs.slew = newSlewLimiter(cs, maxSpeedRate)
 
 	// open
 	cs.lg.Printf("open command station %s", cs.name())
@@ -171,20 +329,84 @@ func newCS(lg logger.Logger, config *CSConfig, gw *gateway.Gateway) (*CS, error)
 	if err != nil {
 		return nil, err
 	}
+	if cs.config.isSerial() {
+		conn = newReconnConn(lg, cs.config.Port, conn, cs.serialHandler)
+	}
 	cs.client = client.New(conn, cs.pushHandler(gw))
 
 	// start go routines
+	cs.wg.Add(1)
 	go cs.cmdHandler(cs.wg, cs.hndCh, gw)
+	if setConfig.PingInterval > 0 {
+		go cs.pinger(setConfig.PingInterval)
+	}
+	if setConfig.StatsInterval > 0 {
+		go cs.statsPublisher(setConfig.StatsInterval)
+	}
+	if setConfig.AlertTempMax > 0 || setConfig.AlertQueueLevel > 0 {
+		go cs.alertMonitor(setConfig.AlertInterval)
+	}
 
 	cs.subscribe()
 
+	if cs.config.Power.EnableOnStart {
+		cs.setPower(true)
+	}
+
+	cs.setConnected(true)
+
 	return cs, nil
 }
 
 func (cs *CS) name() string { return cs.config.Name }
 
+// Config returns the command station configuration.
+func (cs *CS) Config() *CSConfig { return cs.config }
+
+// String implements the fmt.Stringer interface.
+func (cs *CS) String() string { return fmt.Sprintf("cs %s", cs.name()) }
+
+// OnLocoSpeedChanged registers a callback function called whenever the speed of a loco
+// controlled by this command station changes. The callback function must not block.
+func (cs *CS) OnLocoSpeedChanged(fn func(loco *Loco, speed uint)) { cs.speedHooks.add(fn) }
+
+// Available returns true if the command station is connected and responding to the keepalive pings.
+func (cs *CS) Available() bool {
+	cs.connMu.Lock()
+	defer cs.connMu.Unlock()
+	return cs.connected
+}
+
+// setConnected sets the connection state and publishes the availability and calls the connection
+// callback functions on changes.
+func (cs *CS) setConnected(connected bool) {
+	cs.connMu.Lock()
+	defer cs.connMu.Unlock()
+	if cs.connected == connected {
+		return
+	}
+	cs.connected = connected
+	cs.gw.Publish([]string{"cs", cs.name(), "available"}, true, connected)
+	cs.offlineAlert(connected)
+	cs.connEvents.push(connected, cs.callConnHooks)
+}
+
+func (cs *CS) callConnHooks(connected bool) {
+	cs.connHooks.each(func(fn func(cs *CS, connected bool)) { fn(cs, connected) })
+}
+
+// serialHandler handles serial device detach and reattach events.
+func (cs *CS) serialHandler(attached bool) {
+	cs.setConnected(attached)
+	if attached {
+		cs.resync()
+	}
+}
+
 // filterLocos returns a map of locos filtered by function filter.
 func (cs *CS) filterLocos(filter func(loco *Loco) bool) map[string]*Loco {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
 	locos := map[string]*Loco{}
 	for name, loco := range cs.locos {
 		if filter(loco) {
@@ -197,21 +419,192 @@ func (cs *CS) filterLocos(filter func(loco *Loco) bool) map[string]*Loco {
 // close closes the command station and the underlying client connection.
 func (cs *CS) close() error {
 	cs.lg.Printf("close command station %s", cs.name())
+	cs.mu.Lock()
+	var primaries []*Loco
 	for _, loco := range cs.locos {
 		if loco.isPrimary(cs) {
-			loco.unsetPrimary(cs) // ignore error
-			cs.unsubscribeLocoActions(loco)
-		} else {
-			loco.delSecondary(cs) // ignore error
-			cs.unsubscribeLocoEvents(loco)
+			primaries = append(primaries, loco)
 		}
+		cs.removeLoco(loco)
 	}
+	cs.mu.Unlock()
 	cs.unsubscribe()
+	// no more commands are dispatched after unsubscribing - stop the command handler
+	close(cs.hndCh)
+	cs.wg.Wait()
+	cs.pulses.stop() // switch the solenoid coils off before the client is closed
+	close(cs.done)
+	cs.resetWatchdog()
+	cs.stopLocos(primaries, cs.setConfig.StopOnClose)
+	if cs.config.Power.DisableOnClose {
+		cs.setPower(false)
+	}
+	cs.stopButtons()
+	cs.setConnected(false)
+	cs.clearAlerts()
 	return cs.client.Close()
 }
 
+// Latency represents the keepalive ping statistics of a command station.
+type Latency struct {
+	// round-trip time of the last successful ping in milliseconds
+	RTT float64 `json:"rtt"`
+	// number of missed pings (total)
+	Missed uint `json:"missed"`
+	// number of consecutively missed pings
+	ConsecutiveMissed uint `json:"consecutiveMissed"`
+}
+
+// pinger pings the command station periodically and publishes the latency event.
+func (cs *CS) pinger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	latency := &Latency{}
+	for {
+		select {
+		case <-cs.done:
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		if _, err := cs.client.MTE(); err != nil {
+			latency.Missed++
+			latency.ConsecutiveMissed++
+			cs.lg.Printf("command station %s: ping: %s", cs.name(), err)
+			cs.setConnected(false)
+		} else {
+			latency.RTT = float64(time.Since(start).Microseconds()) / 1000
+			latency.ConsecutiveMissed = 0
+			cs.setConnected(true)
+		}
+		l := *latency
+		cs.gw.PublishTelemetry([]string{"cs", cs.name(), "latency"}, true, &l)
+	}
+}
+
+// resync restores the state of the primary locos and the track power after the
+// command station connection was reestablished (e.g. after a USB hot-plug).
+func (cs *CS) resync() {
+	cs.lg.Printf("command station %s: resync state", cs.name())
+	locos := cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) })
+	for _, loco := range locos {
+		cs.restoreLoco(loco)
+	}
+	if cs.config.Power.EnableOnStart {
+		cs.setPower(true)
+	}
+}
+
+// restoreLoco sets the direction, speed and functions of the loco state on the command station.
+func (cs *CS) restoreLoco(loco *Loco) {
+	name := loco.name()
+	state := loco.State()
+	if _, err := cs.client.SetLocoDir(loco.addr(), state.Dir); err != nil {
+		cs.lg.Printf("resync loco %s: %s", name, err)
+		return
+	}
+	if _, err := cs.client.SetLocoSpeed128(loco.addr(), uint(speed127(state.Speed).speed128())); err != nil {
+		cs.lg.Printf("resync loco %s: %s", name, err)
+		return
+	}
+	loco.iterFcts(func(fctName string, no uint) {
+		if _, err := cs.client.SetLocoFct(loco.addr(), no, state.Fcts[fctName]); err != nil {
+			cs.lg.Printf("resync loco %s function %s: %s", name, fctName, err)
+		}
+	})
+}
+
+// setPower enables or disables the track power (main track DCC output) and publishes the mte event.
+func (cs *CS) setPower(enabled bool) {
+	cs.lg.Printf("command station %s: set track power %t", cs.name(), enabled)
+	enabled, err := cs.client.SetMTE(enabled)
+	if err != nil {
+		cs.lg.Printf("command station %s: set track power: %s", cs.name(), err)
+		return
+	}
+	cs.invalidateCache(CtCS + "/" + cs.name())
+	cs.gw.Publish([]string{"cs", cs.name(), "mte"}, true, enabled)
+	cs.gw.Publish([]string{"cs", cs.name(), "enabled"}, true, enabled)
+	cs.publishProtoMTE(enabled)
+}
+
+// connHandler handles broker connection state changes.
+func (cs *CS) connHandler(connected bool) {
+	if connected {
+		cs.resetWatchdog()
+		return
+	}
+	if cs.config.Power.DisableOnBrokerLoss {
+		cs.setPower(false)
+	}
+	cs.startWatchdog()
+}
+
+// startWatchdog starts the broker connection loss watchdog.
+func (cs *CS) startWatchdog() {
+	if cs.setConfig.WatchdogTimeout == 0 {
+		return
+	}
+	cs.watchdogMu.Lock()
+	defer cs.watchdogMu.Unlock()
+	if cs.watchdog == nil {
+		cs.watchdog = time.AfterFunc(cs.setConfig.WatchdogTimeout, cs.watchdogHandler)
+	}
+}
+
+// resetWatchdog stops the broker connection loss watchdog.
+func (cs *CS) resetWatchdog() {
+	cs.watchdogMu.Lock()
+	defer cs.watchdogMu.Unlock()
+	if cs.watchdog != nil {
+		cs.watchdog.Stop()
+		cs.watchdog = nil
+	}
+}
+
+// watchdogHandler stops all primary locos and disables the track power as configured
+// as no external controller can intervene while the broker connection is lost.
+func (cs *CS) watchdogHandler() {
+	cs.lg.Printf("command station %s: broker connection lost for more than %s - watchdog triggered", cs.name(), cs.setConfig.WatchdogTimeout)
+	locos := maps.Values(cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) }))
+	cs.stopLocos(locos, cs.setConfig.WatchdogStop)
+	if cs.setConfig.WatchdogPowerOff {
+		cs.setPower(false)
+	}
+}
+
+// removeLoco unassigns and unsubscribes a loco (cs.mu needs to be locked).
+func (cs *CS) removeLoco(loco *Loco) {
+	if loco.isPrimary(cs) {
+		loco.unsetPrimary(cs) // ignore error
+		cs.unsubscribeLocoActions(loco)
+		cs.slew.cancel(loco)
+	} else {
+		loco.delSecondary(cs) // ignore error
+		cs.unsubscribeLocoEvents(loco)
+	}
+	delete(cs.locos, loco.name())
+}
+
+// RemoveLoco removes a loco from the command station. RemoveLoco returns false
+// if the loco was not assigned to the command station.
+func (cs *CS) RemoveLoco(loco *Loco) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.locos[loco.name()]; !ok {
+		return false
+	}
+	cs.lg.Printf("unsubscribe loco %s from command station %s", loco.name(), cs.name())
+	cs.removeLoco(loco)
+	return true
+}
+
 // AddLoco adds a loco to the command station.
 func (cs *CS) AddLoco(loco *Loco) (bool, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
 	csName := cs.name()
 	locoName := loco.name()
 
@@ -226,6 +619,7 @@ func (cs *CS) AddLoco(loco *Loco) (bool, error) {
 		cs.locos[locoName] = loco
 		cs.lg.Printf("subscribe loco %s to command station %s as primary", locoName, csName)
 		cs.subscribeLocoActions(loco)
+		cs.gw.Publish([]string{"loco", locoName, "primary"}, true, csName)
 		return true, nil
 	}
 
@@ -246,7 +640,7 @@ func (cs *CS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	b, err := json.MarshalIndent(cs.config, "", indent)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		server.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Write(b)
@@ -262,104 +656,212 @@ func (cs *CS) pushHandler(gw *gateway.Gateway) func(msg client.Msg, err error) {
 		switch msg := msg.(type) {
 
 		case *client.IOIEMsg:
-			// TODO: improve performance in not looping over all the IOs
-			for name, io := range cs.config.IOs {
-				if io.GPIO == msg.GPIO {
-					gw.Publish([]string{"cs", cs.name(), name}, true, msg.State)
-				}
+			for _, name := range cs.ioNames[msg.GPIO] {
+				gw.PublishIO([]string{"cs", cs.name(), name}, true, msg.State)
+			}
+			for _, d := range cs.buttons[msg.GPIO] {
+				d.update(msg.State)
 			}
 		}
 	}
 }
 
-// cmdHandler handles commands.
+// cmdHandler handles commands. With more than one worker the commands are executed by concurrent workers
+// whereas the commands of a device are always executed by the same worker, so that the command order of a
+// device is kept. As the client executes one command station round-trip at a time the workers do not pipeline
+// the commands to the command station - they only isolate the devices from a command blocking its worker
+// (head-of-line isolation). wg is done after all workers are finished.
 func (cs *CS) cmdHandler(wg *sync.WaitGroup, hndCh <-chan *gateway.HndMsg, gw *gateway.Gateway) {
-	wg.Add(1)
 	defer wg.Done()
 
+	if cs.workers == nil {
+		for msg := range hndCh {
+			cs.execCmd(msg, gw)
+		}
+		return
+	}
+
+	for _, ch := range cs.workers {
+		wg.Add(1)
+		go func(ch <-chan *gateway.HndMsg) {
+			defer wg.Done()
+			for msg := range ch {
+				cs.execCmd(msg, gw)
+			}
+		}(ch)
+	}
+	defer func() {
+		for _, ch := range cs.workers {
+			close(ch)
+		}
+	}()
 	for msg := range hndCh {
+		cs.workers[cmdShard(msg.TopicStrs, uint(len(cs.workers)))] <- msg
+	}
+}
 
-		value, err := msg.Fn(msg.Value)
-		if err != nil {
-			gw.PublishErr(msg.TopicStrs, false, err)
-			continue
+// cmdDevice returns the device (e.g. loco/<loco name>) a command is addressed to.
+func cmdDevice(topicStrs []string) string {
+	if len(topicStrs) > 0 && topicStrs[0] == gateway.ClassProto {
+		topicStrs = topicStrs[1:]
+	}
+	if len(topicStrs) > 2 {
+		topicStrs = topicStrs[:2]
+	}
+	return strings.Join(topicStrs, "/")
+}
+
+// cmdShard returns the worker index of a command based on the device the command is addressed to.
+func cmdShard(topicStrs []string, n uint) uint {
+	h := fnv.New32a()
+	h.Write([]byte(cmdDevice(topicStrs)))
+	return uint(h.Sum32()) % n
+}
+
+// execCmd executes a command and publishes the resulting event.
+func (cs *CS) execCmd(msg *gateway.HndMsg, gw *gateway.Gateway) {
+	isGet := isGetCmd(msg.TopicStrs)
+	if cs.cache != nil {
+		if !isGet {
+			cs.cache.invalidate(msg.TopicStrs)
+		} else if value, ok := cs.cache.get(msg.TopicStrs); ok {
+			msg.Wrap(func(payload any) (any, error) { return value, nil })(msg.Value)
+			gw.Publish(msg.TopicStrs[:len(msg.TopicStrs)-1], true, value)
+			return
 		}
+	}
 
-		// send event
-		gw.Publish(msg.TopicStrs[:len(msg.TopicStrs)-1], true, value)
+	start := time.Now()
+	value, err := msg.Fn(msg.Value)
+	cs.stats.record(cmdName(msg.TopicStrs), time.Since(start))
+	if err != nil {
+		gw.PublishErr(msg.TopicStrs, false, err)
+		return
+	}
+	if cs.cache != nil && isGet {
+		cs.cache.put(msg.TopicStrs, value)
 	}
+
+	// send event
+	gw.Publish(msg.TopicStrs[:len(msg.TopicStrs)-1], true, value)
 }
 
 func (cs *CS) subscribe() {
 	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "temp", "get"}, cs.getTemp(cs.client))
 	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "mte", "get"}, cs.getMTE(cs.client))
 	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "mte", "set"}, cs.setMTE(cs.client))
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "enabled", "get"}, cs.getEnabled(cs.client))
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "enabled", "set"}, cs.setEnabled(cs.client))
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "enabled", "toggle"}, cs.toggleEnabled(cs.client))
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.config.Name, "pom", "write"}, cs.writeCVOnMain())
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"slowstop"}, cs.slowStop())
+	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", "stop"}, cs.emergencyStop())
+	cs.subscribeIOs()
+	cs.subscribeTurnouts()
+	cs.subscribePatterns()
+	cs.subscribeSignals()
+	cs.subscribeProto()
+	cs.gw.SubscribeConn(cs, cs.connHandler)
 }
 
 func (cs *CS) unsubscribe() {
 	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "tmp", "get"})
 	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "mte", "get"})
 	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "mte", "set"})
+	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "enabled", "get"})
+	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "enabled", "set"})
+	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "enabled", "toggle"})
+	cs.gw.Unsubscribe(cs, []string{"cs", cs.config.Name, "pom", "write"})
+	cs.gw.Unsubscribe(cs, []string{"slowstop"})
+	cs.gw.Unsubscribe(cs, []string{"loco", "stop"})
+	cs.unsubscribeIOs()
+	cs.unsubscribeTurnouts()
+	cs.unsubscribePatterns()
+	cs.unsubscribeSignals()
+	cs.unsubscribeProto()
+	cs.gw.UnsubscribeConn(cs)
 }
 
-// subscribeLocoActions subscribes to loco actions for a loco controlled by this command station.
-func (cs *CS) subscribeLocoActions(loco *Loco) {
-	name := loco.name()
-	addr := loco.addr()
+// locoActions returns the loco action handlers for a loco controlled by this command station
+// (key: property/command topic levels).
+func (cs *CS) locoActions(loco *Loco) map[string]gateway.HndFn {
+	m := map[string]gateway.HndFn{
+		"dir/get":          cs.getLocoDir(cs.client, loco),
+		"dir/set":          cs.setLocoDir(cs.client, loco, true),
+		"dir/toggle":       cs.toggleLocoDir(cs.client, loco),
+		"speed/get":        cs.getLocoSpeed(cs.client, loco),
+		"speed/set":        cs.setLocoSpeed(cs.client, loco, true),
+		"speed/stop":       cs.stopLoco(cs.client, loco),
+		"speed/brake":      cs.brakeLoco(loco),
+		"speed/add":        cs.addLocoSpeed(cs.client, loco),
+		"speed/throttle":   cs.setLocoThrottle(cs.client, loco),
+		"velocity/get":     cs.getLocoVelocity(cs.client, loco),
+		"velocity/set":     cs.setLocoVelocity(cs.client, loco),
+		"drive/get":        cs.getLocoDrive(loco),
+		"drive/set":        cs.setLocoDrive(cs.client, loco),
+		"maintenance/done": cs.setLocoServiced(loco),
+		"shunt/get":        cs.getLocoShunt(loco),
+		"shunt/set":        cs.setLocoShunt(loco),
+		"shunt/toggle":     cs.toggleLocoShunt(loco),
+		"pair/get":         cs.getLocoPair(loco),
+		"pair/set":         cs.setLocoPair(loco),
+	}
+	loco.iterFcts(func(fctName string, fctNo uint) {
+		m[fctName+"/get"] = cs.getLocoFct(cs.client, loco, fctNo)
+		m[fctName+"/set"] = cs.setLocoFct(cs.client, loco, fctNo, true)
+		m[fctName+"/toggle"] = cs.toggleLocoFct(cs.client, loco, fctNo)
+	})
+	for i, fg := range fctGroups {
+		m[fctGroupName(i)+"/get"] = cs.getLocoFctGroup(cs.client, loco, fg)
+		m[fctGroupName(i)+"/set"] = cs.setLocoFctGroup(cs.client, loco, fg, true)
+	}
+	return m
+}
 
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "dir", "get"}, cs.getLocoDir(cs.client, addr))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "dir", "set"}, cs.setLocoDir(cs.client, addr, true))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "dir", "toggle"}, cs.toggleLocoDir(cs.client, addr))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "speed", "get"}, cs.getLocoSpeed(cs.client, addr))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "speed", "set"}, cs.setLocoSpeed(cs.client, addr, true))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "speed", "stop"}, cs.stopLoco(cs.client, addr))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "speed", "add"}, cs.addLocoSpeed(cs.client, addr))
+// locoEvents returns the loco event handlers for a loco not controlled by this command station
+// (key: property topic level).
+func (cs *CS) locoEvents(loco *Loco) map[string]gateway.HndFn {
+	m := map[string]gateway.HndFn{
+		"dir":   cs.setLocoDir(cs.client, loco, false),
+		"speed": cs.setLocoSpeed(cs.client, loco, false),
+	}
 	loco.iterFcts(func(fctName string, fctNo uint) {
-		cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, fctName, "get"}, cs.getLocoFct(cs.client, addr, fctNo))
-		cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, fctName, "set"}, cs.setLocoFct(cs.client, addr, fctNo, true))
-		cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, fctName, "toggle"}, cs.toggleLocoFct(cs.client, addr, fctNo))
+		m[fctName] = cs.setLocoFct(cs.client, loco, fctNo, false)
 	})
+	for i, fg := range fctGroups {
+		m[fctGroupName(i)] = cs.setLocoFctGroup(cs.client, loco, fg, false)
+	}
+	return m
+}
+
+// router returns a routing function dispatching the topic levels below loco/<loco name> to the handlers.
+func router(m map[string]gateway.HndFn) gateway.RouteFn {
+	return func(subTopic string) (gateway.HndFn, bool) {
+		fn, ok := m[subTopic]
+		return fn, ok
+	}
+}
+
+// subscribeLocoActions subscribes to loco actions for a loco controlled by this command station.
+func (cs *CS) subscribeLocoActions(loco *Loco) {
+	cs.gw.SubscribeRouter(cs.hndCh, cs, []string{"loco", loco.name()}, router(cs.locoActions(loco)))
+	cs.gw.SubscribeRouter(cs.hndCh, cs, []string{gateway.ClassProto, "loco", loco.name()}, router(cs.protoLocoActions(loco)))
 }
 
 // subscribeLocoEvents subscribes to loco events for a loco not controlled by this command station.
 func (cs *CS) subscribeLocoEvents(loco *Loco) {
-	name := loco.name()
-	addr := loco.addr()
-
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "dir"}, cs.setLocoDir(cs.client, addr, false))
-	cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, "speed"}, cs.setLocoSpeed(cs.client, addr, false))
-	loco.iterFcts(func(fctName string, fctNo uint) {
-		cs.gw.Subscribe(cs.hndCh, cs, []string{"loco", name, fctName}, cs.setLocoFct(cs.client, addr, fctNo, false))
-	})
+	cs.gw.SubscribeRouter(cs.hndCh, cs, []string{"loco", loco.name()}, router(cs.locoEvents(loco)))
 }
 
 // unsubscribeLocoActions unsubscribes from loco actions.
 func (cs *CS) unsubscribeLocoActions(loco *Loco) {
-	name := loco.name()
-
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "dir", "get"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "dir", "set"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "dir", "toggle"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "speed", "get"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "speed", "set"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "speed", "stop"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "speed", "add"})
-	loco.iterFcts(func(fctName string, fctNo uint) {
-		cs.gw.Unsubscribe(cs, []string{"loco", name, fctName, "get"})
-		cs.gw.Unsubscribe(cs, []string{"loco", name, fctName, "set"})
-		cs.gw.Unsubscribe(cs, []string{"loco", name, fctName, "toggle"})
-	})
+	cs.gw.UnsubscribeRouter(cs, []string{"loco", loco.name()})
+	cs.gw.UnsubscribeRouter(cs, []string{gateway.ClassProto, "loco", loco.name()})
 }
 
 // unsubscribeLocoEvents unsubscribes from loco events.
 func (cs *CS) unsubscribeLocoEvents(loco *Loco) {
-	name := loco.name()
-
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "dir"})
-	cs.gw.Unsubscribe(cs, []string{"loco", name, "speed"})
-	loco.iterFcts(func(fctName string, fctNo uint) {
-		cs.gw.Unsubscribe(cs, []string{"loco", name, fctName})
-	})
+	cs.gw.UnsubscribeRouter(cs, []string{"loco", loco.name()})
 }
 
 func (cs *CS) getTemp(client *client.Client) gateway.HndFn {
@@ -370,46 +872,137 @@ func (cs *CS) getTemp(client *client.Client) gateway.HndFn {
 
 func (cs *CS) getMTE(client *client.Client) gateway.HndFn {
 	return func(payload any) (any, error) {
-		return client.MTE()
+		enabled, err := client.MTE()
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"cs", cs.name(), "enabled"}, true, enabled)
+		cs.publishProtoMTE(enabled)
+		return enabled, nil
 	}
 }
 
 func (cs *CS) setMTE(client *client.Client) gateway.HndFn {
+	return gateway.Typed(func(enabled bool) (any, error) {
+		enabled, err := client.SetMTE(enabled)
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"cs", cs.name(), "enabled"}, true, enabled)
+		cs.publishProtoMTE(enabled)
+		return enabled, nil
+	})
+}
+
+// getEnabled returns the track power state. The enabled topics switch the track power
+// (main track DCC output) like the mte topics and keep the mte event in sync.
+func (cs *CS) getEnabled(client *client.Client) gateway.HndFn {
 	return func(payload any) (any, error) {
-		enabled, ok := payload.(bool)
-		if !ok {
-			return nil, fmt.Errorf("setMTE: invalid enabled type %[1]T value %[1]v", payload)
+		enabled, err := client.MTE()
+		if err != nil {
+			return nil, err
 		}
-		return client.SetMTE(enabled)
+		cs.gw.Publish([]string{"cs", cs.name(), "mte"}, true, enabled)
+		cs.publishProtoMTE(enabled)
+		return enabled, nil
 	}
 }
 
-func (cs *CS) getLocoDir(client *client.Client, addr uint) gateway.HndFn {
+func (cs *CS) setEnabled(client *client.Client) gateway.HndFn {
+	return gateway.Typed(func(enabled bool) (any, error) {
+		return cs.switchEnabled(client, enabled)
+	})
+}
+
+func (cs *CS) toggleEnabled(client *client.Client) gateway.HndFn {
 	return func(payload any) (any, error) {
-		return client.LocoDir(addr)
+		enabled, err := client.MTE()
+		if err != nil {
+			return nil, err
+		}
+		return cs.switchEnabled(client, !enabled)
+	}
+}
+
+func (cs *CS) switchEnabled(client *client.Client, enabled bool) (any, error) {
+	enabled, err := client.SetMTE(enabled)
+	if err != nil {
+		return nil, err
 	}
+	cs.gw.Publish([]string{"cs", cs.name(), "mte"}, true, enabled)
+	cs.publishProtoMTE(enabled)
+	return enabled, nil
 }
 
-func (cs *CS) setLocoDir(client *client.Client, addr uint, publish bool) gateway.HndFn {
+// maxSlowStop is the maximum duration of a slow stop.
+const maxSlowStop = time.Minute
+
+// slowStop ramps all running primary locos to zero. The payload is whether the ramp duration
+// in seconds or any other value for the default stop ramp duration.
+func (cs *CS) slowStop() gateway.HndFn {
+	return gateway.Typed(func(payload any) (any, error) {
+		d := cs.setConfig.StopRamp
+		if f64, ok := payload.(float64); ok {
+			d = time.Duration(f64 * float64(time.Second))
+			if d < 0 || d > maxSlowStop {
+				return nil, fmt.Errorf("slowStop: invalid duration %s (range 0..%s)", d, maxSlowStop)
+			}
+		}
+		locos := maps.Values(cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) && loco.State().Speed != 0 }))
+		cs.lg.Printf("command station %s: slow stop %d locos within %s", cs.name(), len(locos), d)
+		cs.startRamp(locos, d)
+		return nil, nil // no event
+	})
+}
+
+// emergencyStop sends an emergency stop to all primary locos of the command station.
+func (cs *CS) emergencyStop() gateway.HndFn {
 	return func(payload any) (any, error) {
-		dir, ok := payload.(bool)
-		if !ok {
-			return nil, fmt.Errorf("setLocoDir: invalid dir type %T", payload)
+		locos := maps.Values(cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) }))
+		for _, loco := range locos {
+			cs.slew.cancel(loco)
 		}
-		dir, err := client.SetLocoDir(addr, dir)
-		if !publish {
+		cs.stopLocos(locos, StopEmergency)
+		return nil, nil // no event
+	}
+}
+
+func (cs *CS) getLocoDir(client *client.Client, loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		dir, err := client.LocoDir(loco.addr())
+		if err != nil {
 			return nil, err
 		}
-		return dir, err
+		cs.updateLoco(loco, func(state *LocoState) { state.Dir = dir })
+		return dir, nil
 	}
 }
 
-func (cs *CS) toggleLocoDir(client *client.Client, addr uint) gateway.HndFn {
+func (cs *CS) setLocoDir(client *client.Client, loco *Loco, publish bool) gateway.HndFn {
+	return gateway.Typed(func(dir bool) (any, error) {
+		dir, err := client.SetLocoDir(loco.addr(), dir)
+		if !publish || err != nil {
+			return nil, err
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.Dir = dir })
+		return dir, nil
+	})
+}
+
+func (cs *CS) toggleLocoDir(client *client.Client, loco *Loco) gateway.HndFn {
 	return func(payload any) (any, error) {
-		return client.ToggleLocoDir(addr)
+		dir, err := client.ToggleLocoDir(loco.addr())
+		if err != nil {
+			return nil, err
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.Dir = dir })
+		return dir, nil
 	}
 }
 
+// maxSpeed is the maximum speed value (126 speed steps).
+const maxSpeed = 126
+
 type speed127 uint
 type speed128 uint
 
@@ -428,8 +1021,8 @@ func (s speed127) add(delta int) speed127 {
 	switch {
 	case speed < 0:
 		return 0
-	case speed > 126:
-		return 126
+	case speed > maxSpeed:
+		return maxSpeed
 	default:
 		return speed127(speed)
 	}
@@ -446,83 +1039,456 @@ func (s speed128) add(delta int) speed128 {
 	return s.speed127().add(delta).speed128()
 }
 
-func (cs *CS) getLocoSpeed(client *client.Client, addr uint) gateway.HndFn {
+// rampInterval is the interval between two speed steps of a ramp.
+const rampInterval = 100 * time.Millisecond
+
+// setSpeed sets the loco speed and updates the loco state.
+func (cs *CS) setSpeed(loco *Loco, speed speed127) (speed127, error) {
+	result, err := cs.client.SetLocoSpeed128(loco.addr(), uint(speed.speed128()))
+	if err != nil {
+		return 0, err
+	}
+	return cs.updateLocoSpeed(loco, speed128(result).speed127()), nil
+}
+
+// publishLocoSpeed sets the loco speed, updates the loco state and publishes the speed event.
+func (cs *CS) publishLocoSpeed(loco *Loco, speed speed128) error {
+	result, err := cs.client.SetLocoSpeed128(loco.addr(), uint(speed))
+	if err != nil {
+		return err
+	}
+	cs.gw.Publish([]string{"loco", loco.name(), "speed"}, true, loco.config.speedStep(cs.updateLocoSpeed(loco, speed128(result).speed127())))
+	return nil
+}
+
+// startRamp ramps the locos to zero within duration d in the background, so that the command
+// station keeps executing commands (e.g. an emergency stop) during the ramp. A running ramp is cancelled.
+func (cs *CS) startRamp(locos []*Loco, d time.Duration) {
+	cs.rampMu.Lock()
+	defer cs.rampMu.Unlock()
+	cs.stopRamp()
+	stop := make(chan struct{})
+	cs.rampStop = stop
+	cs.rampWg.Add(1)
+	go func() {
+		defer cs.rampWg.Done()
+		cs.rampLocos(stop, locos, d)
+	}()
+}
+
+// cancelRamp cancels a running background ramp and waits until it is finished.
+func (cs *CS) cancelRamp() {
+	cs.rampMu.Lock()
+	defer cs.rampMu.Unlock()
+	cs.stopRamp()
+}
+
+// stopRamp cancels a running background ramp and waits until it is finished (cs.rampMu needs to be locked).
+func (cs *CS) stopRamp() {
+	if cs.rampStop != nil {
+		close(cs.rampStop)
+		cs.rampStop = nil
+	}
+	cs.rampWg.Wait()
+}
+
+// rampSpeed returns the speed of ramp step step (range 0..steps) decreasing start linearly to zero.
+func rampSpeed(start speed127, step, steps int) speed127 {
+	return speed127(int(start) * (steps - step) / steps)
+}
+
+// rampLocos decreases the speed of the locos linearly to zero within duration d. The ramp is cancelled
+// by closing stop (nil: not cancelable). A loco is left out of the ramp as soon as its speed is changed
+// by another command.
+func (cs *CS) rampLocos(stop <-chan struct{}, locos []*Loco, d time.Duration) {
+	starts := make([]speed127, len(locos))
+	speeds := make([]speed127, len(locos)) // last speed set by the ramp
+	for i, loco := range locos {
+		cs.slew.cancel(loco)
+		starts[i] = speed127(loco.State().Speed)
+		speeds[i] = starts[i]
+	}
+	// changed returns true if the loco speed was changed by another command during the ramp.
+	changed := func(i int) bool { return speed127(locos[i].State().Speed) != speeds[i] }
+
+	steps := int(d / rampInterval)
+	for step := 1; step < steps; step++ {
+		for i, loco := range locos {
+			if starts[i] == 0 || changed(i) {
+				continue
+			}
+			speeds[i] = rampSpeed(starts[i], step, steps)
+			if err := cs.publishLocoSpeed(loco, speeds[i].speed128()); err != nil {
+				cs.lg.Printf("ramp loco %s: %s", loco.name(), err)
+			}
+		}
+		select {
+		case <-stop:
+			cs.lg.Printf("command station %s: ramp cancelled", cs.name())
+			return
+		case <-time.After(rampInterval):
+		}
+	}
+	for i, loco := range locos {
+		if changed(i) {
+			continue
+		}
+		if err := cs.publishLocoSpeed(loco, 0); err != nil {
+			cs.lg.Printf("ramp loco %s: %s", loco.name(), err)
+		}
+	}
+}
+
+// stopLocos stops the locos according to the stop mode.
+func (cs *CS) stopLocos(locos []*Loco, mode string) {
+	cs.cancelRamp() // a stop overrides a running slow stop
+	switch mode {
+	case StopEmergency:
+		cs.lg.Printf("command station %s: emergency stop %d locos", cs.name(), len(locos))
+		for _, loco := range locos {
+			if err := cs.publishLocoSpeed(loco, 1); err != nil {
+				cs.lg.Printf("stop loco %s: %s", loco.name(), err)
+			}
+		}
+	case StopRamp:
+		cs.lg.Printf("command station %s: ramp stop %d locos within %s", cs.name(), len(locos), cs.setConfig.StopRamp)
+		cs.rampLocos(nil, locos, cs.setConfig.StopRamp)
+	}
+}
+
+func (cs *CS) getLocoSpeed(client *client.Client, loco *Loco) gateway.HndFn {
 	return func(payload any) (any, error) {
-		speed, err := client.LocoSpeed128(addr)
+		speed, err := client.LocoSpeed128(loco.addr())
 		if err != nil {
 			return nil, err
 		}
-		return speed128(speed).speed127(), nil
+		return loco.config.speedStep(cs.updateLocoSpeed(loco, speed128(speed).speed127())), nil
 	}
 }
 
-func (cs *CS) setLocoSpeed(client *client.Client, addr uint, publish bool) gateway.HndFn {
-	return func(payload any) (any, error) {
-		f64, ok := payload.(float64)
-		if !ok {
-			return nil, fmt.Errorf("setLocoSpeed: invalid speed type %T", payload)
+func (cs *CS) setLocoSpeed(client *client.Client, loco *Loco, publish bool) gateway.HndFn {
+	return gateway.Typed(func(f64 float64) (any, error) {
+		if publish {
+			return loco.speedEvent(cs.slew.set(loco, loco.shuntSpeed(loco.config.stepSpeed(f64))))
 		}
-		speed, err := client.SetLocoSpeed128(addr, uint(speed127(f64).speed128()))
+		_, err := client.SetLocoSpeed128(loco.addr(), uint(loco.config.stepSpeed(f64).speed128()))
+		return nil, err
+	})
+}
+
+func (cs *CS) stopLoco(client *client.Client, loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		cs.slew.cancel(loco)
+		speed, err := client.SetLocoSpeed128(loco.addr(), 1) // emergency stop
 		if err != nil {
 			return nil, err
 		}
-		if !publish {
+		return loco.config.speedStep(cs.updateLocoSpeed(loco, speed128(speed).speed127())), nil // speed should be 0
+	}
+}
+
+// brakeLoco decelerates the loco to zero using the braking rate of the loco (payload: optional
+// braking rate in speed steps per second overwriting the configured braking rate).
+func (cs *CS) brakeLoco(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		rate := loco.config.brakeRate()
+		if f64, err := gateway.Decode[float64](payload); err == nil {
+			if f64 < 1 || f64 > maxBrakeRate {
+				return nil, fmt.Errorf("brakeLoco: invalid rate %v (range 1..%d)", f64, maxBrakeRate)
+			}
+			rate = uint(f64)
+		}
+		return loco.speedEvent(cs.slew.brake(loco, rate))
+	}
+}
+
+func (cs *CS) addLocoSpeed(client *client.Client, loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(delta float64) (any, error) {
+		speed, err := client.LocoSpeed128(loco.addr())
+		if err != nil {
 			return nil, err
 		}
-		return speed128(speed).speed127(), err
+		return loco.speedEvent(cs.slew.set(loco, loco.shuntLimit(loco.config.addSteps(speed128(speed).speed127(), int(delta)))))
+	})
+}
+
+func (cs *CS) setLocoThrottle(client *client.Client, loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(throttle float64) (any, error) {
+		if throttle < 0 || throttle > 1 {
+			return nil, fmt.Errorf("setLocoThrottle: invalid throttle %f (range 0.0..1.0)", throttle)
+		}
+		return loco.speedEvent(cs.slew.set(loco, loco.shuntSpeed(loco.config.throttleSpeed(throttle))))
+	})
+}
+
+// velocity returns the signed speed step (negative speed: backward direction).
+func velocity(dir bool, speed uint) int {
+	if dir {
+		return int(speed)
 	}
+	return -int(speed)
 }
 
-func (cs *CS) stopLoco(client *client.Client, addr uint) gateway.HndFn {
+func (cs *CS) getLocoVelocity(client *client.Client, loco *Loco) gateway.HndFn {
 	return func(payload any) (any, error) {
-		speed, err := client.SetLocoSpeed128(addr, 1) // emergency stop
+		dir, err := client.LocoDir(loco.addr())
+		if err != nil {
+			return nil, err
+		}
+		speed, err := client.LocoSpeed128(loco.addr())
 		if err != nil {
 			return nil, err
 		}
-		return speed128(speed).speed127(), nil // speed should be 0
+		cs.updateLoco(loco, func(state *LocoState) {
+			state.Dir = dir
+			state.Speed = uint(speed128(speed).speed127())
+		})
+		return velocity(dir, loco.config.speedStep(speed128(speed).speed127())), nil
 	}
 }
 
-func (cs *CS) addLocoSpeed(client *client.Client, addr uint) gateway.HndFn {
-	return func(payload any) (any, error) {
-		f64, ok := payload.(float64)
-		if !ok {
-			return nil, fmt.Errorf("addLocoSpeed: invalid delta type %T", payload)
+func (cs *CS) setLocoVelocity(client *client.Client, loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(f64 float64) (any, error) {
+		if max := float64(loco.config.maxStep()); f64 < -max || f64 > max {
+			return nil, fmt.Errorf("setLocoVelocity: invalid velocity %v (range %v..%v)", f64, -max, max)
 		}
-		speed, err := client.LocoSpeed128(addr)
+
+		addr := loco.addr()
+		name := loco.name()
+
+		dir, err := client.LocoDir(addr)
 		if err != nil {
 			return nil, err
 		}
-		speed, err = client.SetLocoSpeed128(addr, uint(speed128(speed).add(int(f64))))
+		if f64 != 0 && dir != (f64 > 0) { // keep direction in case of zero velocity
+			if dir, err = client.SetLocoDir(addr, f64 > 0); err != nil {
+				return nil, err
+			}
+			cs.gw.Publish([]string{"loco", name, "dir"}, true, dir)
+		}
+
+		cs.updateLoco(loco, func(state *LocoState) { state.Dir = dir })
+
+		speed, err := cs.slew.set(loco, loco.shuntSpeed(loco.config.stepSpeed(math.Abs(f64))))
 		if err != nil {
 			return nil, err
 		}
-		return speed128(speed).speed127(), nil
+		step := loco.config.speedStep(speed)
+		cs.gw.Publish([]string{"loco", name, "speed"}, true, step)
+		return velocity(dir, step), nil
+	})
+}
+
+func (cs *CS) getLocoFct(client *client.Client, loco *Loco, no uint) gateway.HndFn {
+	return func(payload any) (any, error) {
+		fct, err := client.LocoFct(loco.addr(), no)
+		if err != nil {
+			return nil, err
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.setFct(loco.config, no, fct) })
+		return fct, nil
 	}
 }
 
-func (cs *CS) getLocoFct(client *client.Client, addr, no uint) gateway.HndFn {
+func (cs *CS) setLocoFct(client *client.Client, loco *Loco, no uint, publish bool) gateway.HndFn {
+	return gateway.Typed(func(fct bool) (any, error) {
+		if fct {
+			if err := cs.switchOffExclFcts(loco, no); err != nil {
+				return nil, err
+			}
+		}
+		fct, err := client.SetLocoFct(loco.addr(), no, fct)
+		if !publish || err != nil {
+			return nil, err
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.setFct(loco.config, no, fct) })
+		return fct, nil
+	})
+}
+
+func (cs *CS) toggleLocoFct(client *client.Client, loco *Loco, no uint) gateway.HndFn {
 	return func(payload any) (any, error) {
-		return client.LocoFct(addr, no)
+		if !loco.fct(no) { // switched on by toggle
+			if err := cs.switchOffExclFcts(loco, no); err != nil {
+				return nil, err
+			}
+		}
+		fct, err := client.ToggleLocoFct(loco.addr(), no)
+		if err != nil {
+			return nil, err
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.setFct(loco.config, no, fct) })
+		return fct, nil
 	}
 }
 
-func (cs *CS) setLocoFct(client *client.Client, addr, no uint, publish bool) gateway.HndFn {
+// fctGroup represents a DCC function group (range of function numbers).
+type fctGroup struct {
+	first, last uint
+}
+
+// fctGroups defines the standard DCC function groups F0-F4, F5-F8, F9-F12, F13-F20 and F21-F28.
+var fctGroups = []fctGroup{{0, 4}, {5, 8}, {9, 12}, {13, 20}, {21, 28}}
+
+func fctGroupName(i int) string { return fmt.Sprintf("fg%d", i) }
+func (fg fctGroup) mask() uint  { return 1<<(fg.last-fg.first+1) - 1 }
+
+func (cs *CS) getLocoFctGroup(client *client.Client, loco *Loco, fg fctGroup) gateway.HndFn {
 	return func(payload any) (any, error) {
-		fct, ok := payload.(bool)
-		if !ok {
-			return nil, fmt.Errorf("setLocoFct: invalid fct type %T", payload)
+		var mask uint
+		for no := fg.first; no <= fg.last; no++ {
+			fct, err := client.LocoFct(loco.addr(), no)
+			if err != nil {
+				return nil, err
+			}
+			if fct {
+				mask |= 1 << (no - fg.first)
+			}
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.setFctGroup(loco.config, fg, mask) })
+		return mask, nil
+	}
+}
+
+func (cs *CS) setLocoFctGroup(client *client.Client, loco *Loco, fg fctGroup, publish bool) gateway.HndFn {
+	return gateway.Typed(func(f64 float64) (any, error) {
+		mask := uint(f64)
+		if f64 < 0 || float64(mask) != f64 || mask > fg.mask() {
+			return nil, fmt.Errorf("setLocoFctGroup: invalid mask %v (range 0..%d)", f64, fg.mask())
+		}
+		var result uint
+		for no := fg.first; no <= fg.last; no++ {
+			bit := uint(1) << (no - fg.first)
+			fct, err := client.SetLocoFct(loco.addr(), no, mask&bit != 0)
+			if err != nil {
+				return nil, err
+			}
+			if fct {
+				result |= bit
+			}
 		}
-		fct, err := client.SetLocoFct(addr, no, fct)
 		if !publish {
+			return nil, nil
+		}
+		cs.updateLoco(loco, func(state *LocoState) { state.setFctGroup(loco.config, fg, result) })
+		return result, nil
+	})
+}
+
+func (cs *CS) getLocoDrive(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return loco.driveState(), nil
+	}
+}
+
+// locoDrive represents a (partial) drive state - only the provided (non nil) fields are set.
+type locoDrive struct {
+	Dir   *bool           `json:"dir"`
+	Speed *float64        `json:"speed"`
+	Fcts  map[string]bool `json:"fcts"`
+}
+
+// setLocoDrive sets the drive state of a loco. The payload is a (partial) drive state object
+// where only the provided fields are set.
+func (cs *CS) setLocoDrive(client *client.Client, loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(drive locoDrive) (any, error) {
+		state, err := cs.drive(client, loco, &drive)
+		if err != nil {
+			return nil, err
+		}
+		cs.publishProtoDrive(loco, state)
+		return state, nil
+	})
+}
+
+// drive sets the provided fields of the drive state, publishes the corresponding direction,
+// speed and function events and returns the resulting drive state.
+func (cs *CS) drive(client *client.Client, loco *Loco, drive *locoDrive) (*LocoState, error) {
+	addr := loco.addr()
+	name := loco.name()
+
+	if drive.Dir != nil {
+		dir, err := client.SetLocoDir(addr, *drive.Dir)
+		if err != nil {
+			return nil, err
+		}
+		var prevDir bool
+		loco.update(func(state *LocoState) {
+			prevDir = state.Dir
+			state.Dir = dir
+		})
+		cs.gw.Publish([]string{"loco", name, "dir"}, true, dir)
+		if dir != prevDir {
+			cs.switchDirLights(loco, dir)
+		}
+	}
+
+	if drive.Speed != nil {
+		if max := float64(loco.config.maxStep()); *drive.Speed < 0 || *drive.Speed > max {
+			return nil, fmt.Errorf("setLocoDrive: invalid speed %v (range 0..%v)", *drive.Speed, max)
+		}
+		speed, err := cs.slew.set(loco, loco.shuntSpeed(loco.config.stepSpeed(*drive.Speed)))
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"loco", name, "speed"}, true, loco.config.speedStep(speed))
+	}
+
+	for fctName, fct := range drive.Fcts {
+		fctConfig, ok := loco.config.Fcts[fctName]
+		if !ok {
+			return nil, fmt.Errorf("setLocoDrive: invalid function %s", fctName)
+		}
+		if fct {
+			if err := cs.switchOffExclFcts(loco, fctConfig.No); err != nil {
+				return nil, err
+			}
+		}
+		fct, err := client.SetLocoFct(addr, fctConfig.No, fct)
+		if err != nil {
 			return nil, err
 		}
-		return fct, err
+		loco.update(func(state *LocoState) { state.setFct(loco.config, fctConfig.No, fct) })
+		cs.gw.Publish([]string{"loco", name, fctName}, true, fct)
 	}
+
+	return loco.driveState(), nil
 }
 
-func (cs *CS) toggleLocoFct(client *client.Client, addr, no uint) gateway.HndFn {
-	return func(payload any) (any, error) {
-		return client.ToggleLocoFct(addr, no)
+// updateLoco updates the loco state and publishes the drive state in case the state did change.
+func (cs *CS) updateLoco(loco *Loco, fn func(state *LocoState)) {
+	var prevSpeed uint
+	var prevDir bool
+	state, changed := loco.update(func(state *LocoState) {
+		prevSpeed = state.Speed
+		prevDir = state.Dir
+		fn(state)
+	})
+	if !changed {
+		return
+	}
+	cs.invalidateCache(CtLoco + "/" + loco.name())
+	driveState := loco.config.driveState(state)
+	cs.gw.Publish([]string{"loco", loco.name(), "drive"}, true, driveState)
+	cs.publishProtoDrive(loco, driveState)
+	if state.Speed != prevSpeed {
+		cs.speedHooks.each(func(fn func(loco *Loco, speed uint)) { fn(loco, state.Speed) })
+	}
+	if state.Dir != prevDir {
+		cs.switchDirLights(loco, state.Dir)
 	}
 }
+
+// invalidateCache removes the cached get command results of a device (e.g. loco/<loco name>) after
+// a state change not caused by a command addressed to the device.
+func (cs *CS) invalidateCache(device string) {
+	if cs.cache != nil {
+		cs.cache.invalidateDevice(device)
+	}
+}
+
+// updateLocoSpeed updates the loco speed state and returns the speed.
+func (cs *CS) updateLocoSpeed(loco *Loco, speed speed127) speed127 {
+	cs.updateLoco(loco, func(state *LocoState) { state.Speed = uint(speed) })
+	return speed
+}
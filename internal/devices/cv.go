@@ -0,0 +1,49 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// CV limits.
+const (
+	maxCV       = 1024
+	maxLocoAddr = 10239 // maximum DCC long address
+)
+
+// CVCmd represents the payload of a CV programming on main command.
+type CVCmd struct {
+	// decoder address (programming on main)
+	Addr uint `json:"addr"`
+	// CV number (1..1024)
+	CV uint `json:"cv"`
+	// CV value
+	Value byte `json:"value"`
+}
+
+func (c *CVCmd) validate() error {
+	if c.Addr == 0 || c.Addr > maxLocoAddr {
+		return fmt.Errorf("invalid decoder address %d (range 1..%d)", c.Addr, maxLocoAddr)
+	}
+	if c.CV == 0 || c.CV > maxCV {
+		return fmt.Errorf("invalid cv %d (range 1..%d)", c.CV, maxCV)
+	}
+	return nil
+}
+
+// writeCVOnMain writes a CV byte of a decoder on the main track (programming on main). As the command
+// station client does neither provide service mode (programming track) nor CV read commands, CVs cannot be read.
+func (cs *CS) writeCVOnMain() gateway.HndFn {
+	return gateway.Typed(func(cmd CVCmd) (any, error) {
+		if err := cmd.validate(); err != nil {
+			return nil, err
+		}
+		value, err := cs.client.SetLocoCVByte(cmd.Addr, cmd.CV-1, cmd.Value) // CV index is CV number - 1
+		if err != nil {
+			return nil, err
+		}
+		cs.lg.Printf("command station %s: write cv %d of decoder %d on main: %d", cs.name(), cmd.CV, cmd.Addr, value)
+		return &CVCmd{Addr: cmd.Addr, CV: cmd.CV, Value: value}, nil
+	})
+}
@@ -4,13 +4,24 @@ package devices
 import (
 	"net/http"
 	"strings"
+
+	"github.com/pico-cs/mqtt-gateway/internal/server"
 )
 
 // ident for json marshalling.
 var indent = strings.Repeat(" ", 4)
 
+// splitPath splits an url path into its non empty segments.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
 // HTTPHandler is a anlder function providing the main html index for the devices.
 func HTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" { // registered at / matching all paths without registered handler
+		server.NotFound(w, r)
+		return
+	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Write([]byte(idxHTML))
+	executeTemplate(w, idxTpl, tplData{BasePath: server.BasePath(r)})
 }
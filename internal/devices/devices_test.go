@@ -0,0 +1,81 @@
+package devices
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestButtonDetector(t *testing.T) {
+	const (
+		longPress   = 100 // ms
+		doubleClick = 50  // ms
+		settle      = 200 * time.Millisecond
+	)
+
+	// A buttonStep sets the input state and waits for d.
+	type buttonStep struct {
+		state bool
+		d     time.Duration
+	}
+
+	tests := []struct {
+		name      string
+		activeLow bool
+		steps     []buttonStep
+		events    []string
+	}{
+		{"short press", false, []buttonStep{{true, 10 * time.Millisecond}, {false, 0}}, []string{ButtonPressed}},
+		{"short press active low", true, []buttonStep{{true, 0}, {false, 10 * time.Millisecond}, {true, 0}}, []string{ButtonPressed}},
+		{"long press", false, []buttonStep{{true, 150 * time.Millisecond}, {false, 0}}, []string{ButtonLong}},
+		{"double click", false, []buttonStep{{true, 10 * time.Millisecond}, {false, 10 * time.Millisecond}, {true, 10 * time.Millisecond}, {false, 0}}, []string{ButtonDouble}},
+		{"short press followed by long press", false, []buttonStep{{true, 10 * time.Millisecond}, {false, 10 * time.Millisecond}, {true, 150 * time.Millisecond}, {false, 0}}, []string{ButtonPressed, ButtonLong}},
+		{"two short presses", false, []buttonStep{{true, 10 * time.Millisecond}, {false, 100 * time.Millisecond}, {true, 10 * time.Millisecond}, {false, 0}}, []string{ButtonPressed, ButtonPressed}},
+		{"unchanged state", false, []buttonStep{{false, 0}, {false, 0}}, nil},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			var events []string
+			config := &CSButtonConfig{ActiveLow: test.activeLow, LongPress: longPress, DoubleClick: doubleClick}
+			d := newButtonDetector(config, func(event string) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, event)
+			})
+			for _, step := range test.steps {
+				d.update(step.state)
+				time.Sleep(step.d)
+			}
+			time.Sleep(settle)
+			d.stop()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(events, test.events) {
+				t.Fatalf("events %v - expected %v", events, test.events)
+			}
+		})
+	}
+}
+
+func TestSpeedSteps(t *testing.T) {
+	for _, speedSteps := range []uint{SpeedSteps14, SpeedSteps28, SpeedSteps128} {
+		config := &LocoConfig{SpeedSteps: speedSteps}
+		max := config.maxStep()
+		for step := uint(0); step <= max; step++ {
+			speed := config.stepSpeed(float64(step))
+			if speed > maxSpeed {
+				t.Fatalf("%d speed steps: step %d - speed %d out of range", speedSteps, step, speed)
+			}
+			if got := config.speedStep(speed); got != step {
+				t.Fatalf("%d speed steps: step %d - speed %d - step %d", speedSteps, step, speed, got)
+			}
+		}
+	}
+}
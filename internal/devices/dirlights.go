@@ -0,0 +1,63 @@
+package devices
+
+import (
+	"fmt"
+)
+
+// LocoDirLightsConfig represents configuration data binding loco functions to the direction, so that
+// head and tail lights of decoders without direction-dependent lighting outputs are switched automatically.
+type LocoDirLightsConfig struct {
+	// functions switched on in forward and off in backward direction (e.g. head lights)
+	Forward []string `json:"forward"`
+	// functions switched on in backward and off in forward direction (e.g. tail lights)
+	Backward []string `json:"backward"`
+}
+
+func (c *LocoDirLightsConfig) validate(fcts map[string]LocoFctConfig) error {
+	for _, names := range [][]string{c.Forward, c.Backward} {
+		for _, name := range names {
+			if _, ok := fcts[name]; !ok {
+				return fmt.Errorf("function %s not found", name)
+			}
+		}
+	}
+	return nil
+}
+
+// switchDirLights switches the direction bound light functions after a direction change.
+// Lights which are switched off completely (none of the bound functions is on) are kept off.
+func (cs *CS) switchDirLights(loco *Loco, dir bool) {
+	lights := loco.config.DirLights
+	if lights == nil {
+		return
+	}
+	state := loco.State()
+	on := false
+	for _, names := range [][]string{lights.Forward, lights.Backward} {
+		for _, name := range names {
+			on = on || state.Fcts[name]
+		}
+	}
+	if !on {
+		return
+	}
+
+	set := func(names []string, fct bool) {
+		for _, name := range names {
+			if state.Fcts[name] == fct {
+				continue
+			}
+			if err := cs.switchLocoFct(loco, name, fct); err != nil {
+				cs.lg.Printf("loco %s: direction lights function %s: %s", loco.name(), name, err)
+			}
+		}
+	}
+	// switch off first to avoid both lights being on
+	set(lights.Forward, false)
+	set(lights.Backward, false)
+	if dir {
+		set(lights.Forward, true)
+	} else {
+		set(lights.Backward, true)
+	}
+}
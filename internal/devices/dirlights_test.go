@@ -0,0 +1,33 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+func TestDirLights(t *testing.T) {
+	fcts := map[string]LocoFctConfig{"head": {No: 0}, "tail": {No: 1}}
+	if err := (&LocoDirLightsConfig{Forward: []string{"head"}, Backward: []string{"tail"}}).validate(fcts); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&LocoDirLightsConfig{Forward: []string{"head"}, Backward: []string{"cab"}}).validate(fcts); err == nil {
+		t.Fatal("unknown direction light function not detected")
+	}
+
+	config := NewLocoConfig()
+	config.Name = "br01"
+	config.Addr = 1
+	config.Fcts = fcts
+	config.DirLights = &LocoDirLightsConfig{Forward: []string{"head"}, Backward: []string{"tail"}}
+	loco, err := newLoco(logger.Null, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lights switched off completely are kept off (the command station client would be called otherwise)
+	cs := &CS{lg: logger.Null, config: &CSConfig{Name: "cs01"}}
+	cs.switchDirLights(loco, false)
+	if state := loco.State(); state.Fcts["head"] || state.Fcts["tail"] {
+		t.Fatalf("lights switched on %v", state.Fcts)
+	}
+}
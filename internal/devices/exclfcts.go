@@ -0,0 +1,70 @@
+package devices
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// validateExclFcts validates the mutually exclusive function groups.
+func (c *LocoConfig) validateExclFcts() error {
+	for i, group := range c.ExclFcts {
+		if len(group) < 2 {
+			return fmt.Errorf("exclFcts group %d: at least two functions required", i)
+		}
+		for _, name := range group {
+			if _, ok := c.Fcts[name]; !ok {
+				return fmt.Errorf("exclFcts group %d: function %s not found", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// exclFcts returns the functions which are mutually exclusive to the functions with function number no.
+func (c *LocoConfig) exclFcts(no uint) []string {
+	var excl []string
+	for _, group := range c.ExclFcts {
+		inGroup := false
+		for _, name := range group {
+			inGroup = inGroup || c.Fcts[name].No == no
+		}
+		if !inGroup {
+			continue
+		}
+		for _, name := range group {
+			if c.Fcts[name].No != no && !slices.Contains(excl, name) {
+				excl = append(excl, name)
+			}
+		}
+	}
+	return excl
+}
+
+// switchLocoFct switches a loco function and publishes the function event.
+func (cs *CS) switchLocoFct(loco *Loco, name string, fct bool) error {
+	no := loco.config.Fcts[name].No
+	fct, err := cs.client.SetLocoFct(loco.addr(), no, fct)
+	if err != nil {
+		return err
+	}
+	loco.update(func(state *LocoState) { state.setFct(loco.config, no, fct) })
+	cs.invalidateCache(CtLoco + "/" + loco.name())
+	cs.gw.Publish([]string{"loco", loco.name(), name}, true, fct)
+	return nil
+}
+
+// switchOffExclFcts switches off the functions which are mutually exclusive to function number no
+// before the function gets enabled.
+func (cs *CS) switchOffExclFcts(loco *Loco, no uint) error {
+	state := loco.State()
+	for _, name := range loco.config.exclFcts(no) {
+		if !state.Fcts[name] {
+			continue
+		}
+		if err := cs.switchLocoFct(loco, name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
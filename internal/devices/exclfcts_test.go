@@ -0,0 +1,42 @@
+package devices
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExclFcts(t *testing.T) {
+	config := &LocoConfig{
+		Name: "br01",
+		Fcts: map[string]LocoFctConfig{"light": {No: 0}, "sound1": {No: 1}, "sound2": {No: 2}, "sound3": {No: 3}, "shunt": {No: 4}},
+		ExclFcts: [][]string{
+			{"sound1", "sound2", "sound3"},
+			{"sound3", "shunt"},
+		},
+	}
+	if err := config.validateExclFcts(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		no   uint
+		excl []string
+	}{
+		{0, nil},
+		{1, []string{"sound2", "sound3"}},
+		{3, []string{"sound1", "sound2", "shunt"}}, // member of both groups
+		{4, []string{"sound3"}},
+	}
+	for _, test := range tests {
+		if excl := config.exclFcts(test.no); !reflect.DeepEqual(excl, test.excl) {
+			t.Fatalf("exclusive functions of function %d: %v - expected %v", test.no, excl, test.excl)
+		}
+	}
+
+	for _, exclFcts := range [][][]string{{{"sound1"}}, {{"sound1", "horn"}}} {
+		config.ExclFcts = exclFcts
+		if err := config.validateExclFcts(); err == nil {
+			t.Fatalf("invalid exclusive function groups %v not detected", exclFcts)
+		}
+	}
+}
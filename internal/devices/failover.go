@@ -0,0 +1,32 @@
+package devices
+
+// failover promotes an available secondary command station of each primary loco of the unavailable
+// command station cs. The loco stays assigned to cs as secondary loco, so that cs follows the loco
+// events when it becomes available again.
+func (s *CSSet) failover(cs *CS) {
+	if cs.Available() { // available again meanwhile
+		return
+	}
+	locos := cs.filterLocos(func(loco *Loco) bool { return loco.isPrimary(cs) })
+	for name, loco := range locos {
+		target := failoverTarget(loco)
+		if target == nil {
+			s.lg.Printf("failover loco %s: no available secondary command station", name)
+			continue
+		}
+		if err := moveLoco(loco, cs, target, true); err != nil {
+			s.lg.Printf("failover loco %s: %s", name, err)
+		}
+	}
+}
+
+// failoverTarget returns the first available secondary command station of the loco in command
+// station name order (nil: no secondary command station available).
+func failoverTarget(loco *Loco) *CS {
+	for _, secondary := range loco.secondaryCSs() {
+		if secondary.Available() {
+			return secondary
+		}
+	}
+	return nil
+}
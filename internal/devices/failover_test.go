@@ -0,0 +1,25 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestFailoverTarget(t *testing.T) {
+	loco := &Loco{config: &LocoConfig{Name: "br01"}, secondaries: map[string]*CS{}}
+	css := map[string]*CS{}
+	for _, name := range []string{"cs02", "cs03", "cs04"} {
+		css[name] = &CS{config: &CSConfig{Name: name}}
+		if err := loco.addSecondary(css[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if target := failoverTarget(loco); target != nil {
+		t.Fatalf("unexpected failover target %s - no command station available", target.name())
+	}
+	css["cs04"].connected = true
+	css["cs03"].connected = true
+	if target := failoverTarget(loco); target != css["cs03"] {
+		t.Fatalf("failover target %v - expected cs03", target)
+	}
+}
@@ -0,0 +1,60 @@
+package devices
+
+import "sync"
+
+// A hookList is a list of callback functions registered by embedding programs.
+type hookList[F any] struct {
+	mu  sync.RWMutex
+	fns []F
+}
+
+func (l *hookList[F]) add(fn F) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fns = append(l.fns, fn)
+}
+
+// each calls call for each registered callback function.
+func (l *hookList[F]) each(call func(fn F)) {
+	l.mu.RLock()
+	fns := l.fns
+	l.mu.RUnlock()
+	for _, fn := range fns {
+		call(fn)
+	}
+}
+
+// An eventQueue calls a function for each pushed event asynchronously in the order the events were
+// pushed, so that the caller does not need to care about locks held while pushing an event.
+type eventQueue[E any] struct {
+	mu      sync.Mutex
+	events  []E
+	running bool
+}
+
+// push queues an event and starts a go routine calling fn for the queued events if not already running.
+func (q *eventQueue[E]) push(e E, fn func(e E)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, e)
+	if q.running {
+		return
+	}
+	q.running = true
+	go q.drain(fn)
+}
+
+func (q *eventQueue[E]) drain(fn func(e E)) {
+	for {
+		q.mu.Lock()
+		if len(q.events) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		e := q.events[0]
+		q.events = q.events[1:]
+		q.mu.Unlock()
+		fn(e)
+	}
+}
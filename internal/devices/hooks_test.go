@@ -0,0 +1,37 @@
+package devices
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestEventQueue(t *testing.T) {
+	var q eventQueue[int]
+	var mu sync.Mutex // held while pushing like the locks of the callers
+	var wg sync.WaitGroup
+	var events []int
+
+	const n = 100
+	wg.Add(n)
+	fn := func(e int) {
+		mu.Lock() // would deadlock if called synchronously by push
+		defer mu.Unlock()
+		events = append(events, e)
+		wg.Done()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		q.push(i, fn)
+		mu.Unlock()
+	}
+	wg.Wait()
+
+	expected := make([]int, n)
+	for i := range expected {
+		expected[i] = i
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("events %v - expected in push order", events)
+	}
+}
@@ -0,0 +1,104 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/pico-cs/go-client/client"
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// numGPIO is the number of pico GPIOs.
+const numGPIO = 30
+
+// ioCmdGPIO is the firmware io command selector for GPIO values.
+const ioCmdGPIO = 0
+
+// validateIOs validates the IO configuration.
+func (c *CSConfig) validateIOs() error {
+	for name, io := range c.IOs {
+		if err := gateway.CheckLevelName(name); err != nil {
+			return fmt.Errorf("io %s: %s", name, err)
+		}
+		switch io.Type {
+		case IOTypeGPIO:
+		case IOTypeSolenoid:
+			if io.Out {
+				return fmt.Errorf("io %s: solenoid coils cannot be controlled as output IO", name)
+			}
+			if err := io.Solenoid.validate(io.GPIO); err != nil {
+				return fmt.Errorf("io %s: %s", name, err)
+			}
+		default:
+			return fmt.Errorf("io %s: invalid type %s (empty or %s)", name, io.Type, IOTypeSolenoid)
+		}
+		if io.Button != nil {
+			if io.Out || io.Type != IOTypeGPIO {
+				return fmt.Errorf("io %s: button needs to be an input GPIO", name)
+			}
+			if err := io.Button.validate(); err != nil {
+				return fmt.Errorf("io %s: %s", name, err)
+			}
+		}
+		if io.GPIO >= numGPIO {
+			return fmt.Errorf("io %s: invalid gpio %d (range 0..%d)", name, io.GPIO, numGPIO-1)
+		}
+	}
+	return nil
+}
+
+// ioNames returns the IO names by GPIO.
+func (c *CSConfig) ioNames() map[uint][]string {
+	m := make(map[uint][]string, len(c.IOs))
+	for name, io := range c.IOs {
+		gpio := io.GPIO
+		m[gpio] = append(m[gpio], name)
+	}
+	return m
+}
+
+func (cs *CS) getIO(client *client.Client, gpio uint) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return client.IOVal(ioCmdGPIO, gpio)
+	}
+}
+
+func (cs *CS) setIO(client *client.Client, gpio uint) gateway.HndFn {
+	return gateway.Typed(func(value bool) (any, error) {
+		return client.SetIOVal(ioCmdGPIO, gpio, value)
+	})
+}
+
+func (cs *CS) toggleIO(client *client.Client, gpio uint) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return client.ToggleIOVal(ioCmdGPIO, gpio)
+	}
+}
+
+// subscribeIOs subscribes to the command topics of the output IOs and publishes the current output values.
+func (cs *CS) subscribeIOs() {
+	for name, io := range cs.config.IOs {
+		if !io.Out {
+			continue
+		}
+		gpio := io.GPIO
+		if value, err := cs.client.IOVal(ioCmdGPIO, gpio); err != nil {
+			cs.lg.Printf("command station %s: io %s: %s", cs.name(), name, err)
+		} else {
+			cs.gw.Publish([]string{"cs", cs.name(), name}, true, value)
+		}
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "get"}, cs.getIO(cs.client, gpio))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "set"}, cs.setIO(cs.client, gpio))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "toggle"}, cs.toggleIO(cs.client, gpio))
+	}
+}
+
+func (cs *CS) unsubscribeIOs() {
+	for name, io := range cs.config.IOs {
+		if !io.Out {
+			continue
+		}
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "get"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "set"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "toggle"})
+	}
+}
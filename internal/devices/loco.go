@@ -3,41 +3,95 @@ package devices
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
 	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	qrcode "github.com/skip2/go-qrcode"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 // LocoSet represents a set of locos.
 type LocoSet struct {
 	lg      logger.Logger
+	gw      *gateway.Gateway
+	mu      sync.RWMutex
 	locoMap map[string]*Loco
+
+	odoFilename string               // odometer file (empty: not persisted)
+	odos        map[string]*Odometer // stored odometers
+	odoDone     chan struct{}        // nil: odometers not tracked
+	odoWg       sync.WaitGroup
 }
 
 // NewLocoSet creates new loco set instance.
-func NewLocoSet(lg logger.Logger) *LocoSet {
+func NewLocoSet(lg logger.Logger, gw *gateway.Gateway) *LocoSet {
 	if lg == nil {
 		lg = logger.Null
 	}
-	return &LocoSet{lg: lg, locoMap: make(map[string]*Loco)}
+	return &LocoSet{lg: lg, gw: gw, locoMap: make(map[string]*Loco)}
 }
 
 // Items returns a loco map.
-func (s *LocoSet) Items() map[string]*Loco { return maps.Clone(s.locoMap) }
+func (s *LocoSet) Items() map[string]*Loco {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.locoMap)
+}
+
+func (s *LocoSet) item(name string) (*Loco, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loco, ok := s.locoMap[name]
+	return loco, ok
+}
 
 // Add adds a loco via a loco configuration.
 func (s *LocoSet) Add(config *LocoConfig) (*Loco, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.locoMap[config.Name]; ok {
+		return nil, fmt.Errorf("loco %s already exists", config.Name)
+	}
 	loco, err := newLoco(s.lg, config)
 	if err != nil {
 		return nil, err
 	}
+	if odo, ok := s.odos[config.Name]; ok {
+		loco.setOdometer(odo)
+	}
 	s.locoMap[config.Name] = loco
 	return loco, nil
 }
 
+// Delete closes and removes a loco. The loco needs to be removed from all command stations before.
+func (s *LocoSet) Delete(name string) error {
+	s.mu.Lock()
+	loco, ok := s.locoMap[name]
+	delete(s.locoMap, name)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("loco %s does not exist", name)
+	}
+	return loco.close()
+}
+
 // Close closes all locos.
 func (s *LocoSet) Close() error {
+	if s.odoDone != nil {
+		close(s.odoDone)
+		s.odoWg.Wait()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var lastErr error
 	for _, loco := range s.locoMap {
 		if err := loco.close(); err != nil {
@@ -49,13 +103,152 @@ func (s *LocoSet) Close() error {
 
 // ServeHTTP implements the http.Handler interface.
 func (s *LocoSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	data := locoTplData{LocoMap: s.locoMap}
+	// path: /loco[/<loco name>[/<property>]]
+	parts := splitPath(r.URL.Path)
+	if len(parts) > 1 {
+		loco, ok := s.item(parts[1])
+		if !ok {
+			server.NotFound(w, r)
+			return
+		}
+		switch {
+		case len(parts) == 2:
+			loco.ServeHTTP(w, r)
+		case len(parts) == 3 && parts[2] == "state":
+			loco.serveState(w, r)
+		case len(parts) == 3 && parts[2] == "drive":
+			s.serveDrive(w, r, loco)
+		case len(parts) == 3 && parts[2] == "qr.png":
+			loco.serveQRCode(w, r)
+		case r.Method == http.MethodPost:
+			s.serveCommand(w, r, loco, parts[2:])
+		default:
+			server.NotFound(w, r)
+		}
+		return
+	}
+
+	data := locoTplData{tplData: tplData{BasePath: server.BasePath(r)}, LocoMap: s.Items()}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if err := locoIdxTpl.Execute(w, data); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	executeTemplate(w, locoIdxTpl, data)
+}
+
+// LocoState represents the drive state of a loco.
+type LocoState struct {
+	// loco direction (true: forward, false: backward)
+	Dir bool `json:"dir"`
+	// loco speed (range 0..126)
+	Speed uint `json:"speed"`
+	// loco function values (key is the function name)
+	Fcts map[string]bool `json:"fcts"`
+}
+
+func newLocoState(config *LocoConfig) *LocoState {
+	state := &LocoState{Fcts: make(map[string]bool, len(config.Fcts))}
+	for name := range config.Fcts {
+		state.Fcts[name] = false
+	}
+	return state
+}
+
+func (s *LocoState) clone() *LocoState {
+	return &LocoState{Dir: s.Dir, Speed: s.Speed, Fcts: maps.Clone(s.Fcts)}
+}
+
+func (s *LocoState) equal(state *LocoState) bool {
+	return s.Dir == state.Dir && s.Speed == state.Speed && maps.Equal(s.Fcts, state.Fcts)
+}
+
+// setFct sets the value of all functions configured with function number no.
+func (s *LocoState) setFct(config *LocoConfig, no uint, fct bool) {
+	for name, fctConfig := range config.Fcts {
+		if fctConfig.No == no {
+			s.Fcts[name] = fct
+		}
+	}
+}
+
+// setFctGroup sets the value of all functions of function group fg.
+func (s *LocoState) setFctGroup(config *LocoConfig, fg fctGroup, mask uint) {
+	for no := fg.first; no <= fg.last; no++ {
+		s.setFct(config, no, mask&(1<<(no-fg.first)) != 0)
+	}
+}
+
+// serveDrive sets the loco drive state (POST request with a partial drive state JSON object)
+// via the primary command station of the loco.
+func (s *LocoSet) serveDrive(w http.ResponseWriter, r *http.Request, loco *Loco) {
+	if r.Method != http.MethodPost {
+		server.MethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !server.RequireJSON(w, r) {
+		return
+	}
+	var value json.RawMessage // decoded by the drive handler
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		server.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !s.gw.Dispatch([]string{"loco", loco.name(), "drive", "set"}, value) {
+		server.Error(w, fmt.Sprintf("loco %s is not assigned to a primary command station", loco.name()), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// defaultHTTPCommand is the command of a loco command request path without command level.
+const defaultHTTPCommand = "set"
+
+// serveCommand dispatches a command to the loco command topic loco/<loco name>/<property>/<command>
+// like a message received by the broker (POST request with the JSON encoded command value, path:
+// /loco/<loco name>/<property>[/<command>] or /loco/<loco name>/fct/<function name>[/<command>]).
+func (s *LocoSet) serveCommand(w http.ResponseWriter, r *http.Request, loco *Loco, parts []string) {
+	if !server.RequireJSON(w, r) {
+		return
+	}
+	if parts[0] == "fct" {
+		parts = parts[1:]
+	}
+	switch len(parts) {
+	case 1:
+		parts = append(parts, defaultHTTPCommand)
+	case 2:
+	default:
+		server.NotFound(w, r)
+		return
+	}
+	value := json.RawMessage("null") // commands like toggle do not need a value
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil && err != io.EOF {
+		server.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.gw.Dispatch([]string{"loco", loco.name(), parts[0], parts[1]}, value) {
+		server.Error(w, fmt.Sprintf("loco %s command %s/%s not available (invalid command or no primary command station)", loco.name(), parts[0], parts[1]), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ServeThrottle provides a mobile throttle page for a loco (path: /throttle/<loco name>).
+func (s *LocoSet) ServeThrottle(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+	if len(parts) != 2 {
+		server.NotFound(w, r)
+		return
+	}
+	loco, ok := s.item(parts[1])
+	if !ok {
+		server.NotFound(w, r)
+		return
+	}
+
+	data := throttleTplData{tplData: tplData{BasePath: server.BasePath(r)}, Name: loco.name(), Fcts: maps.Keys(loco.config.Fcts)}
+	slices.Sort(data.Fcts)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	executeTemplate(w, throttleTpl, data)
 }
 
 // A Loco represents a loco.
@@ -64,23 +257,92 @@ type Loco struct {
 	config      *LocoConfig
 	primary     *CS
 	secondaries map[string]*CS
+
+	mu      sync.RWMutex // protects command station assignment and state
+	state   *LocoState
+	changed chan struct{} // closed and replaced on every state change
+	odo     odometer
+
+	shunt atomic.Bool // shunting mode (halved speed range, no momentum)
+
+	pairMu  sync.Mutex
+	pairing *locoPairing // double heading pairing (nil: not paired)
 }
 
 // newLoco returns a new loco instance.
 func newLoco(lg logger.Logger, config *LocoConfig) (*Loco, error) {
-	if err := config.validate(); err != nil {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-	return &Loco{lg: lg, config: config, secondaries: map[string]*CS{}}, nil
+	return &Loco{
+		lg:          lg,
+		config:      config,
+		secondaries: map[string]*CS{},
+		state:       newLocoState(config),
+		changed:     make(chan struct{}),
+	}, nil
+}
+
+// State returns a copy of the current loco drive state.
+func (l *Loco) State() *LocoState {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state.clone()
+}
+
+// update updates the loco state via function fn and returns a copy of the new state
+// and if the state was changed.
+func (l *Loco) update(fn func(state *LocoState)) (*LocoState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.state.clone()
+	fn(l.state)
+	if old.equal(l.state) {
+		return l.state.clone(), false
+	}
+	if old.Speed != l.state.Speed {
+		l.odo.advance(l.config, time.Now(), l.state.Speed)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return l.state.clone(), true
+}
+
+// stateChanged returns a channel which gets closed on the next state change.
+func (l *Loco) stateChanged() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
 }
 
 func (l *Loco) name() string { return l.config.Name }
 
-func (l *Loco) isPrimary(cs *CS) bool { return cs == l.primary }
+// Config returns the loco configuration.
+func (l *Loco) Config() *LocoConfig { return l.config }
 
-func (l *Loco) isSecondary(cs *CS) bool { _, ok := l.secondaries[cs.name()]; return ok }
+func (l *Loco) isPrimary(cs *CS) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return cs == l.primary
+}
+
+func (l *Loco) isSecondary(cs *CS) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.secondaries[cs.name()]
+	return ok
+}
+
+// primaryCS returns the primary command station of the loco (nil: not assigned).
+func (l *Loco) primaryCS() *CS {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.primary
+}
 
 func (l *Loco) setPrimary(cs *CS) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if l.primary != nil {
 		return fmt.Errorf("loco %s is already assigned to primary command station %s", l.name(), cs.name())
 	}
@@ -89,6 +351,8 @@ func (l *Loco) setPrimary(cs *CS) error {
 }
 
 func (l *Loco) unsetPrimary(cs *CS) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if l.primary != cs {
 		return fmt.Errorf("loco %s is not assigned to primary command station %s", l.name(), cs.name())
 	}
@@ -97,6 +361,8 @@ func (l *Loco) unsetPrimary(cs *CS) error {
 }
 
 func (l *Loco) addSecondary(cs *CS) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if _, ok := l.secondaries[cs.name()]; ok {
 		return fmt.Errorf("loco %s is already assigned to secondary command station %s", l.name(), cs.name())
 	}
@@ -105,6 +371,8 @@ func (l *Loco) addSecondary(cs *CS) error {
 }
 
 func (l *Loco) delSecondary(cs *CS) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if _, ok := l.secondaries[cs.name()]; !ok {
 		return fmt.Errorf("loco %s is not assigned to secondary command station %s", l.name(), cs.name())
 	}
@@ -112,22 +380,124 @@ func (l *Loco) delSecondary(cs *CS) error {
 	return nil
 }
 
+// secondaryCSs returns the secondary command stations of the loco sorted by name.
+func (l *Loco) secondaryCSs() []*CS {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := maps.Keys(l.secondaries)
+	slices.Sort(names)
+	css := make([]*CS, len(names))
+	for i, name := range names {
+		css[i] = l.secondaries[name]
+	}
+	return css
+}
+
 func (l *Loco) addr() uint { return l.config.Addr }
+
+// fct returns true if a function with function number no is on.
+func (l *Loco) fct(no uint) bool {
+	state := l.State()
+	for name, fctConfig := range l.config.Fcts {
+		if fctConfig.No == no && state.Fcts[name] {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *Loco) iterFcts(fn func(name string, no uint)) {
 	for name, fct := range l.config.Fcts {
 		fn(name, fct.No)
 	}
 }
 
-func (l *Loco) close() error { return nil }
+func (l *Loco) close() error {
+	l.pairMu.Lock()
+	defer l.pairMu.Unlock()
+	if l.pairing != nil {
+		l.pairing.stop()
+		l.pairing = nil
+	}
+	return nil
+}
 
 // ServeHTTP implements the http.Handler interface.
 func (l *Loco) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	b, err := json.MarshalIndent(l.config, "", indent)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		server.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Write(b)
 }
+
+// maxStateWait is the maximum waiting time of a state long-poll request.
+const maxStateWait = 5 * time.Minute
+
+// serveState returns the loco drive state. If the wait parameter is provided (e.g. ?wait=30s)
+// the request returns not before the state did change or the waiting time did expire (long-poll).
+func (l *Loco) serveState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if v := r.URL.Query().Get("wait"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil || wait < 0 || wait > maxStateWait {
+			server.Error(w, fmt.Sprintf("invalid wait parameter %s (range 0..%s)", v, maxStateWait), http.StatusBadRequest)
+			return
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-l.stateChanged():
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	b, err := json.MarshalIndent(l.State(), "", indent)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+const (
+	defQRCodeSize = 256
+	maxQRCodeSize = 2048
+)
+
+// serveQRCode returns a QR code (PNG) linking to the throttle page of the loco.
+// The image size in pixels can be set via the size parameter (e.g. ?size=512).
+func (l *Loco) serveQRCode(w http.ResponseWriter, r *http.Request) {
+	size := defQRCodeSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		var err error
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 || size > maxQRCodeSize {
+			server.Error(w, fmt.Sprintf("invalid size parameter %s (range 1..%d)", v, maxQRCodeSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if v := r.Header.Get("X-Forwarded-Proto"); v != "" {
+		scheme = v
+	}
+	link := &url.URL{Scheme: scheme, Host: r.Host, Path: server.BasePath(r) + "/throttle/" + l.name()}
+
+	b, err := qrcode.Encode(link.String(), qrcode.Medium, size)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(b)
+}
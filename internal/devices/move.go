@@ -0,0 +1,56 @@
+package devices
+
+import "fmt"
+
+// MoveLoco moves the primary control of a loco to the command station csName at runtime. The former
+// primary command station keeps the loco as secondary loco in case the loco matches its secondary filter.
+func (s *CSSet) MoveLoco(loco *Loco, csName string) error {
+	to, ok := s.Items()[csName]
+	if !ok {
+		return fmt.Errorf("command station %s does not exist", csName)
+	}
+	from := loco.primaryCS()
+	if from == to {
+		return nil
+	}
+	return moveLoco(loco, from, to, from != nil && from.secondary.includes(loco.name()))
+}
+
+// moveLoco moves the primary control of a loco from command station from (nil: no primary command
+// station) to command station to. If secondary is true the loco is assigned to from as secondary loco.
+func moveLoco(loco *Loco, from, to *CS, secondary bool) error {
+	name := loco.name()
+	fromName := "none"
+
+	if from != nil {
+		fromName = from.name()
+		from.mu.Lock()
+		if !loco.isPrimary(from) {
+			from.mu.Unlock()
+			return fmt.Errorf("loco %s is not assigned to primary command station %s", name, fromName)
+		}
+		from.removeLoco(loco)
+		if secondary {
+			if err := loco.addSecondary(from); err == nil {
+				from.locos[name] = loco
+				from.subscribeLocoEvents(loco)
+			}
+		}
+		from.mu.Unlock()
+	}
+
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	if _, ok := to.locos[name]; ok {
+		to.removeLoco(loco)
+	}
+	if err := loco.setPrimary(to); err != nil {
+		return err
+	}
+	to.locos[name] = loco
+	to.lg.Printf("move loco %s from command station %s to %s as primary", name, fromName, to.name())
+	to.subscribeLocoActions(loco)
+	to.restoreLoco(loco)
+	to.gw.Publish([]string{"loco", name, "primary"}, true, to.name())
+	return nil
+}
@@ -0,0 +1,28 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestMoveLoco(t *testing.T) {
+	cs01 := &CS{config: &CSConfig{Name: "cs01"}, locos: map[string]*Loco{}}
+	cs02 := &CS{config: &CSConfig{Name: "cs02"}, locos: map[string]*Loco{}}
+	s := &CSSet{csMap: map[string]*CS{"cs01": cs01, "cs02": cs02}}
+	loco := &Loco{config: &LocoConfig{Name: "br01"}, secondaries: map[string]*CS{}}
+	if err := loco.setPrimary(cs01); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MoveLoco(loco, "cs03"); err == nil {
+		t.Fatal("move to not existing command station not detected")
+	}
+	if err := s.MoveLoco(loco, "cs01"); err != nil {
+		t.Fatalf("move to current primary command station: %s", err)
+	}
+	if err := moveLoco(loco, cs02, cs01, false); err == nil {
+		t.Fatal("move from command station the loco is not assigned to not detected")
+	}
+	if primary := loco.primaryCS(); primary != cs01 {
+		t.Fatalf("primary command station changed to %v", primary)
+	}
+}
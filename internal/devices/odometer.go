@@ -0,0 +1,228 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultOdometerInterval is the default interval the loco odometers are published and persisted.
+const DefaultOdometerInterval = time.Minute
+
+// LocoMaintenanceConfig represents a maintenance interval of a loco (e.g. lubricate after 10 h).
+// A maintenance gets due as soon as one of the configured intervals is exceeded.
+type LocoMaintenanceConfig struct {
+	// running time in hours (0: no running time interval)
+	Hours float64 `json:"hours"`
+	// estimated distance in km (0: no distance interval)
+	Km float64 `json:"km"`
+}
+
+// OdometerReading represents an odometer reading of a loco.
+type OdometerReading struct {
+	// running time in hours
+	Runtime float64 `json:"runtime"`
+	// estimated distance in km
+	Distance float64 `json:"distance"`
+}
+
+// Odometer represents the accumulated running time and estimated distance of a loco.
+type Odometer struct {
+	OdometerReading
+	// odometer readings of the last maintenances (key: maintenance name)
+	Serviced map[string]OdometerReading `json:"serviced,omitempty"`
+}
+
+func (o *Odometer) clone() *Odometer {
+	return &Odometer{OdometerReading: o.OdometerReading, Serviced: maps.Clone(o.Serviced)}
+}
+
+// due returns the sorted names of the due maintenances.
+func (o *Odometer) due(config *LocoConfig) []string {
+	due := []string{}
+	for name, mConfig := range config.Maintenance {
+		serviced := o.Serviced[name]
+		if (mConfig.Hours > 0 && o.Runtime-serviced.Runtime >= mConfig.Hours) || (mConfig.Km > 0 && o.Distance-serviced.Distance >= mConfig.Km) {
+			due = append(due, name)
+		}
+	}
+	sort.Strings(due)
+	return due
+}
+
+// odometer tracks the running time and distance of a loco (protected by the loco mutex).
+type odometer struct {
+	Odometer
+	speed uint      // current speed
+	since time.Time // time of the last advance
+	due   []string  // last published due maintenances
+}
+
+// advance accumulates running time and distance since the last advance and sets the current speed.
+func (o *odometer) advance(config *LocoConfig, now time.Time, speed uint) {
+	if o.speed > 0 && !o.since.IsZero() {
+		hours := now.Sub(o.since).Hours()
+		o.Runtime += hours
+		o.Distance += config.ScaleSpeed * float64(o.speed) / maxSpeed * hours
+	}
+	o.speed = speed
+	o.since = now
+}
+
+// Odometer returns a copy of the current odometer of the loco.
+func (l *Loco) Odometer() *Odometer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.odo.advance(l.config, time.Now(), l.odo.speed)
+	return l.odo.clone()
+}
+
+// setOdometer initializes the odometer by a stored odometer.
+func (l *Loco) setOdometer(odo *Odometer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.odo.Odometer = *odo.clone()
+	l.odo.due = l.odo.Odometer.due(l.config)
+}
+
+// checkMaintenance returns the due maintenances and if they changed since the last call.
+func (l *Loco) checkMaintenance() ([]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	due := l.odo.Odometer.due(l.config)
+	if slices.Equal(due, l.odo.due) {
+		return due, false
+	}
+	l.odo.due = due
+	return due, true
+}
+
+// serviced records a maintenance at the current odometer reading and returns the due maintenances.
+func (l *Loco) serviced(name string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.config.Maintenance[name]; !ok {
+		return nil, fmt.Errorf("loco %s: maintenance %s not found", l.name(), name)
+	}
+	l.odo.advance(l.config, time.Now(), l.odo.speed)
+	if l.odo.Serviced == nil {
+		l.odo.Serviced = map[string]OdometerReading{}
+	}
+	l.odo.Serviced[name] = l.odo.OdometerReading
+	l.odo.due = l.odo.Odometer.due(l.config)
+	return l.odo.due, nil
+}
+
+func (cs *CS) setLocoServiced(loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(name string) (any, error) {
+		return loco.serviced(name)
+	})
+}
+
+// TrackOdometers loads the loco odometers stored in filename (empty: not persisted) and starts
+// publishing the odometers and due maintenances of the locos periodically. The odometers are
+// persisted after each interval and on close.
+func (s *LocoSet) TrackOdometers(filename string, interval time.Duration) error {
+	odos := map[string]*Odometer{}
+	if filename != "" {
+		b, err := os.ReadFile(filename)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(b, &odos); err != nil {
+				return fmt.Errorf("odometer file %s: %s", filename, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.odoFilename = filename
+	s.odos = odos
+	for name, loco := range s.locoMap {
+		if odo, ok := odos[name]; ok {
+			loco.setOdometer(odo)
+		}
+	}
+	s.mu.Unlock()
+
+	s.odoDone = make(chan struct{})
+	s.odoWg.Add(1)
+	go s.odometerTicker(interval)
+	return nil
+}
+
+func (s *LocoSet) odometerTicker(interval time.Duration) {
+	defer s.odoWg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.odoDone:
+			s.saveOdometers()
+			return
+		case <-ticker.C:
+			s.publishOdometers()
+			s.saveOdometers()
+		}
+	}
+}
+
+func (s *LocoSet) publishOdometers() {
+	for name, loco := range s.Items() {
+		odo := loco.Odometer()
+		s.gw.PublishTelemetry([]string{"loco", name, "odometer"}, true, &odo.OdometerReading)
+		if due, changed := loco.checkMaintenance(); changed {
+			if len(due) > 0 {
+				s.lg.Printf("loco %s: maintenance due %v", name, due)
+			}
+			s.gw.Publish([]string{"loco", name, "maintenance"}, true, due)
+		}
+	}
+}
+
+// saveOdometers writes the odometers atomically to the odometer file (keeping the odometers of removed locos).
+func (s *LocoSet) saveOdometers() {
+	if s.odoFilename == "" {
+		return
+	}
+	s.mu.Lock()
+	for name, loco := range s.locoMap {
+		s.odos[name] = loco.Odometer()
+	}
+	b, err := json.MarshalIndent(s.odos, "", "    ")
+	s.mu.Unlock()
+	if err != nil {
+		s.lg.Printf("save odometers: %s", err)
+		return
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.odoFilename), filepath.Base(s.odoFilename)+".*")
+	if err != nil {
+		s.lg.Printf("save odometers: %s", err)
+		return
+	}
+	defer os.Remove(f.Name()) // no-op after successful rename
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		s.lg.Printf("save odometers: %s", err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		s.lg.Printf("save odometers: %s", err)
+		return
+	}
+	if err := os.Rename(f.Name(), s.odoFilename); err != nil {
+		s.lg.Printf("save odometers: %s", err)
+	}
+}
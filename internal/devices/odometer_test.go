@@ -0,0 +1,35 @@
+package devices
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOdometer(t *testing.T) {
+	config := &LocoConfig{
+		ScaleSpeed:  maxSpeed, // km/h at maximum speed
+		Maintenance: map[string]LocoMaintenanceConfig{"lubricate": {Hours: 1}, "wheels": {Km: 200}},
+	}
+
+	var o odometer
+	now := time.Now()
+	o.advance(config, now, maxSpeed/2)
+	o.advance(config, now.Add(2*time.Hour), 0)
+	o.advance(config, now.Add(5*time.Hour), 0) // stopped
+	if o.Runtime != 2 || o.Distance != maxSpeed {
+		t.Fatalf("odometer runtime %f h distance %f km - expected 2 h %d km", o.Runtime, o.Distance, maxSpeed)
+	}
+
+	if due := o.Odometer.due(config); !reflect.DeepEqual(due, []string{"lubricate"}) {
+		t.Fatalf("due maintenances %v - expected [lubricate]", due)
+	}
+	o.Serviced = map[string]OdometerReading{"lubricate": o.OdometerReading}
+	if due := o.Odometer.due(config); len(due) != 0 {
+		t.Fatalf("due maintenances %v after service - expected none", due)
+	}
+	o.Distance += 200
+	if due := o.Odometer.due(config); !reflect.DeepEqual(due, []string{"wheels"}) {
+		t.Fatalf("due maintenances %v - expected [wheels]", due)
+	}
+}
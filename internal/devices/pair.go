@@ -0,0 +1,138 @@
+package devices
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// maxPairTrim is the maximum speed trim factor of a helper loco.
+const maxPairTrim = 2.0
+
+// LocoPair represents a double heading pairing of a lead loco with a helper loco. The gateway mirrors
+// the direction and speed changes of the lead loco to the helper loco.
+type LocoPair struct {
+	// helper loco name (empty: no pairing)
+	Helper string `json:"helper"`
+	// factor the lead speed is multiplied with for the helper (default 1.0) adapting different motor characteristics
+	Trim float64 `json:"trim"`
+	// helper runs in opposite direction (e.g. coupled back to back)
+	Invert bool `json:"invert"`
+}
+
+func (p *LocoPair) trim() float64 {
+	if p.Trim == 0 {
+		return 1
+	}
+	return p.Trim
+}
+
+// helperVelocity returns the helper velocity of the lead drive state (step: lead speed in the
+// speed step range 0..maxStep of the lead loco).
+func (p *LocoPair) helperVelocity(dir bool, step, maxStep uint) float64 {
+	v := math.Round(float64(step) * p.trim())
+	if v > float64(maxStep) {
+		v = float64(maxStep)
+	}
+	if dir == p.Invert {
+		v = -v
+	}
+	return v
+}
+
+// locoPairing represents an active pairing mirroring the lead loco to the helper loco.
+type locoPairing struct {
+	pair LocoPair
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (p *locoPairing) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// mirror dispatches the direction and speed changes of the lead loco as velocity commands to the helper loco.
+func (p *locoPairing) mirror(lg logger.Logger, gw *gateway.Gateway, lead *Loco) {
+	defer p.wg.Done()
+
+	first := true
+	var dir bool
+	var speed uint
+	for {
+		ch := lead.stateChanged()
+		state := lead.State()
+		if first || state.Dir != dir || state.Speed != speed {
+			first, dir, speed = false, state.Dir, state.Speed
+			v := p.pair.helperVelocity(dir, lead.config.speedStep(speed127(speed)), lead.config.maxStep())
+			if !gw.Dispatch([]string{"loco", p.pair.Helper, "velocity", "set"}, v) {
+				lg.Printf("pair %s: helper loco %s is not assigned to a primary command station", lead.name(), p.pair.Helper)
+			}
+		}
+		select {
+		case <-p.done:
+			return
+		case <-ch:
+		}
+	}
+}
+
+// setPair pairs the loco with a helper loco (empty helper: unpair).
+func (l *Loco) setPair(gw *gateway.Gateway, pair LocoPair) error {
+	if pair.Helper != "" {
+		if pair.Helper == l.name() {
+			return fmt.Errorf("loco %s cannot be paired with itself", l.name())
+		}
+		if err := gateway.CheckLevelName(pair.Helper); err != nil {
+			return fmt.Errorf("invalid helper loco %s: %s", pair.Helper, err)
+		}
+		if pair.Trim < 0 || pair.Trim > maxPairTrim {
+			return fmt.Errorf("invalid trim %v (range 0..%v)", pair.Trim, maxPairTrim)
+		}
+	}
+
+	l.pairMu.Lock()
+	defer l.pairMu.Unlock()
+	if l.pairing != nil {
+		l.pairing.stop()
+		l.pairing = nil
+	}
+	if pair.Helper == "" {
+		return nil
+	}
+	l.pairing = &locoPairing{pair: pair, done: make(chan struct{})}
+	l.pairing.wg.Add(1)
+	go l.pairing.mirror(l.lg, gw, l)
+	return nil
+}
+
+// pair returns the current pairing of the loco.
+func (l *Loco) pair() LocoPair {
+	l.pairMu.Lock()
+	defer l.pairMu.Unlock()
+	if l.pairing == nil {
+		return LocoPair{}
+	}
+	return l.pairing.pair
+}
+
+func (cs *CS) getLocoPair(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return loco.pair(), nil
+	}
+}
+
+func (cs *CS) setLocoPair(loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(pair LocoPair) (any, error) {
+		if err := loco.setPair(cs.gw, pair); err != nil {
+			return nil, err
+		}
+		if pair.Helper != "" {
+			cs.lg.Printf("pair loco %s with helper %s (trim %.2f invert %t)", loco.name(), pair.Helper, pair.trim(), pair.Invert)
+		}
+		return pair, nil
+	})
+}
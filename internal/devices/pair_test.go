@@ -0,0 +1,42 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestHelperVelocity(t *testing.T) {
+	tests := []struct {
+		pair     LocoPair
+		dir      bool
+		step     uint
+		velocity float64
+	}{
+		{LocoPair{Helper: "br02"}, true, 50, 50}, // default trim 1.0
+		{LocoPair{Helper: "br02"}, false, 50, -50},
+		{LocoPair{Helper: "br02", Trim: 1.1}, true, 50, 55},
+		{LocoPair{Helper: "br02", Trim: 0.5}, true, 3, 2},   // rounded
+		{LocoPair{Helper: "br02", Trim: 2}, true, 100, 126}, // limited to max step
+		{LocoPair{Helper: "br02", Invert: true}, true, 50, -50},
+		{LocoPair{Helper: "br02", Invert: true}, false, 50, 50},
+	}
+	for _, test := range tests {
+		if v := test.pair.helperVelocity(test.dir, test.step, maxSpeed); v != test.velocity {
+			t.Fatalf("helper velocity %+v dir %t step %d: %v - expected %v", test.pair, test.dir, test.step, v, test.velocity)
+		}
+	}
+}
+
+func TestSetPair(t *testing.T) {
+	loco := &Loco{config: &LocoConfig{Name: "br01"}}
+	for _, pair := range []LocoPair{{Helper: "br01"}, {Helper: "br/02"}, {Helper: "br02", Trim: -1}, {Helper: "br02", Trim: 2.5}} {
+		if err := loco.setPair(nil, pair); err == nil {
+			t.Fatalf("invalid pairing %+v not detected", pair)
+		}
+	}
+	if err := loco.setPair(nil, LocoPair{}); err != nil {
+		t.Fatal(err)
+	}
+	if pair := loco.pair(); pair.Helper != "" {
+		t.Fatalf("unexpected pairing %+v", pair)
+	}
+}
@@ -0,0 +1,199 @@
+package devices
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// Pattern limits.
+const (
+	minPatternPeriod   = 100   // ms
+	maxPatternPeriod   = 60000 // ms
+	defaultPatternDuty = 50    // percent
+)
+
+// CSPatternConfig represents configuration data for an output pattern (e.g. crossing flasher or warning light)
+// switching command station output IOs periodically while the pattern is enabled.
+type CSPatternConfig struct {
+	// names of the output IOs switched by the pattern
+	IOs []string `json:"ios"`
+	// blink period in milliseconds
+	Period uint `json:"period"`
+	// percentage of the period the IOs are switched on (default 50)
+	Duty uint `json:"duty"`
+	// alternate the IOs (even IOs are switched on during the on phase, odd IOs during the off phase)
+	Alternate bool `json:"alternate"`
+}
+
+func (c *CSPatternConfig) duty() uint {
+	if c.Duty == 0 {
+		return defaultPatternDuty
+	}
+	return c.Duty
+}
+
+func (c *CSPatternConfig) validate(ios map[string]CSIOConfig) error {
+	if len(c.IOs) == 0 {
+		return fmt.Errorf("ios missing")
+	}
+	for _, name := range c.IOs {
+		io, ok := ios[name]
+		if !ok {
+			return fmt.Errorf("io %s not found", name)
+		}
+		if !io.Out {
+			return fmt.Errorf("io %s is no output IO", name)
+		}
+	}
+	if c.Period < minPatternPeriod || c.Period > maxPatternPeriod {
+		return fmt.Errorf("invalid period %dms (range %d..%dms)", c.Period, minPatternPeriod, maxPatternPeriod)
+	}
+	if c.Duty > 100 {
+		return fmt.Errorf("invalid duty %d%% (range 0..100%%)", c.Duty)
+	}
+	return nil
+}
+
+// validatePatterns validates the output pattern configuration.
+func (c *CSConfig) validatePatterns() error {
+	for name, pattern := range c.Patterns {
+		if err := gateway.CheckLevelName(name); err != nil {
+			return fmt.Errorf("pattern %s: %s", name, err)
+		}
+		if _, ok := c.IOs[name]; ok {
+			return fmt.Errorf("pattern %s: name already used by io", name)
+		}
+		if err := pattern.validate(c.IOs); err != nil {
+			return fmt.Errorf("pattern %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// ioPattern represents a running state of an output pattern.
+type ioPattern struct {
+	cs    *CS
+	gpios []uint
+	on    time.Duration
+	off   time.Duration
+	alt   bool
+
+	mu   sync.Mutex
+	stop chan struct{} // nil: pattern disabled
+}
+
+func newIOPattern(cs *CS, config CSPatternConfig) *ioPattern {
+	gpios := make([]uint, len(config.IOs))
+	for i, name := range config.IOs {
+		gpios[i] = cs.config.IOs[name].GPIO
+	}
+	on := time.Duration(config.Period*config.duty()/100) * time.Millisecond
+	return &ioPattern{
+		cs:    cs,
+		gpios: gpios,
+		on:    on,
+		off:   time.Duration(config.Period)*time.Millisecond - on,
+		alt:   config.Alternate,
+	}
+}
+
+func (p *ioPattern) enabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stop != nil
+}
+
+func (p *ioPattern) enable(enabled bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case enabled && p.stop == nil:
+		p.stop = make(chan struct{})
+		go p.run(p.stop)
+	case !enabled && p.stop != nil:
+		close(p.stop)
+		p.stop = nil
+	}
+	return enabled
+}
+
+// set switches the IOs to the on (true) or off (false) phase.
+func (p *ioPattern) set(phase bool) {
+	for i, gpio := range p.gpios {
+		value := phase
+		if p.alt && i%2 == 1 {
+			value = !phase
+		}
+		if _, err := p.cs.client.SetIOVal(ioCmdGPIO, gpio, value); err != nil {
+			p.cs.lg.Printf("command station %s: pattern io %d: %s", p.cs.name(), gpio, err)
+		}
+	}
+}
+
+func (p *ioPattern) reset() {
+	for _, gpio := range p.gpios {
+		p.cs.client.SetIOVal(ioCmdGPIO, gpio, false)
+	}
+}
+
+func (p *ioPattern) run(stop <-chan struct{}) {
+	defer p.reset()
+
+	phase := true
+	for {
+		d := p.off
+		if phase {
+			d = p.on
+		}
+		if d > 0 {
+			p.set(phase)
+		}
+		select {
+		case <-stop:
+			return
+		case <-p.cs.done:
+			return
+		case <-time.After(d):
+		}
+		phase = !phase
+	}
+}
+
+func (cs *CS) getPattern(p *ioPattern) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return p.enabled(), nil
+	}
+}
+
+func (cs *CS) setPattern(p *ioPattern) gateway.HndFn {
+	return gateway.Typed(func(enabled bool) (any, error) {
+		return p.enable(enabled), nil
+	})
+}
+
+func (cs *CS) togglePattern(p *ioPattern) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return p.enable(!p.enabled()), nil
+	}
+}
+
+// subscribePatterns subscribes to the command topics of the output patterns.
+func (cs *CS) subscribePatterns() {
+	for name, config := range cs.config.Patterns {
+		p := newIOPattern(cs, config)
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "get"}, cs.getPattern(p))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "set"}, cs.setPattern(p))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "toggle"}, cs.togglePattern(p))
+	}
+}
+
+func (cs *CS) unsubscribePatterns() {
+	for name := range cs.config.Patterns {
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "get"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "set"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "toggle"})
+	}
+}
@@ -0,0 +1,42 @@
+package devices
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPattern(t *testing.T) {
+	ios := map[string]CSIOConfig{"led1": {GPIO: 10, Out: true}, "led2": {GPIO: 11, Out: true}, "button": {GPIO: 12}}
+	config := &CSConfig{Name: "cs01", IOs: ios}
+
+	tests := []struct {
+		pattern CSPatternConfig
+		ok      bool
+	}{
+		{CSPatternConfig{IOs: []string{"led1", "led2"}, Period: 1000}, true},
+		{CSPatternConfig{Period: 1000}, false},                                   // ios missing
+		{CSPatternConfig{IOs: []string{"led3"}, Period: 1000}, false},            // io not found
+		{CSPatternConfig{IOs: []string{"button"}, Period: 1000}, false},          // no output io
+		{CSPatternConfig{IOs: []string{"led1"}, Period: 50}, false},              // period too short
+		{CSPatternConfig{IOs: []string{"led1"}, Period: 1000, Duty: 101}, false}, // duty out of range
+	}
+	for _, test := range tests {
+		config.Patterns = map[string]CSPatternConfig{"crossing": test.pattern}
+		if err := config.validatePatterns(); (err == nil) != test.ok {
+			t.Fatalf("validate pattern %+v: %v", test.pattern, err)
+		}
+	}
+	config.Patterns = map[string]CSPatternConfig{"led1": tests[0].pattern}
+	if err := config.validatePatterns(); err == nil {
+		t.Fatal("pattern name used by io not detected")
+	}
+
+	p := newIOPattern(&CS{config: config}, CSPatternConfig{IOs: []string{"led1", "led2"}, Period: 1000, Duty: 25, Alternate: true})
+	if !reflect.DeepEqual(p.gpios, []uint{10, 11}) || p.on != 250*time.Millisecond || p.off != 750*time.Millisecond || !p.alt {
+		t.Fatalf("invalid pattern gpios %v on %s off %s alternate %t", p.gpios, p.on, p.off, p.alt)
+	}
+	if p := newIOPattern(&CS{config: config}, CSPatternConfig{IOs: []string{"led1"}, Period: 1000}); p.on != p.off {
+		t.Fatalf("default duty on %s off %s - expected 50%%", p.on, p.off)
+	}
+}
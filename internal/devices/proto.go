@@ -0,0 +1,88 @@
+package devices
+
+import (
+	"github.com/pico-cs/go-client/client"
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/pb"
+)
+
+func (s *LocoState) proto() *pb.LocoState {
+	return &pb.LocoState{Dir: s.Dir, Speed: uint32(s.Speed), Fcts: s.Fcts}
+}
+
+func newLocoDriveFromProto(m *pb.LocoDrive) *locoDrive {
+	drive := &locoDrive{Dir: m.Dir, Fcts: m.Fcts}
+	if m.Speed != nil {
+		speed := float64(*m.Speed)
+		drive.Speed = &speed
+	}
+	return drive
+}
+
+// subscribeProto subscribes to the command station protocol buffer command topics.
+func (cs *CS) subscribeProto() {
+	cs.gw.Subscribe(cs.hndCh, cs, []string{gateway.ClassProto, "cs", cs.name(), "mte", "get"}, cs.getProtoMTE(cs.client))
+	cs.gw.Subscribe(cs.hndCh, cs, []string{gateway.ClassProto, "cs", cs.name(), "mte", "set"}, cs.setProtoMTE(cs.client))
+}
+
+func (cs *CS) unsubscribeProto() {
+	cs.gw.Unsubscribe(cs, []string{gateway.ClassProto, "cs", cs.name(), "mte", "get"})
+	cs.gw.Unsubscribe(cs, []string{gateway.ClassProto, "cs", cs.name(), "mte", "set"})
+}
+
+// publishProtoMTE publishes the main track DCC output state to the protocol buffer topic tree.
+func (cs *CS) publishProtoMTE(enabled bool) {
+	cs.gw.Publish([]string{gateway.ClassProto, "cs", cs.name(), "mte"}, true, &pb.Power{Enabled: enabled})
+}
+
+// publishProtoDrive publishes the loco drive state to the protocol buffer topic tree.
+func (cs *CS) publishProtoDrive(loco *Loco, state *LocoState) {
+	cs.gw.Publish([]string{gateway.ClassProto, "loco", loco.name(), "drive"}, true, state.proto())
+}
+
+// protoLocoActions returns the loco protocol buffer action handlers for a loco controlled by this command station.
+func (cs *CS) protoLocoActions(loco *Loco) map[string]gateway.HndFn {
+	return map[string]gateway.HndFn{
+		"drive/get": cs.getProtoLocoDrive(loco),
+		"drive/set": cs.setProtoLocoDrive(cs.client, loco),
+	}
+}
+
+func (cs *CS) getProtoMTE(client *client.Client) gateway.HndFn {
+	return func(payload any) (any, error) {
+		enabled, err := client.MTE()
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"cs", cs.name(), "mte"}, true, enabled)
+		return &pb.Power{Enabled: enabled}, nil
+	}
+}
+
+func (cs *CS) setProtoMTE(client *client.Client) gateway.HndFn {
+	return gateway.TypedProto(func(m *pb.Power) (any, error) {
+		enabled, err := client.SetMTE(m.Enabled)
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"cs", cs.name(), "mte"}, true, enabled)
+		return &pb.Power{Enabled: enabled}, nil
+	})
+}
+
+func (cs *CS) getProtoLocoDrive(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return loco.driveState().proto(), nil
+	}
+}
+
+func (cs *CS) setProtoLocoDrive(client *client.Client, loco *Loco) gateway.HndFn {
+	return gateway.TypedProto(func(m *pb.LocoDrive) (any, error) {
+		state, err := cs.drive(client, loco, newLocoDriveFromProto(m))
+		if err != nil {
+			return nil, err
+		}
+		cs.gw.Publish([]string{"loco", loco.name(), "drive"}, true, state)
+		return state.proto(), nil
+	})
+}
@@ -0,0 +1,47 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+func TestRampSpeed(t *testing.T) {
+	tests := []struct {
+		start       speed127
+		step, steps int
+		speed       speed127
+	}{
+		{100, 0, 10, 100},
+		{100, 1, 10, 90},
+		{100, 5, 10, 50},
+		{100, 10, 10, 0},
+		{7, 9, 10, 0}, // rounded down
+		{0, 1, 10, 0},
+	}
+	for _, test := range tests {
+		if speed := rampSpeed(test.start, test.step, test.steps); speed != test.speed {
+			t.Fatalf("ramp speed start %d step %d/%d: %d - expected %d", test.start, test.step, test.steps, speed, test.speed)
+		}
+	}
+}
+
+func TestCancelRamp(t *testing.T) {
+	cs := &CS{lg: logger.Null, config: &CSConfig{Name: "cs01"}}
+	cs.startRamp(nil, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		cs.cancelRamp()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ramp not cancelled")
+	}
+	if cs.rampStop != nil {
+		t.Fatal("ramp stop channel not reset")
+	}
+}
@@ -0,0 +1,40 @@
+package devices
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rfcommScheme is the connection string scheme of Bluetooth serial (RFCOMM) connections.
+const rfcommScheme = "bt://"
+
+// defRFCOMMChannel is the default RFCOMM channel.
+const defRFCOMMChannel = 1
+
+func isRFCOMM(s string) bool { return strings.HasPrefix(s, rfcommScheme) }
+
+// rfcommAddr represents a Bluetooth device address and RFCOMM channel.
+type rfcommAddr struct {
+	mac     net.HardwareAddr
+	channel uint8
+}
+
+// parseRFCOMMAddr parses a connection string of format bt://<mac>[/<channel>].
+func parseRFCOMMAddr(s string) (*rfcommAddr, error) {
+	macStr, channelStr, ok := strings.Cut(strings.TrimPrefix(s, rfcommScheme), "/")
+	mac, err := net.ParseMAC(macStr)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid bluetooth address %s", macStr)
+	}
+	addr := &rfcommAddr{mac: mac, channel: defRFCOMMChannel}
+	if ok {
+		channel, err := strconv.ParseUint(channelStr, 10, 8)
+		if err != nil || channel < 1 || channel > 30 {
+			return nil, fmt.Errorf("invalid RFCOMM channel %s (range 1..30)", channelStr)
+		}
+		addr.channel = uint8(channel)
+	}
+	return addr, nil
+}
@@ -0,0 +1,30 @@
+package devices
+
+import (
+	"os"
+
+	"github.com/pico-cs/go-client/client"
+	"golang.org/x/sys/unix"
+)
+
+// newRFCOMMConn opens a Bluetooth serial (RFCOMM) connection.
+func newRFCOMMConn(addr *rfcommAddr) (client.Conn, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_STREAM, unix.BTPROTO_RFCOMM)
+	if err != nil {
+		return nil, err
+	}
+	sa := &unix.SockaddrRFCOMM{Channel: addr.channel}
+	for i, b := range addr.mac { // little-endian byte order
+		sa.Addr[len(sa.Addr)-1-i] = b
+	}
+	if err := unix.Connect(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// non-blocking mode registers the file with the runtime poller, so that Close interrupts a pending Read
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), rfcommScheme+addr.mac.String()), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package devices
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/pico-cs/go-client/client"
+)
+
+// newRFCOMMConn opens a Bluetooth serial (RFCOMM) connection.
+func newRFCOMMConn(addr *rfcommAddr) (client.Conn, error) {
+	return nil, fmt.Errorf("bluetooth serial connections are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,33 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestRFCOMMAddr(t *testing.T) {
+	tests := []struct {
+		s       string
+		channel uint8
+		ok      bool
+	}{
+		{"bt://00:11:22:33:44:55", defRFCOMMChannel, true},
+		{"bt://00:11:22:33:44:55/3", 3, true},
+		{"bt://00:11:22:33:44:55/0", 0, false},
+		{"bt://00:11:22:33:44:55/31", 0, false},
+		{"bt://00:11:22:33:44", 0, false},
+	}
+	for _, test := range tests {
+		addr, err := parseRFCOMMAddr(test.s)
+		if (err == nil) != test.ok {
+			t.Fatalf("parse %s: error %v - expected ok %t", test.s, err, test.ok)
+		}
+		if err == nil && addr.channel != test.channel {
+			t.Fatalf("parse %s: channel %d - expected %d", test.s, addr.channel, test.channel)
+		}
+		config := NewCSConfig()
+		config.Name, config.Port = "cs01", test.s
+		if err := config.Validate(); (err == nil) != test.ok {
+			t.Fatalf("validate port %s: error %v - expected ok %t", test.s, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package devices
+
+import (
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// maxShuntSpeed is the maximum speed in shunting mode.
+const maxShuntSpeed = maxSpeed / 2
+
+// shuntSpeed maps a requested speed to the effective speed. In shunting mode the speed range is
+// halved, so that the full throttle range is available for precise shunting.
+func (l *Loco) shuntSpeed(speed speed127) speed127 {
+	if !l.shunt.Load() {
+		return speed
+	}
+	return (speed + 1) / 2 // keep speed step 1
+}
+
+// shuntLimit limits a speed to the speed range of the shunting mode.
+func (l *Loco) shuntLimit(speed speed127) speed127 {
+	if l.shunt.Load() && speed > maxShuntSpeed {
+		return maxShuntSpeed
+	}
+	return speed
+}
+
+func (cs *CS) getLocoShunt(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return loco.shunt.Load(), nil
+	}
+}
+
+func (cs *CS) setLocoShunt(loco *Loco) gateway.HndFn {
+	return gateway.Typed(func(shunt bool) (any, error) {
+		loco.shunt.Store(shunt)
+		if shunt {
+			cs.slew.cancel(loco) // no momentum
+		}
+		return shunt, nil
+	})
+}
+
+func (cs *CS) toggleLocoShunt(loco *Loco) gateway.HndFn {
+	return func(payload any) (any, error) {
+		shunt := !loco.shunt.Load()
+		loco.shunt.Store(shunt)
+		if shunt {
+			cs.slew.cancel(loco) // no momentum
+		}
+		return shunt, nil
+	}
+}
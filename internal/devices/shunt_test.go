@@ -0,0 +1,44 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestShunt(t *testing.T) {
+	cs := &CS{}
+	cs.slew = newSlewLimiter(cs, 0)
+	loco := &Loco{config: &LocoConfig{Name: "br01"}}
+
+	if speed := loco.shuntSpeed(100); speed != 100 {
+		t.Fatalf("speed %d - expected 100 without shunting mode", speed)
+	}
+	if shunt, err := cs.setLocoShunt(loco)(json.RawMessage("true")); err != nil || shunt != true {
+		t.Fatalf("set shunting mode: %v %v", shunt, err)
+	}
+	if shunt, _ := cs.getLocoShunt(loco)(nil); shunt != true {
+		t.Fatal("shunting mode not set")
+	}
+
+	tests := []struct {
+		speed, shuntSpeed, limit speed127
+	}{
+		{0, 0, 0},
+		{1, 1, 1}, // keep speed step 1
+		{2, 1, 2},
+		{100, 50, maxShuntSpeed},
+		{maxSpeed, maxShuntSpeed, maxShuntSpeed},
+	}
+	for _, test := range tests {
+		if speed := loco.shuntSpeed(test.speed); speed != test.shuntSpeed {
+			t.Fatalf("shunt speed %d: %d - expected %d", test.speed, speed, test.shuntSpeed)
+		}
+		if speed := loco.shuntLimit(test.speed); speed != test.limit {
+			t.Fatalf("shunt limit %d: %d - expected %d", test.speed, speed, test.limit)
+		}
+	}
+
+	if shunt, _ := cs.toggleLocoShunt(loco)(nil); shunt != false {
+		t.Fatal("shunting mode not toggled")
+	}
+}
@@ -0,0 +1,156 @@
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// CSSignalConfig represents configuration data for a light signal driven by command station output IOs.
+type CSSignalConfig struct {
+	// output IOs switched on by aspect (e.g. green: [sig1green]) - all other IOs of the signal are switched off
+	Aspects map[string][]string `json:"aspects"`
+	// aspect set on command station start (empty: none)
+	Initial string `json:"initial"`
+}
+
+func (c *CSSignalConfig) validate(ios map[string]CSIOConfig) error {
+	if len(c.Aspects) == 0 {
+		return fmt.Errorf("aspects missing")
+	}
+	for aspect, names := range c.Aspects {
+		if err := gateway.CheckLevelName(aspect); err != nil {
+			return fmt.Errorf("aspect %s: %s", aspect, err)
+		}
+		for _, name := range names {
+			io, ok := ios[name]
+			if !ok {
+				return fmt.Errorf("aspect %s: io %s not found", aspect, name)
+			}
+			if !io.Out {
+				return fmt.Errorf("aspect %s: io %s is no output IO", aspect, name)
+			}
+		}
+	}
+	if _, ok := c.Aspects[c.Initial]; c.Initial != "" && !ok {
+		return fmt.Errorf("initial aspect %s not found", c.Initial)
+	}
+	return nil
+}
+
+// validateSignals validates the signal configuration.
+func (c *CSConfig) validateSignals() error {
+	for name, signal := range c.Signals {
+		if err := gateway.CheckLevelName(name); err != nil {
+			return fmt.Errorf("signal %s: %s", name, err)
+		}
+		if err := signal.validate(c.IOs); err != nil {
+			return fmt.Errorf("signal %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// ioSignal represents the state of a signal.
+type ioSignal struct {
+	cs      *CS
+	aspects map[string][]uint // (virtual) GPIOs switched on by aspect
+	gpios   []uint            // all (virtual) GPIOs of the signal
+
+	mu     sync.Mutex
+	aspect string // active aspect (empty: not set yet)
+}
+
+func newIOSignal(cs *CS, config CSSignalConfig) *ioSignal {
+	s := &ioSignal{cs: cs, aspects: map[string][]uint{}}
+	gpios := map[uint]bool{}
+	for aspect, names := range config.Aspects {
+		for _, name := range names {
+			gpio := cs.config.IOs[name].GPIO
+			s.aspects[aspect] = append(s.aspects[aspect], gpio)
+			gpios[gpio] = true
+		}
+	}
+	for gpio := range gpios {
+		s.gpios = append(s.gpios, gpio)
+	}
+	sort.Slice(s.gpios, func(i, j int) bool { return s.gpios[i] < s.gpios[j] })
+	return s
+}
+
+func (s *ioSignal) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aspect
+}
+
+// set switches the signal to an aspect - the IOs of the previous aspect are switched off first,
+// so that two aspects are never shown at the same time.
+func (s *ioSignal) set(aspect string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	on, ok := s.aspects[aspect]
+	if !ok {
+		return "", fmt.Errorf("invalid aspect %s", aspect)
+	}
+	isOn := func(gpio uint) bool {
+		for _, g := range on {
+			if g == gpio {
+				return true
+			}
+		}
+		return false
+	}
+	for _, gpio := range s.gpios {
+		if !isOn(gpio) {
+			if _, err := s.cs.client.SetIOVal(ioCmdGPIO, gpio, false); err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, gpio := range on {
+		if _, err := s.cs.client.SetIOVal(ioCmdGPIO, gpio, true); err != nil {
+			return "", err
+		}
+	}
+	s.aspect = aspect
+	return aspect, nil
+}
+
+func (cs *CS) getSignalAspect(s *ioSignal) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return s.get(), nil
+	}
+}
+
+func (cs *CS) setSignalAspect(s *ioSignal) gateway.HndFn {
+	return gateway.Typed(func(aspect string) (any, error) {
+		return s.set(aspect)
+	})
+}
+
+// subscribeSignals subscribes to the aspect topics of the signals and sets the initial aspects.
+func (cs *CS) subscribeSignals() {
+	for name, config := range cs.config.Signals {
+		s := newIOSignal(cs, config)
+		if config.Initial != "" {
+			if aspect, err := s.set(config.Initial); err != nil {
+				cs.lg.Printf("command station %s: signal %s: %s", cs.name(), name, err)
+			} else {
+				cs.gw.Publish([]string{"signal", name, "aspect"}, true, aspect)
+			}
+		}
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"signal", name, "aspect", "get"}, cs.getSignalAspect(s))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"signal", name, "aspect", "set"}, cs.setSignalAspect(s))
+	}
+}
+
+func (cs *CS) unsubscribeSignals() {
+	for name := range cs.config.Signals {
+		cs.gw.Unsubscribe(cs, []string{"signal", name, "aspect", "get"})
+		cs.gw.Unsubscribe(cs, []string{"signal", name, "aspect", "set"})
+	}
+}
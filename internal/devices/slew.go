@@ -0,0 +1,146 @@
+package devices
+
+import (
+	"sync"
+	"time"
+)
+
+// A slewLimiter limits the speed change rate of the primary locos of a command station.
+// Speed changes exceeding the rate (or the acceleration and deceleration rates of the loco)
+// are executed stepwise in the background.
+type slewLimiter struct {
+	cs      *CS
+	rate    uint // default speed steps per second (0: unlimited)
+	mu      sync.Mutex
+	targets map[*Loco]speed127 // target speed of active slews
+	brakes  map[*Loco]uint     // rate of active brakes
+}
+
+func newSlewLimiter(cs *CS, rate uint) *slewLimiter {
+	return &slewLimiter{cs: cs, rate: rate, targets: map[*Loco]speed127{}, brakes: map[*Loco]uint{}}
+}
+
+// step returns the maximum speed change per ramp interval.
+func step(rate uint) int {
+	step := int(time.Duration(rate) * rampInterval / time.Second)
+	if step < 1 {
+		return 1
+	}
+	return step
+}
+
+// locoRate returns the rate of the loco changing the speed towards target (l.mu needs to be locked).
+func (l *slewLimiter) locoRate(loco *Loco, speed, target speed127) uint {
+	if rate, ok := l.brakes[loco]; ok {
+		return rate
+	}
+	return loco.config.momentum(speed, target, l.rate)
+}
+
+// next returns the next speed from speed towards target and if the target is reached.
+func next(speed, target speed127, rate uint) (speed127, bool) {
+	if rate == 0 { // unlimited
+		return target, true
+	}
+	step := step(rate)
+	switch delta := int(target) - int(speed); {
+	case delta > step:
+		return speed.add(step), false
+	case delta < -step:
+		return speed.add(-step), false
+	default:
+		return target, true
+	}
+}
+
+// set sets the loco speed towards target and returns the current speed. In case the speed change
+// exceeds the rate the remaining steps are executed in the background.
+func (l *slewLimiter) set(loco *Loco, target speed127) (speed127, error) {
+	rate := loco.config.momentum(speed127(loco.State().Speed), target, l.rate)
+	if rate == 0 || loco.shunt.Load() { // no momentum in shunting mode
+		l.cancel(loco) // cancel active brake
+		return l.cs.setSpeed(loco, target)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.brakes, loco) // speed command ends braking
+	speed := speed127(loco.State().Speed)
+	if _, ok := l.targets[loco]; ok { // slew active - update target only
+		l.targets[loco] = target
+		return speed, nil
+	}
+	next, done := next(speed, target, l.locoRate(loco, speed, target))
+	speed, err := l.cs.setSpeed(loco, next)
+	if err != nil {
+		return 0, err
+	}
+	if !done {
+		l.targets[loco] = target
+		go l.slew(loco)
+	}
+	return speed, nil
+}
+
+func (l *slewLimiter) slew(loco *Loco) {
+	for {
+		time.Sleep(rampInterval)
+
+		l.mu.Lock()
+		target, ok := l.targets[loco]
+		if !ok { // cancelled
+			l.mu.Unlock()
+			return
+		}
+		speed := speed127(loco.State().Speed)
+		next, done := next(speed, target, l.locoRate(loco, speed, target))
+		if err := l.cs.publishLocoSpeed(loco, next.speed128()); err != nil {
+			l.cs.lg.Printf("slew loco %s: %s", loco.name(), err)
+			done = true
+		}
+		if done {
+			delete(l.targets, loco)
+			delete(l.brakes, loco)
+		}
+		l.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// cancel cancels an active slew of the loco.
+func (l *slewLimiter) cancel(loco *Loco) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.targets, loco)
+	delete(l.brakes, loco)
+}
+
+// brake decelerates the loco to zero with rate (speed steps per second) and returns the current speed.
+// The remaining steps are executed in the background publishing the intermediate speeds.
+func (l *slewLimiter) brake(loco *Loco, rate uint) (speed127, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.brakes[loco] = rate
+	speed := speed127(loco.State().Speed)
+	if _, ok := l.targets[loco]; ok { // slew active - update target only
+		l.targets[loco] = 0
+		return speed, nil
+	}
+	next, done := next(speed, 0, rate)
+	speed, err := l.cs.setSpeed(loco, next)
+	if err != nil {
+		delete(l.brakes, loco)
+		return 0, err
+	}
+	if done {
+		delete(l.brakes, loco)
+	} else {
+		l.targets[loco] = 0
+		go l.slew(loco)
+	}
+	return speed, nil
+}
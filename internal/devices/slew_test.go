@@ -0,0 +1,48 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestSlewNext(t *testing.T) {
+	tests := []struct {
+		speed, target speed127
+		rate          uint
+		next          speed127
+		done          bool
+	}{
+		{0, 100, 0, 100, true}, // unlimited
+		{0, 100, 100, 10, false},
+		{95, 100, 100, 100, true},
+		{100, 0, 100, 90, false},
+		{5, 0, 100, 0, true},
+		{0, 100, 5, 1, false}, // minimal step
+		{42, 42, 100, 42, true},
+	}
+	for _, test := range tests {
+		next, done := next(test.speed, test.target, test.rate)
+		if next != test.next || done != test.done {
+			t.Fatalf("next speed %d target %d rate %d: %d %t - expected %d %t", test.speed, test.target, test.rate, next, done, test.next, test.done)
+		}
+	}
+}
+
+func TestMomentum(t *testing.T) {
+	config := &LocoConfig{Accel: 20, Decel: 50}
+	tests := []struct {
+		config        *LocoConfig
+		speed, target speed127
+		rate          uint
+	}{
+		{config, 0, 100, 20},         // acceleration
+		{config, 100, 0, 50},         // deceleration
+		{config, 42, 42, 100},        // no speed change
+		{&LocoConfig{}, 0, 100, 100}, // default rate
+		{&LocoConfig{Decel: 50}, 0, 100, 100},
+	}
+	for _, test := range tests {
+		if rate := test.config.momentum(test.speed, test.target, 100); rate != test.rate {
+			t.Fatalf("momentum accel %d decel %d speed %d target %d: rate %d - expected %d", test.config.Accel, test.config.Decel, test.speed, test.target, rate, test.rate)
+		}
+	}
+}
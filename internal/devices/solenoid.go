@@ -0,0 +1,164 @@
+package devices
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Solenoid output modes.
+const (
+	SolenoidPaired = ""       // paired coils (closed coil on the IO GPIO, thrown coil on the thrown GPIO)
+	SolenoidSingle = "single" // single coil (e.g. impulse relay) switching the position on each pulse
+)
+
+// Solenoid timing defaults and limits.
+const (
+	DefaultSolenoidPulse   = 250  // ms
+	DefaultSolenoidLockout = 1000 // ms
+	maxSolenoidPulse       = 1000 // ms
+	maxSolenoidLockout     = 60000
+)
+
+// CSSolenoidConfig represents configuration data for a solenoid turnout drive connected to command station outputs.
+type CSSolenoidConfig struct {
+	// output mode (empty: paired coils, single: single coil)
+	Mode string `json:"mode"`
+	// GPIO of the thrown coil (paired coils only)
+	ThrownGPIO uint `json:"thrownGPIO" yaml:"thrownGPIO"`
+	// duration in milliseconds a coil is energized (default 250, max 1000)
+	Pulse uint `json:"pulse"`
+	// minimum duration in milliseconds between the end of a pulse and the next pulse (default 1000)
+	Lockout uint `json:"lockout"`
+}
+
+func (c *CSSolenoidConfig) pulse() time.Duration {
+	if c.Pulse == 0 {
+		return DefaultSolenoidPulse * time.Millisecond
+	}
+	return time.Duration(c.Pulse) * time.Millisecond
+}
+
+func (c *CSSolenoidConfig) lockout() time.Duration {
+	if c.Lockout == 0 {
+		return DefaultSolenoidLockout * time.Millisecond
+	}
+	return time.Duration(c.Lockout) * time.Millisecond
+}
+
+func (c *CSSolenoidConfig) validate(gpio uint) error {
+	switch c.Mode {
+	case SolenoidPaired:
+		if c.ThrownGPIO == gpio {
+			return fmt.Errorf("solenoid closed and thrown coil gpio need to differ")
+		}
+		if c.ThrownGPIO >= numGPIO {
+			return fmt.Errorf("invalid solenoid thrown gpio %d (range 0..%d)", c.ThrownGPIO, numGPIO-1)
+		}
+	case SolenoidSingle:
+	default:
+		return fmt.Errorf("invalid solenoid mode %s (empty or %s)", c.Mode, SolenoidSingle)
+	}
+	if c.Pulse > maxSolenoidPulse {
+		return fmt.Errorf("invalid solenoid pulse %dms (range 0..%dms)", c.Pulse, maxSolenoidPulse)
+	}
+	if c.Lockout > maxSolenoidLockout {
+		return fmt.Errorf("invalid solenoid lockout %dms (range 0..%dms)", c.Lockout, maxSolenoidLockout)
+	}
+	return nil
+}
+
+// A solenoid drives the coils of a solenoid turnout. Coils are only energized for the configured
+// pulse duration and further pulses are rejected during the lockout time to prevent overheating.
+type solenoid struct {
+	cs     *CS
+	config CSSolenoidConfig
+	closed uint // GPIO of the closed coil (single coil in single mode)
+	thrown uint // GPIO of the thrown coil
+
+	mu   sync.Mutex
+	next time.Time // earliest time of the next pulse
+}
+
+func (cs *CS) newSolenoid(io CSIOConfig) *solenoid {
+	return &solenoid{
+		cs:     cs,
+		config: io.Solenoid,
+		closed: io.GPIO,
+		thrown: io.Solenoid.ThrownGPIO,
+	}
+}
+
+// move pulses the coil moving the turnout from the current to the new position.
+func (s *solenoid) move(current, thrown bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.Mode == SolenoidSingle && current == thrown {
+		return nil // each pulse switches the position
+	}
+
+	now := time.Now()
+	if now.Before(s.next) {
+		return fmt.Errorf("solenoid locked out for %s", s.next.Sub(now).Round(time.Millisecond))
+	}
+
+	gpio := s.closed
+	if thrown && s.config.Mode == SolenoidPaired {
+		gpio = s.thrown
+	}
+	if _, err := s.cs.client.SetIOVal(ioCmdGPIO, gpio, true); err != nil {
+		s.cs.client.SetIOVal(ioCmdGPIO, gpio, false) // make sure the coil is not energized
+		return err
+	}
+	pulse := s.config.pulse()
+	s.next = now.Add(pulse + s.config.lockout())
+	s.cs.pulses.start(pulse, func() {
+		if _, err := s.cs.client.SetIOVal(ioCmdGPIO, gpio, false); err != nil {
+			s.cs.lg.Printf("command station %s: solenoid coil %d: %s", s.cs.name(), gpio, err)
+		}
+	})
+	return nil
+}
+
+// coilPulses tracks the pending coil pulses of the solenoids of a command station, so that
+// the coils can be switched off before the command station is closed.
+type coilPulses struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	timers map[*time.Timer]func() // coil off functions by pending pulse timer
+}
+
+func newCoilPulses() *coilPulses {
+	return &coilPulses{timers: map[*time.Timer]func(){}}
+}
+
+// start calls off after the pulse duration d.
+func (p *coilPulses) start(d time.Duration, off func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wg.Add(1)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		defer p.wg.Done()
+		p.mu.Lock()
+		delete(p.timers, t)
+		p.mu.Unlock()
+		off()
+	})
+	p.timers[t] = off
+}
+
+// stop ends all pending pulses immediately switching the coils off and waits until all coils are switched off.
+func (p *coilPulses) stop() {
+	p.mu.Lock()
+	for t, off := range p.timers {
+		if t.Stop() {
+			off()
+			p.wg.Done()
+		}
+		delete(p.timers, t)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,176 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+	"golang.org/x/exp/maps"
+)
+
+// statsWindow is the number of most recent command durations the percentiles are calculated of.
+const statsWindow = 1000
+
+// durations is a ring buffer of command durations.
+type durations struct {
+	buf   []time.Duration
+	next  int
+	count uint64
+	sum   time.Duration
+}
+
+func (d *durations) add(v time.Duration) {
+	if len(d.buf) < statsWindow {
+		d.buf = append(d.buf, v)
+	} else {
+		d.buf[d.next] = v
+		d.next = (d.next + 1) % statsWindow
+	}
+	d.count++
+	d.sum += v
+}
+
+// CommandStats represents the execution duration statistics of a command (durations in milliseconds).
+type CommandStats struct {
+	// number of executed commands
+	Count uint64 `json:"count"`
+	// total duration of the executed commands
+	Sum float64 `json:"sum"`
+	// 50th percentile
+	P50 float64 `json:"p50"`
+	// 90th percentile
+	P90 float64 `json:"p90"`
+	// 99th percentile
+	P99 float64 `json:"p99"`
+	// maximum
+	Max float64 `json:"max"`
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+func (d *durations) stats() *CommandStats {
+	sorted := make([]time.Duration, len(d.buf))
+	copy(sorted, d.buf)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) float64 { return ms(sorted[(len(sorted)-1)*p/100]) }
+	return &CommandStats{Count: d.count, Sum: ms(d.sum), P50: percentile(50), P90: percentile(90), P99: percentile(99), Max: ms(sorted[len(sorted)-1])}
+}
+
+// cmdStats records the execution durations of the commands of a command station.
+type cmdStats struct {
+	mu sync.Mutex
+	m  map[string]*durations
+}
+
+func newCmdStats() *cmdStats { return &cmdStats{m: map[string]*durations{}} }
+
+func (s *cmdStats) record(cmd string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.m[cmd]
+	if !ok {
+		ds = &durations{}
+		s.m[cmd] = ds
+	}
+	ds.add(d)
+}
+
+func (s *cmdStats) snapshot() map[string]*CommandStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]*CommandStats, len(s.m))
+	for cmd, ds := range s.m {
+		stats[cmd] = ds.stats()
+	}
+	return stats
+}
+
+// cmdName returns the statistics command name of a command topic (without topic root).
+// Loco function names are reduced to 'fct' and function groups to 'fg'.
+func cmdName(topicStrs []string) string {
+	if len(topicStrs) > 0 && topicStrs[0] == gateway.ClassProto {
+		return gateway.ClassProto + "/" + cmdName(topicStrs[1:])
+	}
+	if len(topicStrs) < 3 {
+		return strings.Join(topicStrs, "/")
+	}
+	kind, rest := topicStrs[0], topicStrs[2:]
+	if kind == "loco" {
+		switch prop := rest[0]; {
+		case prop == "dir" || prop == "speed" || prop == "velocity" || prop == "drive":
+		case strings.HasPrefix(prop, "fg"):
+			rest = append([]string{"fg"}, rest[1:]...)
+		default:
+			rest = append([]string{"fct"}, rest[1:]...)
+		}
+	}
+	return kind + "/" + strings.Join(rest, "/")
+}
+
+// statsPublisher publishes the command statistics periodically.
+func (cs *CS) statsPublisher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cs.done:
+			return
+		case <-ticker.C:
+			cs.gw.PublishTelemetry([]string{"cs", cs.name(), "stats"}, true, cs.Stats())
+		}
+	}
+}
+
+// Stats returns the command execution duration statistics (key: command name).
+func (cs *CS) Stats() map[string]*CommandStats { return cs.stats.snapshot() }
+
+func (cs *CS) serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	b, err := json.MarshalIndent(cs.Stats(), "", indent)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// ServeMetrics provides the command execution duration statistics of all command stations
+// in the Prometheus text exposition format.
+func (s *CSSet) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeMetrics(w)
+}
+
+func (s *CSSet) writeMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP pico_cs_command_duration_seconds Command station command execution duration.")
+	fmt.Fprintln(w, "# TYPE pico_cs_command_duration_seconds summary")
+
+	csMap := s.Items()
+	csNames := maps.Keys(csMap)
+	sort.Strings(csNames)
+	for _, csName := range csNames {
+		stats := csMap[csName].Stats()
+		cmds := maps.Keys(stats)
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			st := stats[cmd]
+			labels := fmt.Sprintf("cs=%q,command=%q", csName, cmd)
+			for _, q := range []struct {
+				quantile string
+				value    float64
+			}{{"0.5", st.P50}, {"0.9", st.P90}, {"0.99", st.P99}} {
+				fmt.Fprintf(w, "pico_cs_command_duration_seconds{%s,quantile=%q} %g\n", labels, q.quantile, q.value/1000)
+			}
+			fmt.Fprintf(w, "pico_cs_command_duration_seconds_sum{%s} %g\n", labels, st.Sum/1000)
+			fmt.Fprintf(w, "pico_cs_command_duration_seconds_count{%s} %d\n", labels, st.Count)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package devices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurations(t *testing.T) {
+	d := &durations{}
+	for i := 1; i <= statsWindow+100; i++ {
+		d.add(time.Duration(i) * time.Millisecond)
+	}
+	stats := d.stats()
+	n := statsWindow + 100
+	if stats.Count != uint64(n) {
+		t.Fatalf("count %d - expected %d", stats.Count, n)
+	}
+	if sum := float64(n*(n+1)) / 2; stats.Sum != sum { // sum over all commands
+		t.Fatalf("sum %f - expected %f", stats.Sum, sum)
+	}
+	if stats.P50 != 600 || stats.Max != float64(n) { // percentiles of the last statsWindow durations
+		t.Fatalf("p50 %f max %f - expected 600 %d", stats.P50, stats.Max, n)
+	}
+}
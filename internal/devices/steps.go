@@ -0,0 +1,89 @@
+package devices
+
+import (
+	"fmt"
+	"math"
+)
+
+// Speed step modes.
+const (
+	SpeedSteps14  = 14
+	SpeedSteps28  = 28
+	SpeedSteps128 = 128
+)
+
+func (c *LocoConfig) validateSpeedSteps() error {
+	switch c.SpeedSteps {
+	case 0, SpeedSteps14, SpeedSteps28, SpeedSteps128:
+		return nil
+	default:
+		return fmt.Errorf("invalid speed steps %d (%d, %d or %d)", c.SpeedSteps, SpeedSteps14, SpeedSteps28, SpeedSteps128)
+	}
+}
+
+// maxStep returns the maximum speed step of the speed step mode.
+func (c *LocoConfig) maxStep() uint {
+	switch c.SpeedSteps {
+	case SpeedSteps14, SpeedSteps28:
+		return c.SpeedSteps
+	default:
+		return maxSpeed
+	}
+}
+
+// stepSpeed converts a speed value in the speed step range of the loco to the 126 speed steps
+// used by the command station.
+func (c *LocoConfig) stepSpeed(f64 float64) speed127 {
+	max := c.maxStep()
+	if max == maxSpeed {
+		return speed127(f64)
+	}
+	if f64 > float64(max) {
+		f64 = float64(max)
+	}
+	return speed127(math.Round(f64 * maxSpeed / float64(max)))
+}
+
+// speedStep converts a command station speed to the speed step range of the loco.
+func (c *LocoConfig) speedStep(speed speed127) uint {
+	max := c.maxStep()
+	if max == maxSpeed {
+		return uint(speed)
+	}
+	return uint(math.Round(float64(speed) * float64(max) / maxSpeed))
+}
+
+// addSteps adds delta speed steps of the loco speed step range to speed.
+func (c *LocoConfig) addSteps(speed speed127, delta int) speed127 {
+	max := c.maxStep()
+	if max == maxSpeed {
+		return speed.add(delta)
+	}
+	step := int(c.speedStep(speed)) + delta
+	switch {
+	case step < 0:
+		step = 0
+	case step > int(max):
+		step = int(max)
+	}
+	return c.stepSpeed(float64(step))
+}
+
+// driveState returns a copy of the loco state with the speed converted to the speed step range of the loco
+// (drive state events and commands).
+func (c *LocoConfig) driveState(state *LocoState) *LocoState {
+	state = state.clone()
+	state.Speed = c.speedStep(speed127(state.Speed))
+	return state
+}
+
+// driveState returns the drive state of the loco in the speed step range of the loco.
+func (l *Loco) driveState() *LocoState { return l.config.driveState(l.State()) }
+
+// speedEvent returns the speed event value of a command station speed.
+func (l *Loco) speedEvent(speed speed127, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return l.config.speedStep(speed), nil
+}
@@ -1,8 +1,12 @@
 package devices
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
+	"net/http"
+
+	"github.com/pico-cs/mqtt-gateway/internal/server"
 )
 
 const idxHTML = `
@@ -13,8 +17,8 @@ const idxHTML = `
 		<title>locos</title>
 	</head>
 	<body>
-		<div><a href='/cs'>comand stations</a></div>
-		<div><a href='/loco'>locos</a></div>
+		<div><a href='{{ .BasePath }}/cs'>comand stations</a></div>
+		<div><a href='{{ .BasePath }}/loco'>locos</a></div>
 	</body>
 </html>`
 
@@ -28,18 +32,18 @@ const csIdxHTML = `
 	<body>
 		<ul>
 		{{range $k, $v := .CSMap -}}
-			<li><div><a href='/cs/{{ $k }}'>{{ $k }}</a></div></li>
+			<li><div><a href='{{ $.BasePath }}/cs/{{ $k }}'>{{ $k }}</a> ({{if $v.Available}}available{{else}}<b>not available</b>{{end}})</div></li>
 			<ul>
 				<li>primary locos</li>
 					<ul>
 					{{range $k1, $v1 := $v.Primaries -}}
-						<li><div><a href='/loco/{{ $k1 }}'>{{ $k1 }}</a></div></li>
+						<li><div><a href='{{ $.BasePath }}/loco/{{ $k1 }}'>{{ $k1 }}</a></div></li>
 					{{end -}}
 					</ul>
 				<li>secondary locos</li>
 					<ul>
 					{{range $k1, $v1 := $v.Secondaries -}}
-						<li><div><a href='/loco/{{ $k1 }}'>{{ $k1 }}</a></div></li>
+						<li><div><a href='{{ $.BasePath }}/loco/{{ $k1 }}'>{{ $k1 }}</a></div></li>
 					{{end -}}
 					</ul>
 			</ul>
@@ -58,36 +62,136 @@ const locoIdxHTML = `
 	<body>
 		<ul>
 		{{range $k, $v := .LocoMap -}}
-			<li><div><a href='/loco/{{ $k }}'>{{ $k }}</a></div></li>
+			<li><div><a href='{{ $.BasePath }}/loco/{{ $k }}'>{{ $k }}</a> (<a href='{{ $.BasePath }}/throttle/{{ $k }}'>throttle</a>, <a href='{{ $.BasePath }}/loco/{{ $k }}/qr.png'>QR code</a>)</div></li>
 		{{end -}}
 		</ul>
 	</body>
 </html>`
 
+const throttleHTML = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>{{ .Name }}</title>
+		<style>
+			body { font-family: sans-serif; margin: 0; padding: 1em; max-width: 40em; margin: auto; }
+			h1 { text-align: center; }
+			button { font-size: 1.5em; padding: 0.6em; margin: 0.2em 0; width: 100%; border-radius: 0.3em; }
+			button.on { background-color: #4caf50; color: white; }
+			#stop { background-color: #f44336; color: white; }
+			#speed { width: 100%; height: 3em; }
+			#speedValue { font-size: 3em; text-align: center; }
+			.fcts { display: grid; grid-template-columns: 1fr 1fr; gap: 0.4em; }
+			#error { color: #f44336; text-align: center; }
+		</style>
+	</head>
+	<body>
+		<h1>{{ .Name }}</h1>
+		<div id="speedValue">0</div>
+		<input id="speed" type="range" min="0" max="126" value="0">
+		<button id="dir">forward</button>
+		<button id="stop">stop</button>
+		<div class="fcts">
+		{{range .Fcts -}}
+			<button class="fct" data-fct="{{ . }}">{{ . }}</button>
+		{{end -}}
+		</div>
+		<div id="error"></div>
+		<script>
+			const name = {{ .Name }};
+			const base = {{ .BasePath }} + "/loco/" + encodeURIComponent(name);
+			let state = {dir: true, speed: 0, fcts: {}};
+
+			function render() {
+				document.getElementById("speedValue").textContent = state.speed;
+				document.getElementById("speed").value = state.speed;
+				document.getElementById("dir").textContent = state.dir ? "forward" : "backward";
+				document.querySelectorAll(".fct").forEach(b => b.classList.toggle("on", !!state.fcts[b.dataset.fct]));
+			}
+
+			function drive(value) {
+				fetch(base + "/drive", {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(value)})
+					.then(r => { if (!r.ok) { return r.text().then(t => { throw new Error(t); }); } document.getElementById("error").textContent = ""; })
+					.catch(e => document.getElementById("error").textContent = e.message);
+			}
+
+			function poll(wait) {
+				fetch(base + "/state" + (wait ? "?wait=30s" : ""))
+					.then(r => r.json())
+					.then(s => { state = s; render(); poll(true); })
+					.catch(e => setTimeout(() => poll(true), 1000));
+			}
+
+			document.getElementById("speed").addEventListener("input", e => drive({speed: Number(e.target.value)}));
+			document.getElementById("dir").addEventListener("click", () => drive({dir: !state.dir}));
+			document.getElementById("stop").addEventListener("click", () => drive({speed: 0}));
+			document.querySelectorAll(".fct").forEach(b => b.addEventListener("click", () => drive({fcts: {[b.dataset.fct]: !state.fcts[b.dataset.fct]}})));
+
+			poll(false);
+		</script>
+	</body>
+</html>`
+
 var (
-	csIdxTpl   *template.Template
-	locoIdxTpl *template.Template
+	idxTpl      *template.Template
+	csIdxTpl    *template.Template
+	locoIdxTpl  *template.Template
+	throttleTpl *template.Template
 )
 
+// tplData provides the base path of the HTTP server to the templates (see server.BasePath).
+type tplData struct {
+	BasePath string
+}
+
 type csTpl struct {
+	Available   bool
 	Primaries   map[string]*Loco
 	Secondaries map[string]*Loco
 }
 
 type csTplData struct {
+	tplData
 	CSMap map[string]csTpl
 }
 
 type locoTplData struct {
+	tplData
 	LocoMap map[string]*Loco
 }
 
+type throttleTplData struct {
+	tplData
+	Name string
+	Fcts []string
+}
+
 func init() {
 	var err error
+	if idxTpl, err = template.New("idxPage").Parse(idxHTML); err != nil {
+		panic(fmt.Sprintf("template parse error %s", err))
+	}
 	if csIdxTpl, err = template.New("csPage").Parse(csIdxHTML); err != nil {
 		panic(fmt.Sprintf("template parse error %s", err))
 	}
 	if locoIdxTpl, err = template.New("locoPage").Parse(locoIdxHTML); err != nil {
 		panic(fmt.Sprintf("template parse error %s", err))
 	}
+	if throttleTpl, err = template.New("throttlePage").Parse(throttleHTML); err != nil {
+		panic(fmt.Sprintf("template parse error %s", err))
+	}
+}
+
+// executeTemplate renders the template into a buffer before writing the page, so that a template
+// error is replied as error response instead of a partially written page.
+func executeTemplate(w http.ResponseWriter, tpl *template.Template, data any) {
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, data); err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
 }
@@ -0,0 +1,91 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+)
+
+// IO types.
+const (
+	IOTypeGPIO     = ""         // digital GPIO
+	IOTypeSolenoid = "solenoid" // solenoid turnout drive (pulsed coils)
+)
+
+// Turnout positions.
+const (
+	TurnoutClosed = "closed"
+	TurnoutThrown = "thrown"
+)
+
+func turnoutPosition(thrown bool) string {
+	if thrown {
+		return TurnoutThrown
+	}
+	return TurnoutClosed
+}
+
+func (cs *CS) getTurnout(name string) gateway.HndFn {
+	return func(payload any) (any, error) {
+		return turnoutPosition(cs.turnouts[name]), nil
+	}
+}
+
+// turnoutMoveFn moves a turnout from the current to the new position.
+type turnoutMoveFn func(current, thrown bool) error
+
+func (cs *CS) setTurnout(name string, move turnoutMoveFn) gateway.HndFn {
+	return gateway.Typed(func(position string) (any, error) {
+		var thrown bool
+		switch position {
+		case TurnoutClosed:
+		case TurnoutThrown:
+			thrown = true
+		default:
+			return nil, fmt.Errorf("invalid turnout position %s (%s or %s)", position, TurnoutClosed, TurnoutThrown)
+		}
+		if err := move(cs.turnouts[name], thrown); err != nil {
+			return nil, err
+		}
+		cs.turnouts[name] = thrown
+		return turnoutPosition(thrown), nil
+	})
+}
+
+func (cs *CS) toggleTurnout(name string, move turnoutMoveFn) gateway.HndFn {
+	return func(payload any) (any, error) {
+		thrown := !cs.turnouts[name]
+		if err := move(!thrown, thrown); err != nil {
+			return nil, err
+		}
+		cs.turnouts[name] = thrown
+		return turnoutPosition(thrown), nil
+	}
+}
+
+// isTurnout returns true if the IO is a turnout drive.
+func (io CSIOConfig) isTurnout() bool { return io.Type == IOTypeSolenoid }
+
+// subscribeTurnouts subscribes to the turnout topics of the solenoid IOs.
+func (cs *CS) subscribeTurnouts() {
+	for name, io := range cs.config.IOs {
+		if !io.isTurnout() {
+			continue
+		}
+		move := cs.newSolenoid(io).move
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "turnout", "get"}, cs.getTurnout(name))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "turnout", "set"}, cs.setTurnout(name, move))
+		cs.gw.Subscribe(cs.hndCh, cs, []string{"cs", cs.name(), name, "turnout", "toggle"}, cs.toggleTurnout(name, move))
+	}
+}
+
+func (cs *CS) unsubscribeTurnouts() {
+	for name, io := range cs.config.IOs {
+		if !io.isTurnout() {
+			continue
+		}
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "turnout", "get"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "turnout", "set"})
+		cs.gw.Unsubscribe(cs, []string{"cs", cs.name(), name, "turnout", "toggle"})
+	}
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"sort"
+	"time"
+)
+
+const classAlert = "alert"
+
+// Alert severities.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert states.
+const (
+	AlertRaised  = "raised"
+	AlertCleared = "cleared"
+)
+
+// An Alert represents a condition requiring attention (e.g. a command station being offline).
+type Alert struct {
+	// alert id (e.g. cs/<command station name>/offline)
+	ID string `json:"id"`
+	// severity (info, warning or critical)
+	Severity string `json:"severity"`
+	// state (raised or cleared)
+	State string `json:"state"`
+	// alert description
+	Text string `json:"text"`
+	// time of the state change
+	Time time.Time `json:"time"`
+}
+
+// RaiseAlert raises an alert and publishes it to the alert topic. Raising an already raised alert
+// is only published if severity or text changed.
+func (gw *Gateway) RaiseAlert(id, severity, text string) {
+	gw.alertMu.Lock()
+	defer gw.alertMu.Unlock()
+	if alert, ok := gw.alerts[id]; ok && alert.Severity == severity && alert.Text == text {
+		return
+	}
+	if gw.alerts == nil {
+		gw.alerts = map[string]*Alert{}
+	}
+	alert := &Alert{ID: id, Severity: severity, State: AlertRaised, Text: text, Time: time.Now()}
+	gw.alerts[id] = alert
+	gw.lg.Printf("alert %s raised: %s", id, text)
+	gw.Publish([]string{classAlert}, false, alert)
+}
+
+// ClearAlert clears a raised alert and publishes the cleared alert to the alert topic.
+// Clearing an alert which is not raised is a no-op.
+func (gw *Gateway) ClearAlert(id string) {
+	gw.alertMu.Lock()
+	defer gw.alertMu.Unlock()
+	alert, ok := gw.alerts[id]
+	if !ok {
+		return
+	}
+	delete(gw.alerts, id)
+	cleared := *alert
+	cleared.State = AlertCleared
+	cleared.Time = time.Now()
+	gw.lg.Printf("alert %s cleared", id)
+	gw.Publish([]string{classAlert}, false, &cleared)
+}
+
+// Alerts returns the raised alerts sorted by id.
+func (gw *Gateway) Alerts() []*Alert {
+	gw.alertMu.Lock()
+	defer gw.alertMu.Unlock()
+	alerts := make([]*Alert, 0, len(gw.alerts))
+	for _, alert := range gw.alerts {
+		a := *alert
+		alerts = append(alerts, &a)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	return alerts
+}
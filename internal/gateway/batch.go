@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// maxPublishFlush is the maximum flush interval of the publish batcher.
+	maxPublishFlush = time.Second
+	// maxBatchSize is the number of messages after which a batch is flushed before the flush interval elapsed.
+	maxBatchSize = DefChanSize
+)
+
+// A batch collects the messages published within a flush interval. Retained messages
+// of the same topic are coalesced as only the last value is relevant for subscribers.
+type batch struct {
+	msgs     []*pubMsg
+	retained map[string]int // index of retained messages by topic
+	tokens   []MQTT.Token
+}
+
+func newBatch() *batch {
+	return &batch{retained: make(map[string]int)}
+}
+
+func (b *batch) add(msg *pubMsg) {
+	if msg.retain {
+		if i, ok := b.retained[msg.topic]; ok {
+			b.msgs[i] = msg
+			return
+		}
+		b.retained[msg.topic] = len(b.msgs)
+	}
+	b.msgs = append(b.msgs, msg)
+}
+
+func (b *batch) reset() {
+	for i := range b.msgs {
+		b.msgs[i] = nil
+		b.tokens[i] = nil
+	}
+	b.msgs = b.msgs[:0]
+	b.tokens = b.tokens[:0]
+	for topic := range b.retained {
+		delete(b.retained, topic)
+	}
+}
+
+// flush publishes all messages of the batch before waiting for the completion of the publish tokens,
+// so that the broker round-trips overlap instead of adding up.
+func (gw *Gateway) flush(b *batch, errCh chan<- *errMsg) {
+	for _, msg := range b.msgs {
+		b.tokens = append(b.tokens, gw.publishMsg(msg, errCh))
+	}
+	for i, token := range b.tokens {
+		if token != nil {
+			gw.waitToken(b.msgs[i], token, errCh)
+		}
+	}
+	b.reset()
+}
+
+// publishBatched publishes the messages in batches flushed every interval.
+func (gw *Gateway) publishBatched(pubCh <-chan *pubMsg, errCh chan<- *errMsg, interval time.Duration) {
+	b := newBatch()
+	snapshot := snapshot{}
+	var flushCh <-chan time.Time // nil: no pending messages
+	for {
+		select {
+		case msg, ok := <-pubCh:
+			if !ok {
+				gw.flush(b, errCh)
+				return
+			}
+			switch {
+			case msg.snapshot:
+				for _, msg := range snapshot.msgs() {
+					b.add(msg)
+				}
+			case msg.value == nil:
+				continue // nothing to publish
+			default:
+				snapshot.add(msg)
+				b.add(msg)
+			}
+			switch {
+			case len(b.msgs) >= maxBatchSize:
+				gw.flush(b, errCh)
+				flushCh = nil
+			case flushCh == nil:
+				flushCh = time.After(interval)
+			}
+		case <-flushCh:
+			gw.flush(b, errCh)
+			flushCh = nil
+		}
+	}
+}
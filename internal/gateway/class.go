@@ -0,0 +1,67 @@
+package gateway
+
+import "fmt"
+
+// Topic classes with configurable QoS and retain behavior.
+const (
+	TopicClassCS    = "cs"    // command station state events
+	TopicClassLoco  = "loco"  // loco state events
+	TopicClassError = "error" // errors
+	TopicClassIO    = "io"    // command station IO input events
+)
+
+var topicClasses = []string{TopicClassCS, TopicClassLoco, TopicClassError, TopicClassIO}
+
+// ClassConfig represents the QoS and retain configuration of a topic class overwriting
+// the gateway defaults.
+type ClassConfig struct {
+	// MQTT QoS (0..2, nil: default)
+	QoS *byte
+	// publish messages retained (nil: default)
+	Retain *bool
+}
+
+func checkClasses(classes map[string]ClassConfig) error {
+	for class, config := range classes {
+		valid := false
+		for _, topicClass := range topicClasses {
+			valid = valid || class == topicClass
+		}
+		if !valid {
+			return fmt.Errorf("invalid topic class %s", class)
+		}
+		if config.QoS != nil && *config.QoS > 2 {
+			return fmt.Errorf("topic class %s: invalid QoS %d (range 0..2)", class, *config.QoS)
+		}
+	}
+	return nil
+}
+
+// topicClass returns the topic class of an event topic (without topic root).
+func topicClass(topicStrs []string) string {
+	if len(topicStrs) == 0 {
+		return ""
+	}
+	switch topicStrs[0] {
+	case TopicClassCS, TopicClassLoco:
+		return topicStrs[0]
+	default:
+		return ""
+	}
+}
+
+// qos returns the QoS of a topic class.
+func (gw *Gateway) qos(class string, def byte) byte {
+	if config, ok := gw.config.Classes[class]; ok && config.QoS != nil {
+		return *config.QoS
+	}
+	return def
+}
+
+// retain returns the retain flag of a topic class.
+func (gw *Gateway) retain(class string, def bool) bool {
+	if config, ok := gw.config.Classes[class]; ok && config.Retain != nil {
+		return *config.Retain
+	}
+	return def
+}
@@ -3,6 +3,9 @@ package gateway
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"strings"
+	"time"
 )
 
 // Default values.
@@ -20,19 +23,107 @@ type Config struct {
 	Host string
 	// MQTT broker port
 	Port string
+	// list of MQTT broker addresses (host[:port] or URL, IPv6 literals like [::1]:1883) tried in order
+	// on connect and reconnect (failover) - overwrites Host and Port if not empty
+	Brokers []string
+	// proxy URL the broker is connected through (http://[user:password@]host:port for HTTP CONNECT
+	// or socks5://[user:password@]host:port, empty: direct connection)
+	Proxy string
+	// availability topic below the topic root (e.g. gateway/status) published retained as online on connect
+	// and set as last will offline (empty: disabled)
+	StatusTopic string
+	// connect via MQTT 5 instead of MQTT 3.1.1 (proxies and cloud profiles are not supported)
+	MQTT5 bool
+	// message expiry interval of published messages (MQTT 5 only, 0: no expiry)
+	MessageExpiry time.Duration
+	// user properties added to published messages (MQTT 5 only)
+	UserProperties map[string]string
 	// MQTT authentication username
 	Username string
 	// MQTT authentication password
 	Password string
+	// QoS and retain configuration by topic class (TopicClassCS, TopicClassLoco, TopicClassError or TopicClassIO)
+	Classes map[string]ClassConfig
+	// flush interval of the publish batcher (0: publish each message immediately)
+	PublishFlush time.Duration
+	// Sparkplug B group id (empty: Sparkplug B mode disabled)
+	SparkplugGroup string
+	// Sparkplug B edge node id (default: topic root)
+	SparkplugNode string
+	// MQTT client id (default: none - required by cloud brokers, device id for Azure IoT Hub)
+	ClientID string
+	// compatibility profile for cloud brokers (ProfileNone, ProfileAWS or ProfileAzure)
+	Profile string
+	// TLS CA certificate file (PEM, default: system certificate pool)
+	TLSCAFile string
+	// TLS client certificate file (PEM, AWS IoT Core authentication)
+	TLSCertFile string
+	// TLS client key file (PEM)
+	TLSKeyFile string
+	// Azure IoT Hub device shared access key (base64) the SAS token is generated from
+	SASKey string
+	// validity of generated SAS tokens
+	SASTokenTTL time.Duration
 }
 
 func (c *Config) validate() error {
 	if err := CheckLevelName(c.TopicRoot); err != nil {
 		return fmt.Errorf("MQTTConfig topicRoot %s: %s", c.TopicRoot, err)
 	}
+	if c.PublishFlush < 0 || c.PublishFlush > maxPublishFlush {
+		return fmt.Errorf("MQTTConfig publishFlush %s: out of range 0..%s", c.PublishFlush, maxPublishFlush)
+	}
+	if err := checkClasses(c.Classes); err != nil {
+		return fmt.Errorf("MQTTConfig classes: %s", err)
+	}
+	if err := checkProfile(c.Profile); err != nil {
+		return fmt.Errorf("MQTTConfig profile: %s", err)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("MQTTConfig: TLS client certificate and key file need to be provided both")
+	}
+	switch c.Profile {
+	case ProfileAWS:
+		if c.ClientID == "" || c.TLSCertFile == "" {
+			return fmt.Errorf("MQTTConfig profile %s: client id and TLS client certificate required", c.Profile)
+		}
+	case ProfileAzure:
+		if c.ClientID == "" || c.SASKey == "" {
+			return fmt.Errorf("MQTTConfig profile %s: client id (device id) and SAS key required", c.Profile)
+		}
+	}
+	if c.Proxy != "" {
+		if _, err := parseProxyURL(c.Proxy); err != nil {
+			return fmt.Errorf("MQTTConfig proxy: %s", err)
+		}
+	}
+	if err := c.validateMQTT5(); err != nil {
+		return err
+	}
+	if err := c.validateStatusTopic(); err != nil {
+		return err
+	}
+	if _, err := c.brokerURLs(); err != nil {
+		return fmt.Errorf("MQTTConfig brokers: %s", err)
+	}
+	if c.SparkplugGroup != "" {
+		if err := CheckLevelName(c.SparkplugGroup); err != nil {
+			return fmt.Errorf("MQTTConfig sparkplugGroup %s: %s", c.SparkplugGroup, err)
+		}
+		if err := CheckLevelName(c.sparkplugNode()); err != nil {
+			return fmt.Errorf("MQTTConfig sparkplugNode %s: %s", c.sparkplugNode(), err)
+		}
+	}
 	return nil
 }
 
+func (c *Config) sparkplugNode() string {
+	if c.SparkplugNode == "" {
+		return c.TopicRoot
+	}
+	return c.SparkplugNode
+}
+
 func (c *Config) port() string {
 	if c.Port == "" {
 		return DefaultPort
@@ -40,4 +131,96 @@ func (c *Config) port() string {
 	return c.Port
 }
 
-func (c *Config) addr() string { return net.JoinHostPort(c.Host, c.port()) }
+func (c *Config) addr() string { return net.JoinHostPort(trimBrackets(c.Host), c.port()) }
+
+// trimBrackets removes the brackets of an IPv6 literal (e.g. [::1]).
+func trimBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// useTLS returns true if the broker connection is TLS encrypted (always for cloud profiles).
+func (c *Config) useTLS() bool {
+	return c.Profile != ProfileNone || c.TLSCAFile != "" || c.TLSCertFile != ""
+}
+
+func (c *Config) scheme() string {
+	if c.useTLS() {
+		return "ssl"
+	}
+	return "tcp"
+}
+
+// Broker URL schemes supported by the MQTT client.
+var brokerSchemes = []string{"tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss"}
+
+// brokerURL normalizes a broker address (host[:port] or URL) to a broker URL.
+func (c *Config) brokerURL(broker string) (string, error) {
+	broker = strings.TrimSpace(broker)
+	if strings.Contains(broker, "://") {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return "", err
+		}
+		valid := false
+		for _, scheme := range brokerSchemes {
+			valid = valid || u.Scheme == scheme
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid broker URL scheme %s", u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("invalid broker URL %s - host missing", broker)
+		}
+		return broker, nil
+	}
+
+	host, port := broker, ""
+	if net.ParseIP(broker) == nil { // IPv6 literal without port (e.g. ::1) would be split
+		if h, p, err := net.SplitHostPort(broker); err == nil {
+			host, port = h, p
+		}
+	}
+	host = trimBrackets(host)
+	if host == "" {
+		return "", fmt.Errorf("invalid broker address %s - host missing", broker)
+	}
+	if port == "" {
+		port = c.port()
+	}
+	return c.scheme() + "://" + net.JoinHostPort(host, port), nil
+}
+
+// brokerURLs returns the broker URLs in failover order.
+func (c *Config) brokerURLs() ([]string, error) {
+	if len(c.Brokers) == 0 {
+		return []string{c.scheme() + "://" + c.addr()}, nil
+	}
+	urls := make([]string, len(c.Brokers))
+	for i, broker := range c.Brokers {
+		u, err := c.brokerURL(broker)
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
+// brokers returns the broker URLs for logging.
+func (c *Config) brokers() string {
+	urls, _ := c.brokerURLs() // validated
+	return strings.Join(urls, ",")
+}
+
+// DefaultSASTokenTTL is the default validity of generated SAS tokens.
+const DefaultSASTokenTTL = 24 * time.Hour
+
+func (c *Config) sasTokenTTL() time.Duration {
+	if c.SASTokenTTL == 0 {
+		return DefaultSASTokenTTL
+	}
+	return c.SASTokenTTL
+}
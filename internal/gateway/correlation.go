@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+const classReply = "reply"
+
+// A correlatedCmd is a command payload envelope carrying a correlation id (and an optional response topic),
+// so that clients can match the reply to their request:
+//
+//	{"correlationId": "<id>", "responseTopic": "<topic>", "value": <command payload>}
+type correlatedCmd struct {
+	CorrelationID string          `json:"correlationId"`
+	ResponseTopic string          `json:"responseTopic"`
+	Value         json.RawMessage `json:"value"`
+}
+
+// A reply is published to the response topic of a correlated command.
+type reply struct {
+	CorrelationID string `json:"correlationId"`
+	Topic         string `json:"topic"`
+	Value         any    `json:"value,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// A CorrelatedError is an error of a correlated command.
+type CorrelatedError struct {
+	CorrelationID string
+	Err           error
+}
+
+func (e *CorrelatedError) Error() string { return e.Err.Error() }
+func (e *CorrelatedError) Unwrap() error { return e.Err }
+
+// correlationID returns the correlation id of an error (empty if the error is not correlated).
+func correlationID(err error) string {
+	var cerr *CorrelatedError
+	if errors.As(err, &cerr) {
+		return cerr.CorrelationID
+	}
+	return ""
+}
+
+// correlation unwraps the payload of a correlated command and returns the function wrapping the handler
+// functions publishing the reply (payload unchanged and nil if the payload is no correlated command).
+func (gw *Gateway) correlation(topic string, payload []byte) ([]byte, func(HndFn) HndFn) {
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		return payload, nil
+	}
+	var cmd correlatedCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil || cmd.CorrelationID == "" {
+		return payload, nil
+	}
+	responseTopic := cmd.ResponseTopic
+	if responseTopic == "" {
+		responseTopic = topicJoinStr(gw.topicRoot(), classReply)
+	}
+	value := cmd.Value
+	if len(value) == 0 {
+		value = json.RawMessage("null")
+	}
+	return value, func(fn HndFn) HndFn {
+		return func(payload any) (any, error) {
+			value, err := fn(payload)
+			r := &reply{CorrelationID: cmd.CorrelationID, Topic: topic}
+			if err != nil {
+				r.Error = err.Error()
+				err = &CorrelatedError{CorrelationID: cmd.CorrelationID, Err: err}
+			} else {
+				r.Value = value
+			}
+			gw.pubCh <- &pubMsg{topic: responseTopic, qos: defaultQoS, value: r}
+			return value, err
+		}
+	}
+}
+
+// chainWrap returns a function applying the handler function wrappers a and b (nil wrappers are skipped).
+func chainWrap(a, b func(HndFn) HndFn) func(HndFn) HndFn {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	default:
+		return func(fn HndFn) HndFn { return a(b(fn)) }
+	}
+}
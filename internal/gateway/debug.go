@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pico-cs/mqtt-gateway/internal/server"
+)
+
+// SubscriptionInfo represents debugging information of a subscription.
+type SubscriptionInfo struct {
+	// subscription topic (without topic root)
+	Topic string `json:"topic"`
+	// subscription owner
+	Owner string `json:"owner"`
+	// number of messages queued in the handler channel
+	ChanLen int `json:"chanLen"`
+	// capacity of the handler channel
+	ChanCap int `json:"chanCap"`
+}
+
+func ownerString(owner any) string {
+	if s, ok := owner.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", owner)
+}
+
+// Subscriptions returns debugging information about all subscriptions sorted by topic.
+func (gw *Gateway) Subscriptions() []SubscriptionInfo {
+	gw.mu.RLock()
+	defer gw.mu.RUnlock()
+
+	var infos []SubscriptionInfo
+	add := func(topic string, subscription subscription) {
+		infos = append(infos, SubscriptionInfo{
+			Topic:   topic,
+			Owner:   ownerString(subscription.owner),
+			ChanLen: len(subscription.hndCh),
+			ChanCap: cap(subscription.hndCh),
+		})
+	}
+	for topic, subscriptions := range gw.subscriptions {
+		for _, subscription := range subscriptions {
+			add(topic, subscription)
+		}
+	}
+	for topic, subscriptions := range gw.routers {
+		for _, subscription := range subscriptions {
+			add(topicJoinStr(topic, multiLevel), subscription)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Topic != infos[j].Topic {
+			return infos[i].Topic < infos[j].Topic
+		}
+		return infos[i].Owner < infos[j].Owner
+	})
+	return infos
+}
+
+// ident for json marshalling.
+var indent = strings.Repeat(" ", 4)
+
+// ServeSubscriptions provides the subscription debugging information as JSON.
+func (gw *Gateway) ServeSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	b, err := json.MarshalIndent(gw.Subscriptions(), "", indent)
+	if err != nil {
+		server.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// explain topics.
+var (
+	explainTopicStrs       = []string{"debug", "explain"}
+	explainResultTopicStrs = []string{"debug", "explain", "result"}
+	explainTopic           = topicJoin(explainTopicStrs)
+)
+
+type explainRequest struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// explainSubscription represents a subscription the explained message would be dispatched to.
+type explainSubscription struct {
+	SubscriptionInfo
+	// payload type expected by the handler (empty if the handler does not decode the payload)
+	PayloadType string `json:"payloadType,omitempty"`
+	// error decoding the payload into the expected payload type
+	Error string `json:"error,omitempty"`
+}
+
+type explainResult struct {
+	// requested topic
+	Topic string `json:"topic"`
+	// topic levels without topic root
+	Levels []string `json:"levels"`
+	// true if the requested topic did contain the topic root
+	Root bool `json:"root"`
+	// json type of the payload
+	PayloadType string `json:"payloadType"`
+	// subscriptions the message would be dispatched to
+	Subscriptions []explainSubscription `json:"subscriptions"`
+	// informational text
+	Info string `json:"info"`
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// explain explains how a topic and payload would be parsed and routed by the gateway.
+func (gw *Gateway) explain(payload []byte) (*explainResult, error) {
+	var req explainRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, fmt.Errorf("explain: invalid request %s - expected {\"topic\": <topic>, \"payload\": <payload>}", payload)
+	}
+	if req.Topic == "" {
+		return nil, fmt.Errorf("explain: topic missing in request %s", payload)
+	}
+
+	if req.Payload == nil { // payload missing
+		req.Payload = json.RawMessage(null)
+	}
+	var v any
+	if err := json.Unmarshal(req.Payload, &v); err != nil {
+		return nil, fmt.Errorf("explain: invalid payload %s", req.Payload)
+	}
+
+	result := &explainResult{Topic: req.Topic, PayloadType: jsonType(v), Subscriptions: []explainSubscription{}}
+
+	topicStrs := topicSplit(req.Topic)
+	if topicStrs[0] == gw.topicRoot() {
+		result.Root = true
+		topicStrs = topicStrs[1:]
+	}
+	result.Levels = topicStrs
+
+	topic := topicJoin(topicStrs)
+
+	gw.mu.RLock()
+	gw.match(topic, func(subTopic string, subscription subscription, hndFn HndFn) {
+		info := explainSubscription{
+			SubscriptionInfo: SubscriptionInfo{
+				Topic:   subTopic,
+				Owner:   ownerString(subscription.owner),
+				ChanLen: len(subscription.hndCh),
+				ChanCap: cap(subscription.hndCh),
+			},
+		}
+		if typ, ok, err := probeType(hndFn, req.Payload); ok {
+			info.PayloadType = typ
+			if err != nil {
+				info.Error = err.Error()
+			}
+		}
+		result.Subscriptions = append(result.Subscriptions, info)
+	})
+	gw.mu.RUnlock()
+
+	if len(result.Subscriptions) == 0 {
+		result.Info = fmt.Sprintf("no handler subscribed to topic %s - message would be ignored", topic)
+	} else {
+		eventTopicStrs := append([]string{gw.topicRoot()}, topicStrs[:len(topicStrs)-1]...)
+		result.Info = fmt.Sprintf("message would be dispatched to %d handler(s) - results would be published to topic %s", len(result.Subscriptions), topicJoin(eventTopicStrs))
+	}
+	return result, nil
+}
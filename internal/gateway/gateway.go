@@ -3,12 +3,17 @@ package gateway
 
 // use paho mqtt 3.1 broker instead the mqtt 5 version github.com/eclipse/paho.golang/paho
 // because couldn't get the retain message handling work properly which is an essential part
-// of this gateway
+// of this gateway - the mqtt 5 version is only used in the opt-in MQTT 5 mode (see mqtt5.go)
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/pico-cs/mqtt-gateway/internal/logger"
@@ -17,7 +22,8 @@ import (
 // DefChanSize defines the default channel size.
 const DefChanSize = 100
 
-// HndFn represents a handler function.
+// HndFn represents a handler function. The payload of messages received by the broker is
+// provided as raw JSON (json.RawMessage) - use Typed or Decode to decode it.
 type HndFn func(payload any) (any, error)
 
 // HndMsg represents a message provided to a registered handler.
@@ -25,12 +31,24 @@ type HndMsg struct {
 	TopicStrs []string
 	Fn        HndFn
 	Value     any
+	wrap      func(HndFn) HndFn // handler function wrapper (e.g. publishing replies)
+}
+
+// Wrap wraps fn like the handler function of the message, so that e.g. a result served from a cache
+// is replied to a correlated command as well.
+func (m *HndMsg) Wrap(fn HndFn) HndFn {
+	if m.wrap == nil {
+		return fn
+	}
+	return m.wrap(fn)
 }
 
 type pubMsg struct {
-	topic  string
-	retain bool
-	value  any
+	topic    string
+	qos      byte
+	retain   bool
+	value    any
+	snapshot bool // snapshot request
 }
 
 type errMsg struct {
@@ -42,9 +60,28 @@ type errMsg struct {
 type subscription struct {
 	owner any
 	fn    HndFn
+	route RouteFn // wildcard subscription
 	hndCh chan<- *HndMsg
 }
 
+// match calls fn for each subscription matching topic (without topic root) with the subscription topic
+// and the handler function (gw.mu needs to be read locked). match does not allocate.
+func (gw *Gateway) match(topic string, fn func(subTopic string, subscription subscription, hndFn HndFn)) {
+	for _, subscription := range gw.subscriptions[topic] {
+		fn(topic, subscription, subscription.fn)
+	}
+	for i := len(topic) - 1; i > 0; i-- { // wildcard subscriptions: check all parent levels
+		if topic[i] != sep[0] {
+			continue
+		}
+		for _, subscription := range gw.routers[topic[:i]] {
+			if hndFn, ok := subscription.route(topic[i+1:]); ok {
+				fn(topic[:i]+sep+multiLevel, subscription, hndFn)
+			}
+		}
+	}
+}
+
 const classError = "error"
 
 // Gateway represents a MQTT broker gateway.
@@ -56,8 +93,26 @@ type Gateway struct {
 	mu            sync.RWMutex
 	listening     bool
 	subscriptions map[string][]subscription
+	routers       map[string][]subscription // wildcard subscriptions (key: topic without multi-level wildcard)
+
+	connHandlers map[any]func(connected bool)
+	errHandlers  []func(topic string, err error)
+
+	pubHndMu    sync.RWMutex
+	pubHandlers []func(topicStrs []string, value any)
+
+	mwMu     sync.RWMutex
+	inbound  []InboundFn
+	outbound []OutboundFn
+
+	alertMu sync.Mutex
+	alerts  map[string]*Alert // raised alerts
+
+	sparkplug *sparkplug // nil: Sparkplug B mode disabled
+	profile   profile
 
 	subTopic   string
+	rootPrefix string // topic root including separator
 	errorTopic string
 
 	pubCh chan *pubMsg
@@ -79,7 +134,11 @@ func New(lg logger.Logger, config *Config) (*Gateway, error) {
 		lg:            lg,
 		config:        config,
 		subscriptions: make(map[string][]subscription),
+		routers:       make(map[string][]subscription),
+		connHandlers:  make(map[any]func(connected bool)),
+		profile:       newProfile(config),
 		subTopic:      topicJoinStr(config.TopicRoot, multiLevel),
+		rootPrefix:    config.TopicRoot + sep,
 		errorTopic:    topicJoinStr(config.TopicRoot, classError),
 		pubCh:         make(chan *pubMsg, DefChanSize),
 		errCh:         make(chan *errMsg, DefChanSize),
@@ -91,20 +150,76 @@ func New(lg logger.Logger, config *Config) (*Gateway, error) {
 	// retained messages should be enough initializing the
 	// command stations
 	opts := MQTT.NewClientOptions()
-	opts.AddBroker(config.addr())
+	brokerURLs, _ := config.brokerURLs() // validated
+	for _, brokerURL := range brokerURLs {
+		opts.AddBroker(brokerURL) // tried in order (failover)
+	}
+	if config.Proxy != "" {
+		proxyURL, _ := parseProxyURL(config.Proxy) // validated
+		opts.SetCustomOpenConnectionFn(proxyConnectionFn(proxyURL))
+	}
+	opts.SetClientID(config.ClientID)
 	opts.SetUsername(config.Username)
 	opts.SetPassword(config.Password)
+	if config.useTLS() {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if config.Profile == ProfileAzure {
+		opts.SetUsername(azureUsername(config.Host, config.ClientID))
+		opts.SetCredentialsProvider(func() (string, string) { // new token on each (re)connect
+			token, err := azureSASToken(config.Host, config.ClientID, config.SASKey, time.Now().Add(config.sasTokenTTL()))
+			if err != nil {
+				lg.Printf("generate SAS token: %s", err)
+			}
+			return azureUsername(config.Host, config.ClientID), token
+		})
+	}
 	opts.SetAutoReconnect(true)
 	opts.SetCleanSession(true)
 	opts.SetDefaultPublishHandler(gw.handler)
+	opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+		lg.Printf("connection to broker %s lost: %s", config.brokers(), err)
+		gw.notifyConn(false)
+	})
+	opts.SetReconnectingHandler(func(client MQTT.Client, opts *MQTT.ClientOptions) {
+		lg.Printf("reconnect to broker %s", config.brokers())
+		gw.setWill(opts)
+	})
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		lg.Printf("connection attempt to broker %s", broker)
+		return tlsCfg
+	})
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		if gw.sparkplug != nil {
+			gw.sparkplug.connected(client)
+		}
+		if config.StatusTopic != "" {
+			gw.publishStatus(client, StatusOnline)
+		}
+		gw.notifyConn(true)
+	})
+
+	if config.SparkplugGroup != "" {
+		gw.sparkplug = newSparkplug(lg, config.SparkplugGroup, config.sparkplugNode())
+	}
+	gw.setWill(opts)
 
-	client := MQTT.NewClient(opts)
+	var client MQTT.Client
+	if config.MQTT5 {
+		client = newMQTT5Client(config, opts)
+	} else {
+		client = MQTT.NewClient(opts)
+	}
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		return nil, token.Error()
 	}
 	gw.client = client
 
-	lg.Printf("connect to broker %s", config.addr())
+	lg.Printf("connect to broker %s", config.brokers())
 
 	// start go routines
 	go gw.publish(gw.wg, gw.pubCh, gw.errCh)
@@ -113,12 +228,24 @@ func New(lg logger.Logger, config *Config) (*Gateway, error) {
 	return gw, nil
 }
 
+// setWill sets the will message of a new connection (Sparkplug B NDEATH with a new birth / death
+// sequence number or the offline status).
+func (gw *Gateway) setWill(opts *MQTT.ClientOptions) {
+	if gw.sparkplug != nil {
+		opts.SetBinaryWill(gw.sparkplug.topic(spNDeath), gw.sparkplug.newSession(), spWillQoS, false)
+	}
+	if gw.config.StatusTopic != "" {
+		gw.setStatusWill(opts)
+	}
+}
+
 // topicRoot returns the topic root.
 func (gw *Gateway) topicRoot() string { return gw.config.TopicRoot }
 
 const (
-	defaultQoS = 1
-	wait       = 250 // waiting time for client disconnect in ms
+	defaultQoS   = 1
+	telemetryQoS = 0
+	wait         = 250 // waiting time for client disconnect in ms
 )
 
 // Close closes the gateway.
@@ -128,12 +255,21 @@ func (gw *Gateway) Close() error {
 	close(gw.pubCh)
 	close(gw.errCh)
 	gw.wg.Wait()
-	gw.lg.Printf("disconnect from broker %s", gw.config.addr())
+	gw.lg.Printf("disconnect from broker %s", gw.config.brokers())
+	if gw.sparkplug != nil {
+		gw.sparkplug.close()
+	}
 	gw.unsubscribeBroker() // ignore error
+	if gw.config.StatusTopic != "" {
+		gw.publishStatus(gw.client, StatusOffline) // no last will on regular disconnect
+	}
 	gw.client.Disconnect(wait)
 	return nil
 }
 
+// Connected returns true if the gateway is connected to the broker.
+func (gw *Gateway) Connected() bool { return gw.client.IsConnectionOpen() }
+
 // PublishErr publishes a error message
 func (gw *Gateway) PublishErr(topicStrs []string, retain bool, err error) {
 	topicRootStr := topicJoin(append([]string{gw.topicRoot()}, topicStrs...))
@@ -143,7 +279,53 @@ func (gw *Gateway) PublishErr(topicStrs []string, retain bool, err error) {
 // Publish publishes a message.
 func (gw *Gateway) Publish(topicStrs []string, retain bool, value any) {
 	topicRootStr := topicJoin(append([]string{gw.topicRoot()}, topicStrs...))
-	gw.pubCh <- &pubMsg{topic: topicRootStr, retain: retain, value: value}
+	value, err := gw.applyOutbound(topicStrs, value)
+	if err != nil {
+		gw.reject(topicRootStr, err)
+		return
+	}
+	class := topicClass(topicStrs)
+	gw.pubCh <- &pubMsg{topic: topicRootStr, qos: gw.qos(class, defaultQoS), retain: gw.retain(class, retain), value: value}
+	gw.updateSparkplug(topicStrs, retain, value)
+	gw.notifyPublish(topicStrs, value)
+}
+
+// updateSparkplug updates the Sparkplug B metrics by a retained event (Sparkplug B mode only).
+func (gw *Gateway) updateSparkplug(topicStrs []string, retain bool, value any) {
+	if gw.sparkplug != nil && retain && value != nil {
+		gw.sparkplug.update(topicStrs, value)
+	}
+}
+
+// PublishTelemetry publishes a telemetry message (e.g. sensor events or metrics) with QoS 0 directly
+// (fire-and-forget) bypassing the publish queue, so that the caller does not stall on slow brokers.
+// Telemetry messages might get lost and are not ordered with respect to messages sent by Publish.
+func (gw *Gateway) PublishTelemetry(topicStrs []string, retain bool, value any) {
+	gw.publishTelemetry(topicClass(topicStrs), topicStrs, retain, value)
+}
+
+// PublishIO publishes a command station IO input event like a telemetry message (topic class TopicClassIO).
+func (gw *Gateway) PublishIO(topicStrs []string, retain bool, value any) {
+	gw.publishTelemetry(TopicClassIO, topicStrs, retain, value)
+}
+
+func (gw *Gateway) publishTelemetry(class string, topicStrs []string, retain bool, value any) {
+	topicRootStr := topicJoin(append([]string{gw.topicRoot()}, topicStrs...))
+	value, err := gw.applyOutbound(topicStrs, value)
+	if err != nil {
+		gw.reject(topicRootStr, err)
+		return
+	}
+	payload, err := marshal(value)
+	if err != nil {
+		gw.errCh <- &errMsg{topic: topicRootStr, err: err}
+		return
+	}
+	classRetain := gw.retain(class, retain)
+	gw.lg.Printf("publish telemetry topic %s retain %t value %v\n", topicRootStr, classRetain, value)
+	gw.client.Publish(gw.profile.brokerTopic(topicRootStr), gw.qos(class, telemetryQoS), classRetain && gw.profile.retain(), payload) // do not wait for token
+	gw.updateSparkplug(topicStrs, retain, value)
+	gw.notifyPublish(topicStrs, value)
 }
 
 // Listen starts the gateway listening to the mqtt broker.
@@ -159,15 +341,62 @@ func (gw *Gateway) Listen() error {
 	return gw.subscribeBroker()
 }
 
+// RetainedMsg represents a retained message.
+type RetainedMsg struct {
+	TopicStrs []string // topic without topic root
+	Payload   []byte
+}
+
+// Retained returns the retained messages of all topics below topicStrs (without topic root).
+// Retained returns after no further message was received within the quiet period.
+func (gw *Gateway) Retained(topicStrs []string, quiet time.Duration) ([]*RetainedMsg, error) {
+	if !gw.profile.retain() {
+		return nil, fmt.Errorf("retained messages are not supported by profile %s", gw.config.Profile)
+	}
+	topic := topicJoin(append(append([]string{gw.topicRoot()}, topicStrs...), multiLevel))
+
+	var mu sync.Mutex
+	var msgs []*RetainedMsg
+	received := make(chan struct{}, 1)
+
+	token := gw.client.Subscribe(topic, defaultQoS, func(client MQTT.Client, msg MQTT.Message) {
+		if !msg.Retained() || len(msg.Payload()) == 0 {
+			return // no or deleted retained message
+		}
+		mu.Lock()
+		msgs = append(msgs, &RetainedMsg{TopicStrs: topicSplit(msg.Topic())[1:], Payload: msg.Payload()})
+		mu.Unlock()
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+	defer gw.client.Unsubscribe(topic).Wait()
+
+	for {
+		select {
+		case <-received:
+			// wait for further messages
+		case <-time.After(quiet):
+			mu.Lock()
+			defer mu.Unlock()
+			return msgs, nil
+		}
+	}
+}
+
 func (gw *Gateway) subscribeBroker() error {
-	if token := gw.client.Subscribe(gw.subTopic, defaultQoS, gw.handler); token.Wait() && token.Error() != nil {
+	if token := gw.client.Subscribe(gw.profile.subscription(gw.subTopic), defaultQoS, gw.handler); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
 }
 
 func (gw *Gateway) unsubscribeBroker() error {
-	if token := gw.client.Unsubscribe(gw.subTopic); token.Wait() && token.Error() != nil {
+	if token := gw.client.Unsubscribe(gw.profile.subscription(gw.subTopic)); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
@@ -181,73 +410,246 @@ func (gw *Gateway) Subscribe(hndCh chan<- *HndMsg, owner any, topicStrs []string
 	gw.subscriptions[topicStr] = append(gw.subscriptions[topicStr], subscription{owner: owner, fn: fn, hndCh: hndCh})
 }
 
+// RouteFn represents a routing function returning the handler function for the topic levels
+// below a wildcard subscription (e.g. "speed/set") - false if the topic is not handled.
+type RouteFn func(subTopic string) (HndFn, bool)
+
+// SubscribeRouter subscribes a routing function to all topics below topicStrs (multi-level wildcard)
+// replacing many exact subscriptions by a single one.
+func (gw *Gateway) SubscribeRouter(hndCh chan<- *HndMsg, owner any, topicStrs []string, route RouteFn) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	topicStr := topicJoin(topicStrs)
+	gw.routers[topicStr] = append(gw.routers[topicStr], subscription{owner: owner, route: route, hndCh: hndCh})
+}
+
+// UnsubscribeRouter unsubscribes a routing function.
+func (gw *Gateway) UnsubscribeRouter(owner any, topicStrs []string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	unsubscribe(gw.routers, owner, topicJoin(topicStrs))
+}
+
 // Unsubscribe unsubscribes a message handler.
 func (gw *Gateway) Unsubscribe(owner any, topicStrs []string) {
 	gw.mu.Lock()
 	defer gw.mu.Unlock()
-	topicStr := topicJoin(topicStrs)
-	l := len(gw.subscriptions[topicStr])
-	for i, subscription := range gw.subscriptions[topicStr] {
+	unsubscribe(gw.subscriptions, owner, topicJoin(topicStrs))
+}
+
+// unsubscribe removes the subscription of owner from a subscription map (gw.mu needs to be locked).
+func unsubscribe(m map[string][]subscription, owner any, topicStr string) {
+	l := len(m[topicStr])
+	for i, subscription := range m[topicStr] {
 		if subscription.owner == owner {
-			gw.subscriptions[topicStr][i] = gw.subscriptions[topicStr][l-1]
-			gw.subscriptions[topicStr] = gw.subscriptions[topicStr][:l-1]
+			m[topicStr][i] = m[topicStr][l-1]
+			m[topicStr] = m[topicStr][:l-1]
+			if l == 1 {
+				delete(m, topicStr)
+			}
 			break
 		}
 	}
 }
 
-func (gw *Gateway) handler(client MQTT.Client, msg MQTT.Message) {
-	topicStrs := topicSplit(msg.Topic())
+// SubscribeConn registers a handler called on broker connection state changes.
+func (gw *Gateway) SubscribeConn(owner any, fn func(connected bool)) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.connHandlers[owner] = fn
+}
 
-	var value any
-	if err := json.Unmarshal(msg.Payload(), &value); err != nil {
-		gw.errCh <- &errMsg{topic: msg.Topic(), err: err}
-		return
+// UnsubscribeConn unregisters a broker connection state handler.
+func (gw *Gateway) UnsubscribeConn(owner any) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	delete(gw.connHandlers, owner)
+}
+
+// OnError registers a callback function called for each error published on the error topic.
+// The callback function must not block.
+func (gw *Gateway) OnError(fn func(topic string, err error)) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.errHandlers = append(gw.errHandlers, fn)
+}
+
+func (gw *Gateway) notifyError(topic string, err error) {
+	gw.mu.RLock()
+	defer gw.mu.RUnlock()
+	for _, fn := range gw.errHandlers {
+		fn(topic, err)
 	}
+}
 
-	gw.lg.Printf("receive topic %s retained %t value %v\n", msg.Topic(), msg.Retained(), value)
+// OnPublish registers a callback function called for each event published by the gateway
+// (topic levels without topic root). The callback function must not block.
+func (gw *Gateway) OnPublish(fn func(topicStrs []string, value any)) {
+	gw.pubHndMu.Lock()
+	defer gw.pubHndMu.Unlock()
+	gw.pubHandlers = append(gw.pubHandlers, fn)
+}
 
+func (gw *Gateway) notifyPublish(topicStrs []string, value any) {
+	gw.pubHndMu.RLock()
+	defer gw.pubHndMu.RUnlock()
+	for _, fn := range gw.pubHandlers {
+		fn(topicStrs, value)
+	}
+}
+
+func (gw *Gateway) notifyConn(connected bool) {
 	gw.mu.RLock()
 	defer gw.mu.RUnlock()
+	for _, fn := range gw.connHandlers {
+		go fn(connected) // do not block the MQTT client
+	}
+}
 
-	topicNoRootStr := topicJoin(topicStrs[1:]) // no root
-	subscriptions, ok := gw.subscriptions[topicNoRootStr]
+func (gw *Gateway) handler(client MQTT.Client, msg MQTT.Message) {
+	payload := msg.Payload()
+	topic, ok := gw.profile.gatewayTopic(msg.Topic())
 	if !ok {
-		return // nothing to do
+		return
+	}
+	topic = strings.TrimPrefix(topic, gw.rootPrefix) // no root
+
+	payload, err := gw.applyInbound(topic, payload)
+	if err != nil {
+		gw.reject(msg.Topic(), err)
+		return
 	}
 
-	for _, subscription := range subscriptions {
-		subscription.hndCh <- &HndMsg{TopicStrs: topicStrs[1:], Fn: subscription.fn, Value: value}
+	if strings.HasPrefix(topic, protoPrefix) {
+		gw.lg.Printf("receive topic %s retained %t protocol buffer payload %d bytes\n", msg.Topic(), msg.Retained(), len(payload))
+		gw.dispatch(topic, nil, ProtoPayload(payload), gw.responseFn(msg)) // decoded by the handler functions
+		return
 	}
+
+	if !json.Valid(payload) {
+		gw.errCh <- &errMsg{topic: msg.Topic(), err: fmt.Errorf("invalid JSON payload %s", payload)}
+		return
+	}
+
+	gw.lg.Printf("receive topic %s retained %t payload %s\n", msg.Topic(), msg.Retained(), payload)
+
+	if topic == snapshotTopic {
+		gw.pubCh <- &pubMsg{snapshot: true}
+		return
+	}
+
+	if topic == explainTopic {
+		result, err := gw.explain(payload)
+		if err != nil {
+			gw.PublishErr(explainTopicStrs, false, err)
+			return
+		}
+		gw.Publish(explainResultTopicStrs, false, result)
+		return
+	}
+
+	payload, wrap := gw.correlation(msg.Topic(), payload)
+	gw.dispatch(topic, nil, json.RawMessage(payload), chainWrap(wrap, gw.responseFn(msg))) // decoded by the handler functions
+}
+
+// Dispatch dispatches a value to the handlers subscribed to topic (without topic root) like
+// a message received by the broker. Dispatch returns false if no handler is subscribed to the topic.
+func (gw *Gateway) Dispatch(topicStrs []string, value any) bool {
+	return gw.dispatch(topicJoin(topicStrs), topicStrs, value, nil)
+}
+
+// DispatchInbound dispatches a JSON payload to the handlers subscribed to topic (without topic root)
+// after applying the inbound middleware functions like a message received by the broker (e.g. for
+// commands received by other transports). DispatchInbound returns false if no handler is subscribed
+// to the topic and an error if the payload is rejected by a middleware function or no valid JSON.
+// Messages dropped by a middleware function (ErrSkip) are not reported.
+func (gw *Gateway) DispatchInbound(topicStrs []string, payload []byte) (bool, error) {
+	topic := topicJoin(topicStrs)
+	payload, err := gw.applyInbound(topic, payload)
+	if err != nil {
+		if errors.Is(err, ErrSkip) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !json.Valid(payload) {
+		return false, fmt.Errorf("invalid JSON payload %s", payload)
+	}
+	return gw.dispatch(topic, topicStrs, json.RawMessage(payload), nil), nil
+}
+
+// dispatch dispatches a value to the handlers subscribed to topic. The topic levels are
+// split lazily if topicStrs is nil and a handler is matching. The handler functions are
+// wrapped by wrap if not nil (e.g. publishing a MQTT 5 response).
+func (gw *Gateway) dispatch(topic string, topicStrs []string, value any, wrap func(HndFn) HndFn) bool {
+	gw.mu.RLock()
+	defer gw.mu.RUnlock()
+
+	matched := false
+	gw.match(topic, func(subTopic string, subscription subscription, hndFn HndFn) {
+		if topicStrs == nil {
+			topicStrs = topicSplit(topic)
+		}
+		if wrap != nil {
+			hndFn = wrap(hndFn)
+		}
+		subscription.hndCh <- &HndMsg{TopicStrs: topicStrs, Fn: hndFn, Value: value, wrap: wrap}
+		matched = true
+	})
+	return matched
 }
 
 func (gw *Gateway) publish(wg *sync.WaitGroup, pubCh <-chan *pubMsg, errCh chan<- *errMsg) {
 	wg.Add(1)
 	defer wg.Done()
 
+	if gw.config.PublishFlush > 0 {
+		gw.publishBatched(pubCh, errCh, gw.config.PublishFlush)
+		return
+	}
+
+	snapshot := snapshot{}
 	for msg := range pubCh {
+		if msg.snapshot {
+			for _, msg := range snapshot.msgs() {
+				if token := gw.publishMsg(msg, errCh); token != nil {
+					gw.waitToken(msg, token, errCh)
+				}
+			}
+			continue
+		}
 		if msg.value == nil {
 			continue // nothing to publish
 		}
+		snapshot.add(msg)
+		if token := gw.publishMsg(msg, errCh); token != nil {
+			gw.waitToken(msg, token, errCh)
+		}
+	}
+}
 
-		gw.lg.Printf("publish topic %s retain %t value %v\n", msg.topic, msg.retain, msg.value)
+// publishMsg publishes a message without waiting for the completion (nil token in case of an error).
+func (gw *Gateway) publishMsg(msg *pubMsg, errCh chan<- *errMsg) MQTT.Token {
+	gw.lg.Printf("publish topic %s retain %t value %v\n", msg.topic, msg.retain, msg.value)
 
-		payload, err := json.Marshal(msg.value)
-		if err != nil {
-			errCh <- &errMsg{topic: msg.topic, err: err}
-			continue
-		}
+	payload, err := marshal(msg.value)
+	if err != nil {
+		errCh <- &errMsg{topic: msg.topic, err: err}
+		return nil
+	}
+	return gw.client.Publish(gw.profile.brokerTopic(msg.topic), msg.qos, msg.retain && gw.profile.retain(), payload)
+}
 
-		token := gw.client.Publish(msg.topic, defaultQoS, msg.retain, payload)
-		if token.Wait() && token.Error() != nil {
-			errCh <- &errMsg{topic: msg.topic, err: token.Error()}
-		}
+func (gw *Gateway) waitToken(msg *pubMsg, token MQTT.Token, errCh chan<- *errMsg) {
+	if token.Wait() && token.Error() != nil {
+		errCh <- &errMsg{topic: msg.topic, err: token.Error()}
 	}
 }
 
 type errPayload struct {
-	Topic string `json:"topic"`
-	Error string `json:"error"`
+	Topic         string `json:"topic"`
+	Error         string `json:"error"`
+	CorrelationID string `json:"correlationId,omitempty"`
 }
 
 func (gw *Gateway) publishError(wg *sync.WaitGroup, errCh <-chan *errMsg) {
@@ -257,14 +659,16 @@ func (gw *Gateway) publishError(wg *sync.WaitGroup, errCh <-chan *errMsg) {
 	for msg := range errCh {
 
 		gw.lg.Printf("publish topic %s retain %t error %s\n", msg.topic, msg.retain, msg.err)
+		gw.notifyError(msg.topic, msg.err)
 
-		payload, err := json.Marshal(&errPayload{Topic: msg.topic, Error: msg.err.Error()})
+		payload, err := json.Marshal(&errPayload{Topic: msg.topic, Error: msg.err.Error(), CorrelationID: correlationID(msg.err)})
 		if err != nil {
 			// hm, we can only log...
 			gw.lg.Printf("publish error topic %s err %s", msg.topic, err)
 		}
 
-		token := gw.client.Publish(gw.errorTopic, defaultQoS, msg.retain, payload)
+		retain := gw.retain(TopicClassError, msg.retain) && gw.profile.retain()
+		token := gw.client.Publish(gw.profile.brokerTopic(gw.errorTopic), gw.qos(TopicClassError, defaultQoS), retain, payload)
 		if token.Wait() && token.Error() != nil {
 			// hm, we can only log...
 			gw.lg.Printf("publish error topic %s err %s", msg.topic, token.Error())
@@ -0,0 +1,429 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 0 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 0 }
+func (m *testMessage) Payload() []byte   { return m.payload }
+func (m *testMessage) Ack()              {}
+
+const numTestLocos = 200
+
+func newTestGateway(hndCh chan<- *HndMsg) *Gateway {
+	gw := &Gateway{
+		lg:            logger.Null,
+		config:        &Config{TopicRoot: DefaultTopicRoot},
+		subscriptions: make(map[string][]subscription),
+		routers:       make(map[string][]subscription),
+		rootPrefix:    DefaultTopicRoot + sep,
+		profile:       defaultProfile{},
+	}
+	fn := func(payload any) (any, error) { return payload, nil }
+	m := map[string]HndFn{"dir/set": fn, "speed/set": fn, "speed/get": fn, "light/toggle": fn}
+	for i := 0; i < numTestLocos; i++ {
+		gw.SubscribeRouter(hndCh, gw, []string{"loco", fmt.Sprintf("br%03d", i)}, func(subTopic string) (HndFn, bool) {
+			fn, ok := m[subTopic]
+			return fn, ok
+		})
+	}
+	return gw
+}
+
+func BenchmarkHandler(b *testing.B) {
+	hndCh := make(chan *HndMsg, 1)
+	gw := newTestGateway(hndCh)
+	msg := &testMessage{topic: DefaultTopicRoot + "/loco/br042/speed/set", payload: []byte("42")}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gw.handler(nil, msg)
+		<-hndCh
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	hndCh := make(chan *HndMsg, 1)
+	gw := newTestGateway(hndCh)
+	topicStrs := []string{"loco", "br042", "speed", "set"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gw.Dispatch(topicStrs, 42.0)
+		<-hndCh
+	}
+}
+
+func TestDecode(t *testing.T) {
+	if v, err := Decode[bool](json.RawMessage("true")); err != nil || !v {
+		t.Fatalf("decode bool: value %v error %v", v, err)
+	}
+	if v, err := Decode[float64](42.0); err != nil || v != 42 {
+		t.Fatalf("decode float64: value %v error %v", v, err)
+	}
+	if v, err := Decode[map[string]bool](map[string]any{"light": true}); err != nil || !v["light"] {
+		t.Fatalf("decode map: value %v error %v", v, err)
+	}
+	for _, payload := range []string{"42", "null", `"true"`} {
+		_, err := Decode[bool](json.RawMessage(payload))
+		var payloadErr *PayloadError
+		if !errors.As(err, &payloadErr) {
+			t.Fatalf("decode bool payload %s: payload error expected - got %v", payload, err)
+		}
+		if want := "invalid payload " + payload + " - boolean expected"; err.Error() != want {
+			t.Fatalf("decode bool payload %s: error %q - expected %q", payload, err, want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	hndCh := make(chan *HndMsg, 1)
+	gw := newTestGateway(hndCh)
+	called := false
+	gw.Subscribe(hndCh, gw, []string{"cs", "cs01", "enabled", "set"}, Typed(func(enabled bool) (any, error) {
+		called = true
+		return enabled, nil
+	}))
+
+	tests := []struct {
+		payload, typ, err string
+	}{
+		{`{"topic": "cs/cs01/enabled/set", "payload": true}`, "boolean", ""},
+		{`{"topic": "cs/cs01/enabled/set", "payload": 42}`, "boolean", "invalid payload 42 - boolean expected"},
+		{`{"topic": "cs/cs01/enabled/set"}`, "boolean", "invalid payload null - boolean expected"},
+		{`{"topic": "loco/br042/speed/set", "payload": 42}`, "", ""}, // untyped handler
+	}
+	for _, test := range tests {
+		result, err := gw.explain([]byte(test.payload))
+		if err != nil {
+			t.Fatalf("explain %s: %s", test.payload, err)
+		}
+		if len(result.Subscriptions) != 1 {
+			t.Fatalf("explain %s: %d subscriptions - expected 1", test.payload, len(result.Subscriptions))
+		}
+		if subscription := result.Subscriptions[0]; subscription.PayloadType != test.typ || subscription.Error != test.err {
+			t.Fatalf("explain %s: payload type %q error %q - expected %q %q", test.payload, subscription.PayloadType, subscription.Error, test.typ, test.err)
+		}
+	}
+	if called {
+		t.Fatal("explain called the typed handler function")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	b := newBatch()
+	b.add(&pubMsg{topic: "a", retain: true, value: 1})
+	b.add(&pubMsg{topic: "b", value: 1})
+	b.add(&pubMsg{topic: "a", retain: true, value: 2})
+	b.add(&pubMsg{topic: "b", value: 2})
+
+	var values []any
+	for _, msg := range b.msgs {
+		values = append(values, msg.topic, msg.value)
+	}
+	if want := []any{"a", 2, "b", 1, "b", 2}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("batch %v - expected %v", values, want)
+	}
+}
+
+func TestTopicClasses(t *testing.T) {
+	qos0, noRetain := byte(0), false
+	gw := &Gateway{
+		config:  &Config{Classes: map[string]ClassConfig{TopicClassIO: {QoS: &qos0, Retain: &noRetain}, TopicClassLoco: {QoS: &qos0}}},
+		profile: defaultProfile{},
+	}
+	if err := checkClasses(gw.config.Classes); err != nil {
+		t.Fatal(err)
+	}
+	if class := topicClass([]string{"loco", "br01", "speed"}); class != TopicClassLoco {
+		t.Fatalf("invalid topic class %s", class)
+	}
+	if qos, retain := gw.qos(TopicClassIO, defaultQoS), gw.retain(TopicClassIO, true); qos != 0 || retain {
+		t.Fatalf("invalid io qos %d retain %t", qos, retain)
+	}
+	if qos, retain := gw.qos(TopicClassLoco, defaultQoS), gw.retain(TopicClassLoco, true); qos != 0 || !retain {
+		t.Fatalf("invalid loco qos %d retain %t", qos, retain)
+	}
+	if qos, retain := gw.qos(TopicClassCS, defaultQoS), gw.retain(TopicClassCS, true); qos != defaultQoS || !retain {
+		t.Fatalf("invalid cs qos %d retain %t", qos, retain)
+	}
+	if err := checkClasses(map[string]ClassConfig{"sensor": {}}); err == nil {
+		t.Fatal("invalid topic class not detected")
+	}
+}
+
+func TestSparkplugMetrics(t *testing.T) {
+	type latency struct {
+		RTT    float64 `json:"rtt"`
+		Missed int     `json:"missed"`
+	}
+	metrics := spMetrics("cs/cs1/mte", true, nil)
+	metrics = spMetrics("loco/br01/speed", uint(42), metrics)
+	metrics = spMetrics("cs/cs1/latency", &latency{RTT: 1.5, Missed: 2}, metrics)
+	metrics = spMetrics("gateway/devices", []string{"cs1"}, metrics) // ignored
+
+	var names []string
+	for _, metric := range metrics {
+		names = append(names, metric.GetName())
+	}
+	if want := []string{"cs/cs1/mte", "loco/br01/speed", "cs/cs1/latency/missed", "cs/cs1/latency/rtt"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("metric names %v - expected %v", names, want)
+	}
+	if !metrics[0].GetBooleanValue() || metrics[1].GetLongValue() != 42 || metrics[3].GetDoubleValue() != 1.5 {
+		t.Fatalf("invalid metric values %v", metrics)
+	}
+}
+
+func TestAzureProfile(t *testing.T) {
+	p := azureProfile{deviceID: "gw1"}
+	if topic := p.brokerTopic("pico-cs/loco/br01/speed"); topic != "devices/gw1/messages/events/topic=pico-cs%2Floco%2Fbr01%2Fspeed" {
+		t.Fatalf("invalid broker topic %s", topic)
+	}
+	if topic, ok := p.gatewayTopic("devices/gw1/messages/devicebound/%24.mid=42&topic=pico-cs%2Floco%2Fbr01%2Fspeed%2Fset"); !ok || topic != "pico-cs/loco/br01/speed/set" {
+		t.Fatalf("invalid gateway topic %s", topic)
+	}
+	if _, ok := p.gatewayTopic("devices/gw2/messages/devicebound/topic=x"); ok {
+		t.Fatal("topic of other device accepted")
+	}
+}
+
+func TestMQTT5Response(t *testing.T) {
+	config := &Config{MQTT5: true, UserProperties: map[string]string{"layout": "basement"}}
+	client := newMQTT5Client(config, MQTT.NewClientOptions())
+	gw := &Gateway{lg: logger.Null, config: config, client: client}
+
+	msg := &mqtt5Message{p: &paho.Publish{Topic: "pico-cs/loco/br01/speed/set", Properties: &paho.PublishProperties{ResponseTopic: "app/response", CorrelationData: []byte("42")}}}
+	fn := gw.responseFn(msg)(func(payload any) (any, error) { return 10, nil })
+	if value, err := fn(nil); value != 10 || err != nil {
+		t.Fatalf("invalid handler result %v %s", value, err)
+	}
+
+	pub := (<-client.pubCh).p
+	if pub.Topic != "app/response" || string(pub.Payload) != "10" || string(pub.Properties.CorrelationData) != "42" {
+		t.Fatalf("invalid response %s %s correlation data %s", pub.Topic, pub.Payload, pub.Properties.CorrelationData)
+	}
+	if value := pub.Properties.User.Get("layout"); value != "basement" {
+		t.Fatalf("invalid user property %s", value)
+	}
+
+	if gw.responseFn(&mqtt5Message{p: &paho.Publish{}}) != nil {
+		t.Fatal("response without response topic")
+	}
+}
+
+func TestMQTT5Config(t *testing.T) {
+	for _, config := range []*Config{
+		{MQTT5: true, Proxy: "socks5://localhost:1080"},
+		{MQTT5: true, Profile: ProfileAWS},
+		{MQTT5: true, SparkplugGroup: "layout"},
+		{UserProperties: map[string]string{"layout": "basement"}}, // MQTT 5 mode required
+	} {
+		if err := config.validateMQTT5(); err == nil {
+			t.Fatalf("invalid MQTT 5 configuration %+v not detected", config)
+		}
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	gw := &Gateway{config: &Config{TopicRoot: "pico-cs"}, pubCh: make(chan *pubMsg, 2)}
+
+	if payload, wrap := gw.correlation("pico-cs/loco/br01/fct", []byte(`{"name":"light"}`)); wrap != nil || string(payload) != `{"name":"light"}` {
+		t.Fatalf("uncorrelated payload %s modified", payload)
+	}
+
+	payload, wrap := gw.correlation("pico-cs/loco/br01/speed/set", []byte(`{"correlationId":"42","value":10}`))
+	if wrap == nil || string(payload) != "10" {
+		t.Fatalf("invalid correlated payload %s", payload)
+	}
+	wrap(func(payload any) (any, error) { return 10, nil })(payload)
+	msg := <-gw.pubCh
+	if r := msg.value.(*reply); msg.topic != "pico-cs/reply" || r.CorrelationID != "42" || r.Value != 10 {
+		t.Fatalf("invalid reply %s %v", msg.topic, msg.value)
+	}
+
+	_, wrap = gw.correlation("pico-cs/loco/br01/speed/set", []byte(`{"correlationId":"43","responseTopic":"app/reply","value":-1}`))
+	_, err := wrap(func(payload any) (any, error) { return nil, errors.New("invalid speed") })(nil)
+	if id := correlationID(err); id != "43" {
+		t.Fatalf("invalid error correlation id %s", id)
+	}
+	msg = <-gw.pubCh
+	if r := msg.value.(*reply); msg.topic != "app/reply" || r.Error != "invalid speed" {
+		t.Fatalf("invalid error reply %s %v", msg.topic, msg.value)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gw := &Gateway{}
+	gw.UseInbound(func(topicStrs []string, payload []byte) ([]byte, error) {
+		if topicStrs[0] == "blocked" {
+			return nil, ErrSkip
+		}
+		return append(payload, '0'), nil
+	})
+	gw.UseOutbound(func(topicStrs []string, value any) (any, error) {
+		if i, ok := value.(int); ok {
+			return i * 2, nil
+		}
+		return nil, errors.New("invalid value")
+	})
+
+	if payload, err := gw.applyInbound("loco/br01/speed/set", []byte("1")); err != nil || string(payload) != "10" {
+		t.Fatalf("invalid inbound payload %s error %v", payload, err)
+	}
+	if _, err := gw.applyInbound("blocked/a", []byte("1")); !errors.Is(err, ErrSkip) {
+		t.Fatalf("invalid inbound error %v", err)
+	}
+	if value, err := gw.applyOutbound([]string{"loco", "br01", "speed"}, 21); err != nil || value != 42 {
+		t.Fatalf("invalid outbound value %v error %v", value, err)
+	}
+	if _, err := gw.applyOutbound([]string{"loco", "br01", "speed"}, "a"); err == nil {
+		t.Fatal("outbound rejection not detected")
+	}
+}
+
+func TestDispatchInbound(t *testing.T) {
+	hndCh := make(chan *HndMsg, 1)
+	gw := newTestGateway(hndCh)
+	gw.UseInbound(func(topicStrs []string, payload []byte) ([]byte, error) {
+		switch topicStrs[2] {
+		case "dir":
+			return nil, ErrSkip
+		case "light":
+			return nil, errors.New("blocked")
+		}
+		return append(payload, '0'), nil
+	})
+
+	if ok, err := gw.DispatchInbound([]string{"loco", "br042", "speed", "set"}, []byte("4")); !ok || err != nil {
+		t.Fatalf("dispatch: ok %t error %v", ok, err)
+	}
+	if msg := <-hndCh; string(msg.Value.(json.RawMessage)) != "40" {
+		t.Fatalf("invalid dispatched payload %v", msg.Value)
+	}
+	if ok, err := gw.DispatchInbound([]string{"loco", "br042", "dir", "set"}, []byte("true")); !ok || err != nil {
+		t.Fatalf("skip: ok %t error %v", ok, err)
+	}
+	if _, err := gw.DispatchInbound([]string{"loco", "br042", "light", "toggle"}, []byte("null")); err == nil {
+		t.Fatal("inbound rejection not detected")
+	}
+	if ok, _ := gw.DispatchInbound([]string{"loco", "br999", "speed", "set"}, []byte("4")); ok {
+		t.Fatal("dispatch to unsubscribed topic not detected")
+	}
+	select {
+	case msg := <-hndCh:
+		t.Fatalf("unexpected dispatched message %v", msg.TopicStrs)
+	default:
+	}
+}
+
+func TestAlert(t *testing.T) {
+	gw := &Gateway{lg: logger.Null, config: &Config{TopicRoot: DefaultTopicRoot}, pubCh: make(chan *pubMsg, 10)}
+
+	gw.RaiseAlert("cs/cs1/offline", SeverityCritical, "offline")
+	gw.RaiseAlert("cs/cs1/offline", SeverityCritical, "offline") // no state change
+	gw.ClearAlert("cs/cs1/offline")
+	gw.ClearAlert("cs/cs1/offline") // not raised
+	close(gw.pubCh)
+
+	var states []string
+	for msg := range gw.pubCh {
+		if msg.topic != "pico-cs/alert" {
+			t.Fatalf("invalid topic %s", msg.topic)
+		}
+		states = append(states, msg.value.(*Alert).State)
+	}
+	if !reflect.DeepEqual(states, []string{AlertRaised, AlertCleared}) {
+		t.Fatalf("invalid alert states %v", states)
+	}
+	if alerts := gw.Alerts(); len(alerts) != 0 {
+		t.Fatalf("unexpected raised alerts %v", alerts)
+	}
+}
+
+func TestBrokerURLs(t *testing.T) {
+	config := &Config{Port: "1884", Brokers: []string{"10.10.10.42", "backup:1885", "::1", "[fe80::1]:1886", "ws://broker:8080/mqtt"}}
+	urls, err := config.brokerURLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"tcp://10.10.10.42:1884", "tcp://backup:1885", "tcp://[::1]:1884", "tcp://[fe80::1]:1886", "ws://broker:8080/mqtt"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("invalid broker urls %v - expected %v", urls, expected)
+	}
+
+	config = &Config{Host: "[::1]", TLSCAFile: "ca.pem"}
+	if urls, _ := config.brokerURLs(); urls[0] != "ssl://[::1]:1883" {
+		t.Fatalf("invalid broker url %s", urls[0])
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	reqCh := make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		reqCh <- req
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	}()
+
+	proxyURL, err := parseProxyURL("http://user:secret@" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer, err := proxyDialer(proxyURL, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", "broker:1883")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	req := <-reqCh
+	if req.Method != http.MethodConnect || req.Host != "broker:1883" {
+		t.Fatalf("invalid proxy request %s %s", req.Method, req.Host)
+	}
+	if user, password, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Fatalf("invalid proxy authorization %v", req.Header["Proxy-Authorization"])
+	}
+
+	if _, err := parseProxyURL("ftp://proxy:21"); err == nil {
+		t.Fatal("invalid proxy scheme not detected")
+	}
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import "errors"
+
+// ErrSkip is returned by a middleware function to drop a message silently (other errors are
+// published to the error topic).
+var ErrSkip = errors.New("skip message")
+
+// An InboundFn inspects or transforms the payload of a message received by the broker before it is
+// dispatched to the handlers (topic levels without topic root). Returning an error rejects the message.
+type InboundFn func(topicStrs []string, payload []byte) ([]byte, error)
+
+// An OutboundFn inspects or transforms the value of a message before it is published (topic levels
+// without topic root). Returning an error rejects the message.
+type OutboundFn func(topicStrs []string, value any) (any, error)
+
+// UseInbound registers a middleware function called for each message received by the broker.
+// Middleware functions are called in the order of registration and must be safe for concurrent use.
+func (gw *Gateway) UseInbound(fn InboundFn) {
+	gw.mwMu.Lock()
+	defer gw.mwMu.Unlock()
+	gw.inbound = append(gw.inbound, fn)
+}
+
+// UseOutbound registers a middleware function called for each message published by Publish or
+// PublishTelemetry. Middleware functions are called in the order of registration and must be safe
+// for concurrent use.
+func (gw *Gateway) UseOutbound(fn OutboundFn) {
+	gw.mwMu.Lock()
+	defer gw.mwMu.Unlock()
+	gw.outbound = append(gw.outbound, fn)
+}
+
+// reject publishes the error of a message rejected by a middleware function.
+func (gw *Gateway) reject(topic string, err error) {
+	if !errors.Is(err, ErrSkip) {
+		gw.errCh <- &errMsg{topic: topic, err: err}
+	}
+}
+
+// applyInbound applies the inbound middleware functions to a received message (topic without topic root).
+func (gw *Gateway) applyInbound(topic string, payload []byte) ([]byte, error) {
+	gw.mwMu.RLock()
+	defer gw.mwMu.RUnlock()
+	if len(gw.inbound) == 0 {
+		return payload, nil
+	}
+	topicStrs := topicSplit(topic)
+	for _, fn := range gw.inbound {
+		var err error
+		if payload, err = fn(topicStrs, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// applyOutbound applies the outbound middleware functions to a message to be published.
+func (gw *Gateway) applyOutbound(topicStrs []string, value any) (any, error) {
+	gw.mwMu.RLock()
+	defer gw.mwMu.RUnlock()
+	for _, fn := range gw.outbound {
+		var err error
+		if value, err = fn(topicStrs, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
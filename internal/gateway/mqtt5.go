@@ -0,0 +1,357 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// maxMessageExpiry is the maximum message expiry interval of published messages.
+const maxMessageExpiry = 24 * time.Hour
+
+func (c *Config) validateMQTT5() error {
+	if !c.MQTT5 {
+		if c.MessageExpiry != 0 || len(c.UserProperties) != 0 {
+			return fmt.Errorf("MQTTConfig: message expiry and user properties require MQTT 5 mode")
+		}
+		return nil
+	}
+	if c.Proxy != "" {
+		return fmt.Errorf("MQTTConfig: proxy is not supported in MQTT 5 mode")
+	}
+	if c.Profile != ProfileNone {
+		return fmt.Errorf("MQTTConfig: profile %s is not supported in MQTT 5 mode", c.Profile)
+	}
+	if c.SparkplugGroup != "" { // the will message cannot be renewed per connection
+		return fmt.Errorf("MQTTConfig: Sparkplug B mode is not supported in MQTT 5 mode")
+	}
+	if c.MessageExpiry < 0 || c.MessageExpiry > maxMessageExpiry {
+		return fmt.Errorf("MQTTConfig messageExpiry %s: out of range 0..%s", c.MessageExpiry, maxMessageExpiry)
+	}
+	return nil
+}
+
+// mqtt5Token is a token completed by the MQTT 5 client.
+type mqtt5Token struct {
+	done chan struct{}
+	err  error
+}
+
+func newMQTT5Token() *mqtt5Token { return &mqtt5Token{done: make(chan struct{})} }
+
+func (t *mqtt5Token) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *mqtt5Token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *mqtt5Token) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t *mqtt5Token) Done() <-chan struct{} { return t.done }
+func (t *mqtt5Token) Error() error          { return t.err }
+
+// mqtt5Message is a message received by the MQTT 5 client.
+type mqtt5Message struct{ p *paho.Publish }
+
+func (m *mqtt5Message) Duplicate() bool   { return false }
+func (m *mqtt5Message) Qos() byte         { return m.p.QoS }
+func (m *mqtt5Message) Retained() bool    { return m.p.Retain }
+func (m *mqtt5Message) Topic() string     { return m.p.Topic }
+func (m *mqtt5Message) MessageID() uint16 { return m.p.PacketID }
+func (m *mqtt5Message) Payload() []byte   { return m.p.Payload }
+func (m *mqtt5Message) Ack()              {}
+
+// responseTopic returns the response topic and the correlation data of a request message.
+func (m *mqtt5Message) responseTopic() (string, []byte) {
+	if m.p.Properties == nil {
+		return "", nil
+	}
+	return m.p.Properties.ResponseTopic, m.p.Properties.CorrelationData
+}
+
+type mqtt5Pub struct {
+	p     *paho.Publish
+	token *mqtt5Token
+}
+
+// mqtt5Client adapts a MQTT 5 connection (paho.golang) to the MQTT 3.1.1 client interface used
+// by the gateway, so that both protocol versions share the gateway implementation.
+type mqtt5Client struct {
+	config *Config
+	opts   *MQTT.ClientOptions
+	router *paho.StandardRouter
+	cm     *autopaho.ConnectionManager
+	cancel context.CancelFunc
+
+	connected atomic.Bool
+
+	mu   sync.Mutex
+	subs map[string]byte // subscriptions renewed on reconnect
+
+	pubMu     sync.RWMutex
+	pubClosed bool
+	pubCh     chan *mqtt5Pub // publish queue keeping the message order
+	pubDone   chan struct{}
+}
+
+func newMQTT5Client(config *Config, opts *MQTT.ClientOptions) *mqtt5Client {
+	return &mqtt5Client{
+		config:  config,
+		opts:    opts,
+		router:  paho.NewStandardRouter(),
+		subs:    map[string]byte{},
+		pubCh:   make(chan *mqtt5Pub, DefChanSize),
+		pubDone: make(chan struct{}),
+	}
+}
+
+func (c *mqtt5Client) IsConnected() bool      { return c.connected.Load() }
+func (c *mqtt5Client) IsConnectionOpen() bool { return c.connected.Load() }
+
+func (c *mqtt5Client) OptionsReader() MQTT.ClientOptionsReader { return MQTT.ClientOptionsReader{} }
+
+func (c *mqtt5Client) connectionLost(err error) {
+	if c.connected.Swap(false) && c.opts.OnConnectionLost != nil {
+		c.opts.OnConnectionLost(c, err)
+	}
+}
+
+// Connect connects to the broker. The connection is reestablished automatically on connection loss.
+func (c *mqtt5Client) Connect() MQTT.Token {
+	cfg := autopaho.ClientConfig{
+		BrokerUrls:        c.opts.Servers,
+		TlsCfg:            c.opts.TLSConfig,
+		KeepAlive:         uint16(c.opts.KeepAlive),
+		ConnectRetryDelay: c.opts.ConnectRetryInterval,
+		ConnectTimeout:    c.opts.ConnectTimeout,
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+			c.renewSubscriptions(cm)
+			c.connected.Store(true)
+			if c.opts.OnConnect != nil {
+				c.opts.OnConnect(c)
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:           c.opts.ClientID,
+			Router:             c.router,
+			OnClientError:      c.connectionLost,
+			OnServerDisconnect: func(d *paho.Disconnect) { c.connectionLost(fmt.Errorf("server disconnect reason %d", d.ReasonCode)) },
+		},
+	}
+	cfg.SetUsernamePassword(c.opts.Username, []byte(c.opts.Password))
+	if c.opts.WillEnabled {
+		cfg.SetWillMessage(c.opts.WillTopic, c.opts.WillPayload, c.opts.WillQos, c.opts.WillRetained)
+	}
+
+	token := newMQTT5Token()
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, cfg)
+	if err != nil {
+		cancel()
+		token.complete(err)
+		return token
+	}
+	c.cm, c.cancel = cm, cancel
+	go c.publish()
+
+	go func() {
+		connCtx, connCancel := context.WithTimeout(ctx, c.opts.ConnectTimeout)
+		defer connCancel()
+		if err := cm.AwaitConnection(connCtx); err != nil {
+			cancel()
+			token.complete(fmt.Errorf("connect to broker: %w", err))
+			return
+		}
+		token.complete(nil)
+	}()
+	return token
+}
+
+// Disconnect disconnects from the broker waiting quiesce milliseconds for pending messages.
+func (c *mqtt5Client) Disconnect(quiesce uint) {
+	if c.cm == nil {
+		return
+	}
+	c.pubMu.Lock()
+	c.pubClosed = true
+	close(c.pubCh)
+	c.pubMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+	defer cancel()
+	select {
+	case <-c.pubDone: // pending messages published
+	case <-ctx.Done():
+	}
+	c.cm.Disconnect(ctx)
+	c.cancel()
+	c.connected.Store(false)
+}
+
+// properties returns the publish properties of the configured MQTT 5 features.
+func (c *mqtt5Client) properties() *paho.PublishProperties {
+	props := &paho.PublishProperties{}
+	if c.config.MessageExpiry > 0 {
+		expiry := uint32(c.config.MessageExpiry / time.Second)
+		props.MessageExpiry = &expiry
+	}
+	for key, value := range c.config.UserProperties {
+		props.User.Add(key, value)
+	}
+	return props
+}
+
+func (c *mqtt5Client) enqueue(p *paho.Publish) MQTT.Token {
+	token := newMQTT5Token()
+	c.pubMu.RLock()
+	defer c.pubMu.RUnlock()
+	if c.pubClosed {
+		token.complete(MQTT.ErrNotConnected)
+		return token
+	}
+	c.pubCh <- &mqtt5Pub{p: p, token: token}
+	return token
+}
+
+func (c *mqtt5Client) publish() {
+	defer close(c.pubDone)
+	for pub := range c.pubCh {
+		_, err := c.cm.Publish(context.Background(), pub.p)
+		pub.token.complete(err)
+	}
+}
+
+// Publish publishes a message with the configured MQTT 5 properties.
+func (c *mqtt5Client) Publish(topic string, qos byte, retained bool, payload any) MQTT.Token {
+	var b []byte
+	switch payload := payload.(type) {
+	case []byte:
+		b = payload
+	case string:
+		b = []byte(payload)
+	default:
+		token := newMQTT5Token()
+		token.complete(fmt.Errorf("invalid payload type %T", payload))
+		return token
+	}
+	return c.enqueue(&paho.Publish{Topic: topic, QoS: qos, Retain: retained, Payload: b, Properties: c.properties()})
+}
+
+// publishResponse publishes the response of a request message to its response topic.
+func (c *mqtt5Client) publishResponse(topic string, correlation, payload []byte) MQTT.Token {
+	props := c.properties()
+	props.CorrelationData = correlation
+	return c.enqueue(&paho.Publish{Topic: topic, QoS: defaultQoS, Payload: payload, Properties: props})
+}
+
+func (c *mqtt5Client) handler(callback MQTT.MessageHandler) paho.MessageHandler {
+	if callback == nil {
+		callback = c.opts.DefaultPublishHandler
+	}
+	return func(p *paho.Publish) { callback(c, &mqtt5Message{p: p}) }
+}
+
+func subscribe5(cm *autopaho.ConnectionManager, subs map[string]byte) error {
+	s := &paho.Subscribe{Subscriptions: map[string]paho.SubscribeOptions{}}
+	for topic, qos := range subs {
+		s.Subscriptions[topic] = paho.SubscribeOptions{QoS: qos}
+	}
+	_, err := cm.Subscribe(context.Background(), s)
+	return err
+}
+
+func (c *mqtt5Client) renewSubscriptions(cm *autopaho.ConnectionManager) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.subs) == 0 {
+		return
+	}
+	if err := subscribe5(cm, c.subs); err != nil && c.opts.OnConnectionLost != nil {
+		c.opts.OnConnectionLost(c, fmt.Errorf("renew subscriptions: %w", err))
+	}
+}
+
+func (c *mqtt5Client) Subscribe(topic string, qos byte, callback MQTT.MessageHandler) MQTT.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *mqtt5Client) SubscribeMultiple(filters map[string]byte, callback MQTT.MessageHandler) MQTT.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, qos := range filters {
+		c.router.RegisterHandler(topic, c.handler(callback))
+		c.subs[topic] = qos
+	}
+	token := newMQTT5Token()
+	token.complete(subscribe5(c.cm, filters))
+	return token
+}
+
+func (c *mqtt5Client) Unsubscribe(topics ...string) MQTT.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		c.router.UnregisterHandler(topic)
+		delete(c.subs, topic)
+	}
+	token := newMQTT5Token()
+	_, err := c.cm.Unsubscribe(context.Background(), &paho.Unsubscribe{Topics: topics})
+	token.complete(err)
+	return token
+}
+
+func (c *mqtt5Client) AddRoute(topic string, callback MQTT.MessageHandler) {
+	c.router.RegisterHandler(topic, c.handler(callback))
+}
+
+// A responder is a received message requesting a response (MQTT 5 response topic).
+type responder interface {
+	responseTopic() (string, []byte)
+}
+
+// responseFn returns a function wrapping the handler functions of a request message, so that
+// the result is published to the response topic as well (nil if no response is requested).
+func (gw *Gateway) responseFn(msg MQTT.Message) func(HndFn) HndFn {
+	client, ok := gw.client.(*mqtt5Client)
+	if !ok {
+		return nil
+	}
+	r, ok := msg.(responder)
+	if !ok {
+		return nil
+	}
+	topic, correlation := r.responseTopic()
+	if topic == "" {
+		return nil
+	}
+	return func(fn HndFn) HndFn {
+		return func(payload any) (any, error) {
+			value, err := fn(payload)
+			var b []byte
+			if err != nil {
+				b, _ = marshal(&errPayload{Topic: msg.Topic(), Error: err.Error()})
+			} else {
+				b, _ = marshal(value)
+			}
+			gw.lg.Printf("publish response topic %s payload %s\n", topic, b)
+			client.publishResponse(topic, correlation, b) // do not wait for token
+			return value, err
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ClassProto is the first topic level (below the topic root) of the protocol buffer topic tree.
+const ClassProto = "pb"
+
+// protoPrefix is the topic prefix (without topic root) of the protocol buffer topic tree.
+const protoPrefix = ClassProto + sep
+
+// ProtoPayload represents the raw payload of a message received on the protocol buffer topic tree.
+type ProtoPayload []byte
+
+// A PayloadError is returned by typed handler functions if the payload cannot be decoded
+// into the expected payload type.
+type PayloadError struct {
+	Payload []byte
+	Type    string
+	Err     error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("invalid payload %s - %s expected", e.Payload, e.Type)
+}
+
+func (e *PayloadError) Unwrap() error { return e.Err }
+
+// marshal encodes a value published by the gateway whether as protocol buffer (proto.Message) or as JSON.
+func marshal(value any) ([]byte, error) {
+	if m, ok := value.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return json.Marshal(value)
+}
+
+// Decode decodes a payload into a value of type T. The payload is whether the raw JSON payload
+// of a message received by the broker (json.RawMessage), a value of type T or any other
+// value which can be marshalled to JSON (e.g. a value provided by Dispatch).
+func Decode[T any](payload any) (T, error) {
+	var v T
+	b, ok := payload.(json.RawMessage)
+	if !ok {
+		if v, ok := payload.(T); ok {
+			return v, nil
+		}
+		var err error
+		if b, err = json.Marshal(payload); err != nil {
+			return v, err
+		}
+	}
+	typ := reflect.TypeOf(&v).Elem()
+	if bytes.Equal(bytes.TrimSpace(b), null) && !nullable(typ) {
+		return v, &PayloadError{Payload: b, Type: jsonTypeName(typ)} // unmarshal would ignore null
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return v, &PayloadError{Payload: b, Type: jsonTypeName(typ), Err: err}
+	}
+	return v, nil
+}
+
+var null = []byte("null")
+
+func nullable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
+
+// jsonTypeName returns the JSON type name of a go type.
+func jsonTypeName(typ reflect.Type) string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		return "any"
+	}
+}
+
+// Typed returns a handler function decoding the payload into the expected payload type T
+// before calling fn. Payloads which cannot be decoded result in a *PayloadError.
+func Typed[T any](fn func(v T) (any, error)) HndFn {
+	hndFn := func(payload any) (any, error) {
+		if probe, ok := payload.(typeProbe); ok {
+			_, err := Decode[T](probe.payload)
+			var v T
+			return jsonTypeName(reflect.TypeOf(&v).Elem()), err
+		}
+		v, err := Decode[T](payload)
+		if err != nil {
+			return nil, err
+		}
+		return fn(v)
+	}
+	typedCode.LoadOrStore(reflect.ValueOf(hndFn).Pointer(), struct{}{})
+	return hndFn
+}
+
+// typeProbe is the payload explain passes to handler functions created by Typed. The handler
+// function decodes the probe payload and returns the expected payload type without calling
+// the typed function.
+type typeProbe struct {
+	payload any
+}
+
+// typedCode holds the code pointers of the handler functions created by Typed. The code
+// pointer is shared by all handler functions of the same instantiation, so that the
+// number of entries is bound by the number of instantiations.
+var typedCode sync.Map
+
+// probeType returns the payload type expected by fn and the error decoding payload into it.
+// ok is false if fn was not created by Typed (fn is not called in that case).
+func probeType(fn HndFn, payload any) (typ string, ok bool, err error) {
+	if _, ok := typedCode.Load(reflect.ValueOf(fn).Pointer()); !ok {
+		return "", false, nil
+	}
+	v, err := fn(typeProbe{payload: payload})
+	typ, _ = v.(string)
+	return typ, true, err
+}
+
+// TypedProto returns a handler function decoding a protocol buffer payload into a message
+// of type P before calling fn. Payloads which cannot be decoded result in a *PayloadError.
+func TypedProto[T any, P interface {
+	*T
+	proto.Message
+}](fn func(m P) (any, error)) HndFn {
+	return func(payload any) (any, error) {
+		m := P(new(T))
+		switch payload := payload.(type) {
+		case ProtoPayload:
+			if err := proto.Unmarshal(payload, m); err != nil {
+				return nil, &PayloadError{Payload: payload, Type: string(m.ProtoReflect().Descriptor().FullName()), Err: err}
+			}
+		case P:
+			m = payload
+		default:
+			return nil, fmt.Errorf("invalid payload type %T - %s expected", payload, m.ProtoReflect().Descriptor().FullName())
+		}
+		return fn(m)
+	}
+}
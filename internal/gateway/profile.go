@@ -0,0 +1,144 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compatibility profiles.
+const (
+	ProfileNone  = ""
+	ProfileAWS   = "aws"   // AWS IoT Core
+	ProfileAzure = "azure" // Azure IoT Hub
+)
+
+func checkProfile(profile string) error {
+	switch profile {
+	case ProfileNone, ProfileAWS, ProfileAzure:
+		return nil
+	default:
+		return fmt.Errorf("invalid profile %s", profile)
+	}
+}
+
+// A profile adapts the gateway to the connection and topic restrictions of a MQTT broker.
+type profile interface {
+	// brokerTopic maps a gateway topic (including topic root) to the broker topic.
+	brokerTopic(topic string) string
+	// gatewayTopic maps a broker topic to the gateway topic (false if the topic is no gateway topic).
+	gatewayTopic(topic string) (string, bool)
+	// subscription maps a gateway subscription topic to the broker subscription topic.
+	subscription(topic string) string
+	// retain returns true if the broker supports retained messages.
+	retain() bool
+}
+
+func newProfile(config *Config) profile {
+	switch config.Profile {
+	case ProfileAWS:
+		return awsProfile{}
+	case ProfileAzure:
+		return azureProfile{deviceID: config.ClientID}
+	default:
+		return defaultProfile{}
+	}
+}
+
+// defaultProfile is the profile of standard MQTT brokers.
+type defaultProfile struct{}
+
+func (defaultProfile) brokerTopic(topic string) string          { return topic }
+func (defaultProfile) gatewayTopic(topic string) (string, bool) { return topic, true }
+func (defaultProfile) subscription(topic string) string         { return topic }
+func (defaultProfile) retain() bool                             { return true }
+
+// awsProfile is the profile of AWS IoT Core (X.509 client certificate authentication, no retained messages).
+type awsProfile struct{ defaultProfile }
+
+func (awsProfile) retain() bool { return false }
+
+// azureProfile is the profile of Azure IoT Hub (SAS token authentication, no retained messages).
+// Azure IoT Hub does only allow the device-to-cloud topic devices/<device id>/messages/events/ and the
+// cloud-to-device topic devices/<device id>/messages/devicebound/, so that the gateway topic is
+// transferred as message property 'topic'.
+type azureProfile struct{ deviceID string }
+
+const azureTopicProperty = "topic"
+
+func (p azureProfile) prefix(dir string) string {
+	return "devices/" + p.deviceID + "/messages/" + dir + "/"
+}
+
+func (p azureProfile) brokerTopic(topic string) string {
+	return p.prefix("events") + url.Values{azureTopicProperty: {topic}}.Encode()
+}
+
+func (p azureProfile) gatewayTopic(topic string) (string, bool) {
+	prefix := p.prefix("devicebound")
+	if !strings.HasPrefix(topic, prefix) {
+		return "", false
+	}
+	props, err := url.ParseQuery(topic[len(prefix):])
+	if err != nil {
+		return "", false
+	}
+	topic = props.Get(azureTopicProperty)
+	return topic, topic != ""
+}
+
+func (p azureProfile) subscription(topic string) string { return p.prefix("devicebound") + multiLevel }
+func (azureProfile) retain() bool                       { return false }
+
+// azureAPIVersion is the Azure IoT Hub MQTT API version.
+const azureAPIVersion = "2021-04-12"
+
+// azureUsername returns the Azure IoT Hub MQTT username.
+func azureUsername(host, deviceID string) string {
+	return host + "/" + deviceID + "/?api-version=" + azureAPIVersion
+}
+
+// azureSASToken returns an Azure IoT Hub shared access signature token for a device.
+func azureSASToken(host, deviceID, key string, expiry time.Time) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid SAS key: %w", err)
+	}
+	resource := url.QueryEscape(host + "/devices/" + deviceID)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(resource + "\n" + se))
+	sig := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s", resource, sig, se), nil
+}
+
+// tlsConfig returns the TLS configuration of the broker connection.
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSCAFile != "" {
+		b, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", c.TLSCAFile)
+		}
+	}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
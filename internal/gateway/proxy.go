@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/net/proxy"
+)
+
+// Proxy URL schemes.
+const (
+	proxyHTTP   = "http"   // HTTP CONNECT
+	proxyHTTPS  = "https"  // HTTP CONNECT via TLS
+	proxySOCKS5 = "socks5" // SOCKS5
+)
+
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case proxyHTTP, proxyHTTPS, proxySOCKS5:
+	default:
+		return nil, fmt.Errorf("invalid proxy scheme %s (http, https or socks5)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %s - host missing", s)
+	}
+	return u, nil
+}
+
+// httpConnectDialer connects to addresses via a HTTP CONNECT proxy.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  *net.Dialer
+}
+
+func (d *httpConnectDialer) proxyAddr() string {
+	if d.proxyURL.Port() != "" {
+		return d.proxyURL.Host
+	}
+	if d.proxyURL.Scheme == proxyHTTPS {
+		return net.JoinHostPort(d.proxyURL.Hostname(), "443")
+	}
+	return net.JoinHostPort(d.proxyURL.Hostname(), "80")
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if d.proxyURL.Scheme == proxyHTTPS {
+		conn, err = tls.DialWithDialer(d.forward, network, d.proxyAddr(), &tls.Config{ServerName: d.proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = d.forward.Dial(network, d.proxyAddr())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the broker does not send data before the client, so that the reader does not buffer any payload
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", d.proxyURL.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// proxyDialer returns a dialer connecting via the proxy.
+func proxyDialer(proxyURL *url.URL, forward *net.Dialer) (proxy.Dialer, error) {
+	if proxyURL.Scheme == proxySOCKS5 {
+		return proxy.FromURL(proxyURL, forward)
+	}
+	return &httpConnectDialer{proxyURL: proxyURL, forward: forward}, nil
+}
+
+// proxyConnectionFn returns a MQTT client connection function connecting to the broker via the proxy.
+func proxyConnectionFn(proxyURL *url.URL) MQTT.OpenConnectionFunc {
+	return func(uri *url.URL, options MQTT.ClientOptions) (net.Conn, error) {
+		switch uri.Scheme {
+		case "ws", "wss":
+			return MQTT.NewWebsocket(uri.String(), options.TLSConfig, options.ConnectTimeout, options.HTTPHeaders, &MQTT.WebsocketOptions{Proxy: http.ProxyURL(proxyURL)})
+		}
+
+		dialer, err := proxyDialer(proxyURL, options.Dialer)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.Dial("tcp", uri.Host)
+		if err != nil {
+			return nil, err
+		}
+		switch uri.Scheme {
+		case "mqtt", "tcp":
+			return conn, nil
+		case "ssl", "tls", "mqtts":
+			tlsConfig := &tls.Config{}
+			if options.TLSConfig != nil {
+				tlsConfig = options.TLSConfig.Clone()
+			}
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = uri.Hostname()
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		default:
+			conn.Close()
+			return nil, fmt.Errorf("unsupported protocol %s", uri.Scheme)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package gateway
+
+import "sort"
+
+// snapshot topic.
+var (
+	snapshotTopicStrs = []string{"snapshot", "get"}
+	snapshotTopic     = topicJoin(snapshotTopicStrs)
+)
+
+// A snapshot holds the last retained message of each topic, so that clients can request the
+// current state from brokers not supporting retained messages (e.g. cloud brokers).
+// A snapshot is only accessed by the publish go routine.
+type snapshot map[string]*pubMsg
+
+func (s snapshot) add(msg *pubMsg) {
+	if msg.retain {
+		s[msg.topic] = msg
+	}
+}
+
+// msgs returns the snapshot messages sorted by topic.
+func (s snapshot) msgs() []*pubMsg {
+	msgs := make([]*pubMsg, 0, len(s))
+	for _, msg := range s {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].topic < msgs[j].topic })
+	return msgs
+}
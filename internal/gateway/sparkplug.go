@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+	"github.com/pico-cs/mqtt-gateway/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sparkplug B topic namespace and message types.
+const (
+	spNamespace = "spBv1.0"
+	spNBirth    = "NBIRTH"
+	spNData     = "NDATA"
+	spNDeath    = "NDEATH"
+	spNCmd      = "NCMD"
+)
+
+// Sparkplug B metric data types.
+const (
+	spInt64   = 4
+	spUInt64  = 8
+	spDouble  = 10
+	spBoolean = 11
+	spString  = 12
+)
+
+// Sparkplug B node metrics.
+const (
+	spBdSeq   = "bdSeq"
+	spRebirth = "Node Control/Rebirth"
+)
+
+const (
+	spQoS = 0
+	// spWillQoS is the QoS of the NDEATH message (Sparkplug B requires QoS 1).
+	spWillQoS = 1
+	// spRebirthDelay is the delay of a rebirth caused by new metrics collecting the metrics of an event burst (e.g. on startup).
+	spRebirthDelay = 500 * time.Millisecond
+)
+
+// sparkplug represents a Sparkplug B edge node publishing the retained gateway events as node metrics.
+type sparkplug struct {
+	lg          logger.Logger
+	group, node string
+	bdSeq       uint64
+
+	mu      sync.Mutex
+	client  MQTT.Client
+	seq     uint64
+	metrics map[string]*pb.Payload_Metric
+	rebirth *time.Timer
+}
+
+func newSparkplug(lg logger.Logger, group, node string) *sparkplug {
+	return &sparkplug{
+		lg:      lg,
+		group:   group,
+		node:    node,
+		bdSeq:   uint64(time.Now().Unix() % 256),
+		metrics: make(map[string]*pb.Payload_Metric),
+	}
+}
+
+func (sp *sparkplug) topic(typ string) string {
+	return topicJoinStr(spNamespace, sp.group, typ, sp.node)
+}
+
+func timestamp() *uint64 { return proto.Uint64(uint64(time.Now().UnixMilli())) }
+
+// bdSeqMetric returns the birth / death sequence number metric (sp.mu needs to be locked).
+func (sp *sparkplug) bdSeqMetric() *pb.Payload_Metric {
+	return &pb.Payload_Metric{Name: proto.String(spBdSeq), Datatype: proto.Uint32(spUInt64), Value: &pb.Payload_Metric_LongValue{LongValue: sp.bdSeq}}
+}
+
+// deathPayload returns the NDEATH payload registered as MQTT will message (sp.mu needs to be locked).
+func (sp *sparkplug) deathPayload() []byte {
+	b, err := proto.Marshal(&pb.Payload{Timestamp: timestamp(), Metrics: []*pb.Payload_Metric{sp.bdSeqMetric()}})
+	if err != nil {
+		panic(err) // cannot happen
+	}
+	return b
+}
+
+// newSession increments the birth / death sequence number for a new MQTT session and returns
+// the NDEATH payload to be registered as will message of the session.
+func (sp *sparkplug) newSession() []byte {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.bdSeq = (sp.bdSeq + 1) % 256
+	return sp.deathPayload()
+}
+
+// connected publishes the NBIRTH message and subscribes to the node commands after connecting to the broker.
+func (sp *sparkplug) connected(client MQTT.Client) {
+	sp.mu.Lock()
+	sp.client = client
+	sp.birth()
+	sp.mu.Unlock()
+
+	client.Subscribe(sp.topic(spNCmd), spQoS, sp.cmdHandler)
+}
+
+// close publishes the NDEATH message (the will message is not sent by the broker on a regular disconnect).
+func (sp *sparkplug) close() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.rebirth != nil {
+		sp.rebirth.Stop()
+	}
+	if sp.client != nil {
+		sp.client.Publish(sp.topic(spNDeath), spWillQoS, false, sp.deathPayload()).Wait()
+	}
+}
+
+func (sp *sparkplug) cmdHandler(client MQTT.Client, msg MQTT.Message) {
+	var payload pb.Payload
+	if err := proto.Unmarshal(msg.Payload(), &payload); err != nil {
+		sp.lg.Printf("sparkplug: invalid %s payload: %s", spNCmd, err)
+		return
+	}
+	for _, metric := range payload.Metrics {
+		if metric.GetName() == spRebirth && metric.GetBooleanValue() {
+			sp.mu.Lock()
+			sp.birth()
+			sp.mu.Unlock()
+		}
+	}
+}
+
+// publish publishes a payload with the next sequence number (sp.mu needs to be locked).
+func (sp *sparkplug) publish(typ string, payload *pb.Payload) {
+	if sp.client == nil {
+		return // not connected yet
+	}
+	payload.Timestamp = timestamp()
+	payload.Seq = proto.Uint64(sp.seq)
+	sp.seq = (sp.seq + 1) % 256
+	b, err := proto.Marshal(payload)
+	if err != nil {
+		sp.lg.Printf("sparkplug: %s: %s", typ, err)
+		return
+	}
+	sp.client.Publish(sp.topic(typ), spQoS, false, b) // fire-and-forget
+}
+
+// birth publishes the NBIRTH message containing all metrics (sp.mu needs to be locked).
+func (sp *sparkplug) birth() {
+	if sp.rebirth != nil {
+		sp.rebirth.Stop()
+		sp.rebirth = nil
+	}
+	names := make([]string, 0, len(sp.metrics))
+	for name := range sp.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]*pb.Payload_Metric, 0, len(names)+1)
+	metrics = append(metrics, sp.bdSeqMetric())
+	for _, name := range names {
+		metrics = append(metrics, sp.metrics[name])
+	}
+	sp.seq = 0
+	sp.publish(spNBirth, &pb.Payload{Metrics: metrics})
+}
+
+// update updates the metrics of a retained event. Changed metrics are published by NDATA
+// messages whereas new metrics trigger a (delayed) rebirth.
+func (sp *sparkplug) update(topicStrs []string, value any) {
+	if len(topicStrs) > 0 && topicStrs[0] == ClassProto {
+		return // same events as the JSON topic tree
+	}
+	if _, ok := value.(proto.Message); ok {
+		return
+	}
+	metrics := spMetrics(topicJoin(topicStrs), value, nil)
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var changed []*pb.Payload_Metric
+	for _, metric := range metrics {
+		prev, ok := sp.metrics[metric.GetName()]
+		sp.metrics[metric.GetName()] = metric
+		switch {
+		case !ok:
+			if sp.rebirth == nil {
+				sp.rebirth = time.AfterFunc(spRebirthDelay, func() {
+					sp.mu.Lock()
+					defer sp.mu.Unlock()
+					sp.birth()
+				})
+			}
+		case !proto.Equal(prev, metric):
+			changed = append(changed, metric)
+		}
+	}
+	if len(changed) > 0 {
+		sp.publish(spNData, &pb.Payload{Metrics: changed})
+	}
+}
+
+// spMetrics appends the metrics of an event value to metrics. Atomic values are mapped to a metric
+// named by the topic, objects are flattened (e.g. cs/<name>/latency/rtt) and arrays are ignored.
+func spMetrics(name string, value any, metrics []*pb.Payload_Metric) []*pb.Payload_Metric {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return metrics
+		}
+		v = v.Elem()
+	}
+
+	metric := &pb.Payload_Metric{Name: proto.String(name)}
+	switch v.Kind() {
+	case reflect.Bool:
+		metric.Datatype = proto.Uint32(spBoolean)
+		metric.Value = &pb.Payload_Metric_BooleanValue{BooleanValue: v.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		metric.Datatype = proto.Uint32(spInt64)
+		metric.Value = &pb.Payload_Metric_LongValue{LongValue: uint64(v.Int())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		metric.Datatype = proto.Uint32(spUInt64)
+		metric.Value = &pb.Payload_Metric_LongValue{LongValue: v.Uint()}
+	case reflect.Float32, reflect.Float64:
+		metric.Datatype = proto.Uint32(spDouble)
+		metric.Value = &pb.Payload_Metric_DoubleValue{DoubleValue: v.Float()}
+	case reflect.String:
+		metric.Datatype = proto.Uint32(spString)
+		metric.Value = &pb.Payload_Metric_StringValue{StringValue: v.String()}
+	case reflect.Struct, reflect.Map:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return metrics
+		}
+		var m map[string]any
+		if err := json.Unmarshal(b, &m); err != nil {
+			return metrics
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			metrics = spMetrics(topicJoinStr(name, k), m[k], metrics)
+		}
+		return metrics
+	default:
+		return metrics
+	}
+	return append(metrics, metric)
+}
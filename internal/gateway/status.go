@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Gateway availability states published on the status topic.
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// checkStatusTopic checks the levels of a status topic (without topic root).
+func checkStatusTopic(topic string) error {
+	for _, name := range topicSplit(topic) {
+		if err := CheckLevelName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusTopic returns the broker topic of the gateway status.
+func (gw *Gateway) statusTopic() string {
+	return gw.profile.brokerTopic(topicJoinStr(gw.topicRoot(), gw.config.StatusTopic))
+}
+
+// setStatusWill sets the offline status as last will, so that the broker publishes it on
+// an unexpected connection loss of the gateway.
+func (gw *Gateway) setStatusWill(opts *MQTT.ClientOptions) {
+	payload, _ := marshal(StatusOffline)
+	opts.SetBinaryWill(gw.statusTopic(), payload, defaultQoS, gw.profile.retain())
+}
+
+// publishStatus publishes the gateway availability status.
+func (gw *Gateway) publishStatus(client MQTT.Client, status string) {
+	payload, _ := marshal(status)
+	gw.lg.Printf("publish topic %s retain %t value %s\n", gw.statusTopic(), gw.profile.retain(), status)
+	if token := client.Publish(gw.statusTopic(), defaultQoS, gw.profile.retain(), payload); token.Wait() && token.Error() != nil {
+		gw.lg.Printf("publish status %s: %s", status, token.Error())
+	}
+}
+
+func (c *Config) validateStatusTopic() error {
+	if c.StatusTopic == "" {
+		return nil
+	}
+	if err := checkStatusTopic(c.StatusTopic); err != nil {
+		return fmt.Errorf("MQTTConfig statusTopic %s: %s", c.StatusTopic, err)
+	}
+	if c.SparkplugGroup != "" {
+		return fmt.Errorf("MQTTConfig statusTopic: last will is used by Sparkplug B mode")
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package pb provides the protocol buffer messages of the gateway generated from proto/gateway.proto
+// and the Sparkplug B payload generated from proto/sparkplug_b.proto.
+package pb
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=paths=source_relative gateway.proto sparkplug_b.proto
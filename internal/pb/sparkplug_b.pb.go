@@ -0,0 +1,449 @@
+// Sparkplug B payload (subset of the Eclipse Tahu sparkplug_b.proto used by the gateway).
+//
+// The field numbers are identical to the Sparkplug B specification, so that the messages
+// are wire compatible - unsupported fields (metric metadata, properties, datasets and
+// templates) are omitted.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: sparkplug_b.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Payload struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Timestamp *uint64           `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Metrics   []*Payload_Metric `protobuf:"bytes,2,rep,name=metrics" json:"metrics,omitempty"`
+	Seq       *uint64           `protobuf:"varint,3,opt,name=seq" json:"seq,omitempty"`
+	Uuid      *string           `protobuf:"bytes,4,opt,name=uuid" json:"uuid,omitempty"`
+	Body      []byte            `protobuf:"bytes,5,opt,name=body" json:"body,omitempty"`
+}
+
+func (x *Payload) Reset() {
+	*x = Payload{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sparkplug_b_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Payload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Payload) ProtoMessage() {}
+
+func (x *Payload) ProtoReflect() protoreflect.Message {
+	mi := &file_sparkplug_b_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Payload.ProtoReflect.Descriptor instead.
+func (*Payload) Descriptor() ([]byte, []int) {
+	return file_sparkplug_b_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Payload) GetTimestamp() uint64 {
+	if x != nil && x.Timestamp != nil {
+		return *x.Timestamp
+	}
+	return 0
+}
+
+func (x *Payload) GetMetrics() []*Payload_Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *Payload) GetSeq() uint64 {
+	if x != nil && x.Seq != nil {
+		return *x.Seq
+	}
+	return 0
+}
+
+func (x *Payload) GetUuid() string {
+	if x != nil && x.Uuid != nil {
+		return *x.Uuid
+	}
+	return ""
+}
+
+func (x *Payload) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type Payload_Metric struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name         *string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Alias        *uint64 `protobuf:"varint,2,opt,name=alias" json:"alias,omitempty"`
+	Timestamp    *uint64 `protobuf:"varint,3,opt,name=timestamp" json:"timestamp,omitempty"`
+	Datatype     *uint32 `protobuf:"varint,4,opt,name=datatype" json:"datatype,omitempty"`
+	IsHistorical *bool   `protobuf:"varint,5,opt,name=is_historical,json=isHistorical" json:"is_historical,omitempty"`
+	IsTransient  *bool   `protobuf:"varint,6,opt,name=is_transient,json=isTransient" json:"is_transient,omitempty"`
+	IsNull       *bool   `protobuf:"varint,7,opt,name=is_null,json=isNull" json:"is_null,omitempty"`
+	// Types that are assignable to Value:
+	//	*Payload_Metric_IntValue
+	//	*Payload_Metric_LongValue
+	//	*Payload_Metric_FloatValue
+	//	*Payload_Metric_DoubleValue
+	//	*Payload_Metric_BooleanValue
+	//	*Payload_Metric_StringValue
+	//	*Payload_Metric_BytesValue
+	Value isPayload_Metric_Value `protobuf_oneof:"value"`
+}
+
+func (x *Payload_Metric) Reset() {
+	*x = Payload_Metric{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sparkplug_b_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Payload_Metric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Payload_Metric) ProtoMessage() {}
+
+func (x *Payload_Metric) ProtoReflect() protoreflect.Message {
+	mi := &file_sparkplug_b_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Payload_Metric.ProtoReflect.Descriptor instead.
+func (*Payload_Metric) Descriptor() ([]byte, []int) {
+	return file_sparkplug_b_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *Payload_Metric) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+func (x *Payload_Metric) GetAlias() uint64 {
+	if x != nil && x.Alias != nil {
+		return *x.Alias
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetTimestamp() uint64 {
+	if x != nil && x.Timestamp != nil {
+		return *x.Timestamp
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetDatatype() uint32 {
+	if x != nil && x.Datatype != nil {
+		return *x.Datatype
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetIsHistorical() bool {
+	if x != nil && x.IsHistorical != nil {
+		return *x.IsHistorical
+	}
+	return false
+}
+
+func (x *Payload_Metric) GetIsTransient() bool {
+	if x != nil && x.IsTransient != nil {
+		return *x.IsTransient
+	}
+	return false
+}
+
+func (x *Payload_Metric) GetIsNull() bool {
+	if x != nil && x.IsNull != nil {
+		return *x.IsNull
+	}
+	return false
+}
+
+func (m *Payload_Metric) GetValue() isPayload_Metric_Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (x *Payload_Metric) GetIntValue() uint32 {
+	if x, ok := x.GetValue().(*Payload_Metric_IntValue); ok {
+		return x.IntValue
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetLongValue() uint64 {
+	if x, ok := x.GetValue().(*Payload_Metric_LongValue); ok {
+		return x.LongValue
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetFloatValue() float32 {
+	if x, ok := x.GetValue().(*Payload_Metric_FloatValue); ok {
+		return x.FloatValue
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetDoubleValue() float64 {
+	if x, ok := x.GetValue().(*Payload_Metric_DoubleValue); ok {
+		return x.DoubleValue
+	}
+	return 0
+}
+
+func (x *Payload_Metric) GetBooleanValue() bool {
+	if x, ok := x.GetValue().(*Payload_Metric_BooleanValue); ok {
+		return x.BooleanValue
+	}
+	return false
+}
+
+func (x *Payload_Metric) GetStringValue() string {
+	if x, ok := x.GetValue().(*Payload_Metric_StringValue); ok {
+		return x.StringValue
+	}
+	return ""
+}
+
+func (x *Payload_Metric) GetBytesValue() []byte {
+	if x, ok := x.GetValue().(*Payload_Metric_BytesValue); ok {
+		return x.BytesValue
+	}
+	return nil
+}
+
+type isPayload_Metric_Value interface {
+	isPayload_Metric_Value()
+}
+
+type Payload_Metric_IntValue struct {
+	IntValue uint32 `protobuf:"varint,10,opt,name=int_value,json=intValue,oneof"`
+}
+
+type Payload_Metric_LongValue struct {
+	LongValue uint64 `protobuf:"varint,11,opt,name=long_value,json=longValue,oneof"`
+}
+
+type Payload_Metric_FloatValue struct {
+	FloatValue float32 `protobuf:"fixed32,12,opt,name=float_value,json=floatValue,oneof"`
+}
+
+type Payload_Metric_DoubleValue struct {
+	DoubleValue float64 `protobuf:"fixed64,13,opt,name=double_value,json=doubleValue,oneof"`
+}
+
+type Payload_Metric_BooleanValue struct {
+	BooleanValue bool `protobuf:"varint,14,opt,name=boolean_value,json=booleanValue,oneof"`
+}
+
+type Payload_Metric_StringValue struct {
+	StringValue string `protobuf:"bytes,15,opt,name=string_value,json=stringValue,oneof"`
+}
+
+type Payload_Metric_BytesValue struct {
+	BytesValue []byte `protobuf:"bytes,16,opt,name=bytes_value,json=bytesValue,oneof"`
+}
+
+func (*Payload_Metric_IntValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_LongValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_FloatValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_DoubleValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_BooleanValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_StringValue) isPayload_Metric_Value() {}
+
+func (*Payload_Metric_BytesValue) isPayload_Metric_Value() {}
+
+var File_sparkplug_b_proto protoreflect.FileDescriptor
+
+var file_sparkplug_b_proto_rawDesc = []byte{
+	0x0a, 0x11, 0x73, 0x70, 0x61, 0x72, 0x6b, 0x70, 0x6c, 0x75, 0x67, 0x5f, 0x62, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x19, 0x6f, 0x72, 0x67, 0x2e, 0x65, 0x63, 0x6c, 0x69, 0x70, 0x73, 0x65,
+	0x2e, 0x74, 0x61, 0x68, 0x75, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x22, 0xf6,
+	0x04, 0x0a, 0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x43, 0x0a, 0x07, 0x6d, 0x65, 0x74, 0x72,
+	0x69, 0x63, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x29, 0x2e, 0x6f, 0x72, 0x67, 0x2e,
+	0x65, 0x63, 0x6c, 0x69, 0x70, 0x73, 0x65, 0x2e, 0x74, 0x61, 0x68, 0x75, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x4d, 0x65,
+	0x74, 0x72, 0x69, 0x63, 0x52, 0x07, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x12, 0x10, 0x0a,
+	0x03, 0x73, 0x65, 0x71, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71, 0x12,
+	0x12, 0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75,
+	0x75, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x1a, 0xcd, 0x03, 0x0a, 0x06, 0x4d, 0x65, 0x74, 0x72,
+	0x69, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x12, 0x1c, 0x0a, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x61,
+	0x74, 0x61, 0x74, 0x79, 0x70, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x64, 0x61,
+	0x74, 0x61, 0x74, 0x79, 0x70, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x69, 0x73, 0x5f, 0x68, 0x69, 0x73,
+	0x74, 0x6f, 0x72, 0x69, 0x63, 0x61, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x69,
+	0x73, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x69, 0x63, 0x61, 0x6c, 0x12, 0x21, 0x0a, 0x0c, 0x69,
+	0x73, 0x5f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x69, 0x65, 0x6e, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x69, 0x73, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x69, 0x65, 0x6e, 0x74, 0x12, 0x17,
+	0x0a, 0x07, 0x69, 0x73, 0x5f, 0x6e, 0x75, 0x6c, 0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x06, 0x69, 0x73, 0x4e, 0x75, 0x6c, 0x6c, 0x12, 0x1d, 0x0a, 0x09, 0x69, 0x6e, 0x74, 0x5f, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x00, 0x52, 0x08, 0x69, 0x6e,
+	0x74, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1f, 0x0a, 0x0a, 0x6c, 0x6f, 0x6e, 0x67, 0x5f, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x04, 0x48, 0x00, 0x52, 0x09, 0x6c, 0x6f,
+	0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x21, 0x0a, 0x0b, 0x66, 0x6c, 0x6f, 0x61, 0x74,
+	0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x02, 0x48, 0x00, 0x52, 0x0a,
+	0x66, 0x6c, 0x6f, 0x61, 0x74, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x23, 0x0a, 0x0c, 0x64, 0x6f,
+	0x75, 0x62, 0x6c, 0x65, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x01,
+	0x48, 0x00, 0x52, 0x0b, 0x64, 0x6f, 0x75, 0x62, 0x6c, 0x65, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12,
+	0x25, 0x0a, 0x0d, 0x62, 0x6f, 0x6f, 0x6c, 0x65, 0x61, 0x6e, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x0e, 0x20, 0x01, 0x28, 0x08, 0x48, 0x00, 0x52, 0x0c, 0x62, 0x6f, 0x6f, 0x6c, 0x65, 0x61,
+	0x6e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x23, 0x0a, 0x0c, 0x73, 0x74, 0x72, 0x69, 0x6e, 0x67,
+	0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x0b,
+	0x73, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x21, 0x0a, 0x0b, 0x62,
+	0x79, 0x74, 0x65, 0x73, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0c,
+	0x48, 0x00, 0x52, 0x0a, 0x62, 0x79, 0x74, 0x65, 0x73, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x42, 0x07,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x42, 0x2d, 0x5a, 0x2b, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x69, 0x63, 0x6f, 0x2d, 0x63, 0x73, 0x2f, 0x6d, 0x71,
+	0x74, 0x74, 0x2d, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62,
+}
+
+var (
+	file_sparkplug_b_proto_rawDescOnce sync.Once
+	file_sparkplug_b_proto_rawDescData = file_sparkplug_b_proto_rawDesc
+)
+
+func file_sparkplug_b_proto_rawDescGZIP() []byte {
+	file_sparkplug_b_proto_rawDescOnce.Do(func() {
+		file_sparkplug_b_proto_rawDescData = protoimpl.X.CompressGZIP(file_sparkplug_b_proto_rawDescData)
+	})
+	return file_sparkplug_b_proto_rawDescData
+}
+
+var file_sparkplug_b_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_sparkplug_b_proto_goTypes = []interface{}{
+	(*Payload)(nil),        // 0: org.eclipse.tahu.protobuf.Payload
+	(*Payload_Metric)(nil), // 1: org.eclipse.tahu.protobuf.Payload.Metric
+}
+var file_sparkplug_b_proto_depIdxs = []int32{
+	1, // 0: org.eclipse.tahu.protobuf.Payload.metrics:type_name -> org.eclipse.tahu.protobuf.Payload.Metric
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_sparkplug_b_proto_init() }
+func file_sparkplug_b_proto_init() {
+	if File_sparkplug_b_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_sparkplug_b_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Payload); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sparkplug_b_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Payload_Metric); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_sparkplug_b_proto_msgTypes[1].OneofWrappers = []interface{}{
+		(*Payload_Metric_IntValue)(nil),
+		(*Payload_Metric_LongValue)(nil),
+		(*Payload_Metric_FloatValue)(nil),
+		(*Payload_Metric_DoubleValue)(nil),
+		(*Payload_Metric_BooleanValue)(nil),
+		(*Payload_Metric_StringValue)(nil),
+		(*Payload_Metric_BytesValue)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_sparkplug_b_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_sparkplug_b_proto_goTypes,
+		DependencyIndexes: file_sparkplug_b_proto_depIdxs,
+		MessageInfos:      file_sparkplug_b_proto_msgTypes,
+	}.Build()
+	File_sparkplug_b_proto = out.File
+	file_sparkplug_b_proto_rawDesc = nil
+	file_sparkplug_b_proto_goTypes = nil
+	file_sparkplug_b_proto_depIdxs = nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// A statusRecorder records the status code and the number of bytes written by a handler.
+// It keeps the streaming (http.Flusher) and connection takeover (http.Hijacker) capabilities
+// of the wrapped response writer needed by Server-Sent Events and WebSocket clients.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer %T does not support hijacking", r.ResponseWriter)
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// accessLogHandler returns a handler logging method, path, status, duration, response size and
+// remote address of each request in key=value format (the query is not logged as it might contain
+// the API token).
+func accessLogHandler(lg logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		lg.Printf("http method=%s path=%q status=%d duration=%s bytes=%d remote=%s", r.Method, r.URL.Path, rec.status, time.Since(start), rec.bytes, r.RemoteAddr)
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// tokenParam is the query parameter of the API token for clients not able to set the authorization
+// header (e.g. browser WebSocket or EventSource clients).
+const tokenParam = "token"
+
+const bearerPrefix = "Bearer "
+
+// authEnabled returns true if basic or API token authentication is configured.
+func (c *Config) authEnabled() bool { return c.Username != "" || c.Token != "" }
+
+func equal(s1, s2 string) bool { return subtle.ConstantTimeCompare([]byte(s1), []byte(s2)) == 1 }
+
+// authorized returns true if the request provides the configured basic authentication credentials
+// or the API token (authorization header 'Bearer <token>' or query parameter token).
+func (c *Config) authorized(r *http.Request) bool {
+	if c.Username != "" {
+		if username, password, ok := r.BasicAuth(); ok && equal(username, c.Username) && equal(password, c.Password) {
+			return true
+		}
+	}
+	if c.Token != "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearerPrefix) && equal(strings.TrimPrefix(auth, bearerPrefix), c.Token) {
+			return true
+		}
+		if token := r.URL.Query().Get(tokenParam); token != "" && equal(token, c.Token) {
+			return true
+		}
+	}
+	return false
+}
+
+// authHandler returns a handler authenticating the requests before calling next (requests
+// of public paths are not authenticated).
+func (c *Config) authHandler(public map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !public[r.URL.Path] && !c.authorized(r) {
+			if c.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pico-cs", charset="UTF-8"`)
+			}
+			Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
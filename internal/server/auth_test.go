@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	config := &Config{Username: "admin", Password: "secret", Token: "t0ken"}
+	handler := config.authHandler(map[string]bool{"/healthz": true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name  string
+		path  string
+		setup func(r *http.Request)
+		code  int
+	}{
+		{"public path", "/healthz", func(r *http.Request) {}, http.StatusNoContent},
+		{"no credentials", "/state", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"basic", "/state", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusNoContent},
+		{"basic invalid password", "/state", func(r *http.Request) { r.SetBasicAuth("admin", "secre") }, http.StatusUnauthorized},
+		{"bearer", "/state", func(r *http.Request) { r.Header.Set("Authorization", "Bearer t0ken") }, http.StatusNoContent},
+		{"bearer invalid token", "/state", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusUnauthorized},
+		{"query token", "/state?token=t0ken", func(r *http.Request) {}, http.StatusNoContent},
+		{"query invalid token", "/state?token=", func(r *http.Request) {}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		test.setup(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Fatalf("%s: status code %d - expected %d", test.name, w.Code, test.code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: authenticate header missing", test.name)
+		}
+	}
+}
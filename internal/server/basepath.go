@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type basePathKey struct{}
+
+// BasePath returns the base path the server is served at (e.g. /pico-cs behind a reverse proxy) - empty
+// if no base path is configured. Handlers use it to generate links.
+func BasePath(r *http.Request) string {
+	basePath, _ := r.Context().Value(basePathKey{}).(string)
+	return basePath
+}
+
+func validateBasePath(basePath string) error {
+	if basePath == "" {
+		return nil
+	}
+	if !strings.HasPrefix(basePath, "/") || strings.HasSuffix(basePath, "/") {
+		return fmt.Errorf("base path %s: needs to start with / and must not end with /", basePath)
+	}
+	return nil
+}
+
+// basePathHandler returns a handler stripping the base path from the request path before calling next,
+// so that the handlers are registered independent of the base path. Requests outside of the base path
+// are rejected.
+func basePathHandler(basePath string, next http.Handler) http.Handler {
+	strip := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			strip.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath)))
+		default:
+			NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	for _, basePath := range []string{"pico-cs", "/pico-cs/"} {
+		if err := validateBasePath(basePath); err == nil {
+			t.Fatalf("invalid base path %s not detected", basePath)
+		}
+	}
+
+	handler := basePathHandler("/pico-cs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(BasePath(r) + " " + r.URL.Path))
+	}))
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/pico-cs/loco/br01", http.StatusOK, "/pico-cs /loco/br01"},
+		{"/pico-cs/", http.StatusOK, "/pico-cs /"},
+		{"/pico-cs", http.StatusMovedPermanently, ""},
+		{"/pico-csx/loco/br01", http.StatusNotFound, ""},
+		{"/loco/br01", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.code {
+			t.Fatalf("path %s: status code %d - expected %d", test.path, w.Code, test.code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Fatalf("path %s: body %q - expected %q", test.path, w.Body.String(), test.body)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"fmt"
 	"net"
+	"os"
 )
 
 // Default values.
@@ -16,6 +18,21 @@ type Config struct {
 	Host string
 	// HTTP Gateway port
 	Port string
+	// basic authentication user name (empty: no basic authentication)
+	Username string
+	// basic authentication password
+	Password string
+	// API token (empty: no API token authentication)
+	Token string
+	// directory of static files served at / (e.g. custom control panels - empty: index page)
+	WebRoot string
+	// path prefix of all endpoints (e.g. /pico-cs for reverse proxy path based routing - empty: none)
+	BasePath string
+	// log each request (method, path, status, duration, response size and remote address)
+	AccessLog bool
+	// origin hosts (host[:port]) allowed to send state changing requests and to connect via WebSocket
+	// besides the gateway host (e.g. web UIs served by another host)
+	Origins []string
 }
 
 func (c *Config) port() string {
@@ -25,4 +42,22 @@ func (c *Config) port() string {
 	return c.Port
 }
 
+// Validate validates the http configuration.
+func (c *Config) Validate() error {
+	if err := validateBasePath(c.BasePath); err != nil {
+		return err
+	}
+	if c.WebRoot == "" {
+		return nil
+	}
+	fi, err := os.Stat(c.WebRoot)
+	if err != nil {
+		return fmt.Errorf("web root: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("web root %s: not a directory", c.WebRoot)
+	}
+	return nil
+}
+
 func (c *Config) addr() string { return net.JoinHostPort(c.Host, c.port()) }
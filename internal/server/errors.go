@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// An ErrorDoc represents the JSON error response of the HTTP endpoints.
+type ErrorDoc struct {
+	// HTTP status code
+	Code int `json:"code"`
+	// HTTP status text
+	Message string `json:"message"`
+	// error details (e.g. the validation error of a request)
+	Detail string `json:"detail,omitempty"`
+}
+
+// Error replies to the request with the HTTP status code and a JSON error document like http.Error.
+func Error(w http.ResponseWriter, detail string, code int) {
+	h := w.Header()
+	h.Del("Content-Length") // might be set by the handler before the error occurred
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&ErrorDoc{Code: code, Message: http.StatusText(code), Detail: detail})
+}
+
+// NotFound replies to the request with an HTTP 404 not found error.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, fmt.Sprintf("%s not found", r.URL.Path), http.StatusNotFound)
+}
+
+// MethodNotAllowed replies to the request with an HTTP 405 method not allowed error listing
+// the allowed method.
+func MethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	Error(w, fmt.Sprintf("allowed method: %s", allowed), http.StatusMethodNotAllowed)
+}
+
+// notFoundHandler returns a handler replying a JSON not found error for the paths without
+// registered handler instead of the plain text error of the multiplexer.
+func notFoundHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			NotFound(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
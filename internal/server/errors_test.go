@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loco", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			MethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Length", "42")
+		Error(w, "loco br01: invalid speed", http.StatusBadRequest)
+	})
+	handler := notFoundHandler(mux)
+
+	tests := []struct {
+		method, path string
+		doc          ErrorDoc
+	}{
+		{http.MethodGet, "/loco", ErrorDoc{Code: http.StatusBadRequest, Message: "Bad Request", Detail: "loco br01: invalid speed"}},
+		{http.MethodPost, "/loco", ErrorDoc{Code: http.StatusMethodNotAllowed, Message: "Method Not Allowed", Detail: "allowed method: GET"}},
+		{http.MethodGet, "/cs", ErrorDoc{Code: http.StatusNotFound, Message: "Not Found", Detail: "/cs not found"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.doc.Code {
+			t.Fatalf("%s %s: status code %d - expected %d", test.method, test.path, w.Code, test.doc.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("%s %s: content type %s - expected application/json", test.method, test.path, contentType)
+		}
+		if w.Header().Get("Content-Length") != "" {
+			t.Fatalf("%s %s: content length of the handler not removed", test.method, test.path)
+		}
+		var doc ErrorDoc
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(doc, test.doc) {
+			t.Fatalf("%s %s: error document %+v - expected %+v", test.method, test.path, doc, test.doc)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// mDNS service types.
+const (
+	ServiceHTTP = "_http._tcp"
+)
+
+// DefaultInstance is the default mDNS service instance name.
+const DefaultInstance = "pico-cs gateway"
+
+const mdnsDomain = "local."
+
+// An Advertiser advertises services on the LAN via mDNS service records (zeroconf), so that
+// clients like throttle apps can find the gateway without typing IP addresses.
+type Advertiser struct {
+	lg       logger.Logger
+	instance string
+	servers  []*zeroconf.Server
+}
+
+// NewAdvertiser returns a new mDNS advertiser registering services with the instance name.
+func NewAdvertiser(lg logger.Logger, instance string) *Advertiser {
+	if instance == "" {
+		instance = DefaultInstance
+	}
+	return &Advertiser{lg: lg, instance: instance}
+}
+
+// Advertise registers a service (e.g. ServiceHTTP) listening on addr with the TXT records txt.
+func (a *Advertiser) Advertise(service, addr string, txt []string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		a.lg.Printf("mDNS: service %s listens on loopback address %s - not reachable on the LAN", service, addr)
+	}
+	server, err := zeroconf.Register(a.instance, service, mdnsDomain, port, txt, nil)
+	if err != nil {
+		return err
+	}
+	a.lg.Printf("mDNS: advertise service %s instance %s port %d", service, a.instance, port)
+	a.servers = append(a.servers, server)
+	return nil
+}
+
+// AdvertiseHTTP registers the HTTP API listening on addr with the TXT records txt.
+func (a *Advertiser) AdvertiseHTTP(addr string, txt []string) error {
+	return a.Advertise(ServiceHTTP, addr, txt)
+}
+
+// Close unregisters all advertised services.
+func (a *Advertiser) Close() error {
+	for _, server := range a.servers {
+		server.Shutdown()
+	}
+	a.servers = nil
+	return nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// allowedOrigin returns true for requests without origin header (non browser clients), from the same
+// origin or from one of the configured origin hosts.
+func (c *Config) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if host == strings.ToLower(r.Host) {
+		return true
+	}
+	for _, allowed := range c.Origins {
+		if host == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethod returns true for HTTP methods not changing the gateway state.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// originHandler returns a handler rejecting state changing requests (e.g. POST) of not allowed origins
+// before calling next, so that foreign web pages cannot control the devices via the browser of a user.
+func (c *Config) originHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !safeMethod(r.Method) && !c.allowedOrigin(r) {
+			Error(w, "origin "+r.Header.Get("Origin")+" not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireJSON replies to the request with an HTTP 415 unsupported media type error and returns false if
+// the request content type is not application/json. Requiring the JSON content type lets browsers send a
+// CORS preflight request for cross origin requests instead of executing them directly.
+func RequireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		Error(w, "content type application/json expected", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOrigin(t *testing.T) {
+	config := &Config{Origins: []string{"panel.example.com", "localhost:8080"}}
+	handler := config.originHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && !RequireJSON(w, r) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method      string
+		origin      string
+		contentType string
+		code        int
+	}{
+		{http.MethodPost, "", "application/json", http.StatusNoContent},                         // no browser
+		{http.MethodPost, "http://gateway:50000", "application/json", http.StatusNoContent},     // same origin
+		{http.MethodPost, "http://Panel.example.com", "application/json", http.StatusNoContent}, // allowed origin
+		{http.MethodPut, "http://localhost:8080", "application/json; charset=utf-8", http.StatusNoContent},
+		{http.MethodPost, "http://evil.example.com", "application/json", http.StatusForbidden},
+		{http.MethodDelete, "http://localhost:8081", "application/json", http.StatusForbidden},
+		{http.MethodGet, "http://evil.example.com", "", http.StatusNoContent}, // not state changing
+		{http.MethodPost, "", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "http://gateway:50000/loco/br01/speed", strings.NewReader("42"))
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Fatalf("%s origin %q content type %q: status %d - expected %d", test.method, test.origin, test.contentType, w.Code, test.code)
+		}
+	}
+}
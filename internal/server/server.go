@@ -15,19 +15,51 @@ type Server struct {
 	addr           string
 	*http.ServeMux // embedd (provides Handle and HandleFunc)
 	svr            *http.Server
+	public         map[string]bool // paths accessible without authentication
 }
 
 // New returns a new server instance.
 func New(lg logger.Logger, config *Config) *Server {
 	mux := &http.ServeMux{}
 	addr := config.addr()
-	return &Server{
+	s := &Server{
 		lg:       lg,
 		config:   config,
 		addr:     addr,
 		ServeMux: mux,
-		svr:      &http.Server{Addr: addr, Handler: mux},
+		public:   map[string]bool{},
 	}
+	if config.WebRoot != "" {
+		mux.Handle("/", http.FileServer(http.Dir(config.WebRoot)))
+	}
+	handler := config.originHandler(notFoundHandler(mux))
+	if config.authEnabled() {
+		handler = config.authHandler(s.public, handler)
+	}
+	if config.BasePath != "" {
+		handler = basePathHandler(config.BasePath, handler)
+	}
+	if config.AccessLog {
+		handler = accessLogHandler(lg, handler) // log rejected requests as well
+	}
+	s.svr = &http.Server{Addr: addr, Handler: handler}
+	return s
+}
+
+// HandleIndexFunc registers the handler function of the index page at /. The handler function is
+// not registered if a web root directory is configured, which is served at / instead.
+func (s *Server) HandleIndexFunc(handler func(http.ResponseWriter, *http.Request)) {
+	if s.config.WebRoot != "" {
+		return
+	}
+	s.HandleFunc("/", handler)
+}
+
+// HandlePublicFunc registers the handler function for path like HandleFunc. The path is accessible
+// without authentication (e.g. health checks).
+func (s *Server) HandlePublicFunc(path string, handler func(http.ResponseWriter, *http.Request)) {
+	s.public[path] = true
+	s.HandleFunc(path, handler)
 }
 
 // Addr returns the server address.
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// sseKeepAlive is the interval of the keep-alive comments sent to idle Server-Sent Events clients.
+const sseKeepAlive = 30 * time.Second
+
+// An SSEHub streams events to the connected Server-Sent Events clients.
+type SSEHub struct {
+	lg   logger.Logger
+	done chan struct{}
+
+	mu      sync.Mutex
+	clients map[*sseClient]struct{}
+}
+
+// NewSSEHub returns a new Server-Sent Events hub instance.
+func NewSSEHub(lg logger.Logger) *SSEHub {
+	return &SSEHub{lg: lg, done: make(chan struct{}), clients: map[*sseClient]struct{}{}}
+}
+
+// Close ends all event streams, so that the server can be shut down.
+func (h *SSEHub) Close() error {
+	close(h.done)
+	return nil
+}
+
+type sseClient struct {
+	prefixes []string // topic prefixes (empty: all events)
+	sendCh   chan *Event
+}
+
+// match returns true if the topic matches one of the topic prefixes of the client.
+func (c *sseClient) match(topic string) bool {
+	if len(c.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if topic == prefix || strings.HasPrefix(topic, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends an event to all connected clients the event is matching (does not block - events
+// are dropped for clients not keeping up).
+func (h *SSEHub) Publish(topic string, value any) {
+	event := &Event{Topic: topic, Value: value}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if !c.match(topic) {
+			continue
+		}
+		select {
+		case c.sendCh <- event:
+		default:
+			h.lg.Printf("event stream: queue full - event %s dropped", topic)
+		}
+	}
+}
+
+func (h *SSEHub) add(c *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+func (h *SSEHub) remove(c *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// ssePrefixes returns the topic prefixes of the query parameters loco (loco name), cs (command station
+// name) and topic (topic prefix). All parameters can be provided more than once.
+func ssePrefixes(r *http.Request) []string {
+	var prefixes []string
+	query := r.URL.Query()
+	for _, name := range query["loco"] {
+		prefixes = append(prefixes, "loco/"+name)
+	}
+	for _, name := range query["cs"] {
+		prefixes = append(prefixes, "cs/"+name)
+	}
+	for _, topic := range query["topic"] {
+		prefixes = append(prefixes, strings.Trim(topic, "/"))
+	}
+	return prefixes
+}
+
+// ServeHTTP implements the http.Handler interface streaming the events as Server-Sent Events
+// (data: JSON encoded event {"topic": <topic>, "value": <value>}).
+func (h *SSEHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := &sseClient{prefixes: ssePrefixes(r), sendCh: make(chan *Event, wsQueueSize)}
+	h.add(c)
+	defer h.remove(c)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-c.sendCh:
+			b, err := json.Marshal(event)
+			if err != nil {
+				h.lg.Printf("event stream: event %s: %s", event.Topic, err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+func TestSSE(t *testing.T) {
+	hub := NewSSEHub(logger.Null)
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?loco=br01&topic=/cs/cs01/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("content type %s - expected text/event-stream", contentType)
+	}
+
+	// wait until the client is registered
+	for i := 0; ; i++ {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("event stream client not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hub.Publish("loco/br02/speed", 7)    // filtered
+	hub.Publish("loco/br012/speed", 7)   // filtered (prefix is no topic level prefix)
+	hub.Publish("loco/br01/speed", 42)   // loco filter
+	hub.Publish("cs/cs01/enabled", true) // topic filter
+
+	scanner := bufio.NewScanner(resp.Body)
+	// nextEvent returns the next non-empty line of the event stream.
+	nextEvent := func() (string, bool) {
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				return line, true
+			}
+		}
+		return "", false
+	}
+
+	for _, want := range []string{`data: {"topic":"loco/br01/speed","value":42}`, `data: {"topic":"cs/cs01/enabled","value":true}`} {
+		if event, _ := nextEvent(); event != want {
+			t.Fatalf("event %s - expected %s", event, want)
+		}
+	}
+
+	hub.Close()
+	if event, ok := nextEvent(); ok {
+		t.Fatalf("unexpected event %s after close", event)
+	}
+}
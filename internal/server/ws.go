@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// WebSocket defaults.
+const (
+	wsQueueSize    = 256
+	wsWriteTimeout = 10 * time.Second
+)
+
+// An Event is an event frame sent to the WebSocket clients.
+type Event struct {
+	Topic string `json:"topic"`
+	Value any    `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// A Command is a command frame received from a WebSocket client.
+type Command struct {
+	Topic string          `json:"topic"`
+	Value json.RawMessage `json:"value"`
+}
+
+// A CommandFn executes a command received from a WebSocket client.
+type CommandFn func(cmd *Command) error
+
+// A WSHub streams events to the connected WebSocket clients and executes the command frames
+// received from the clients.
+type WSHub struct {
+	lg       logger.Logger
+	cmdFn    CommandFn
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+}
+
+// NewWSHub returns a new WebSocket hub instance. As command frames control the devices, browser
+// connections are only accepted from the same origin or the origin hosts of the http configuration.
+func NewWSHub(lg logger.Logger, config *Config, cmdFn CommandFn) *WSHub {
+	h := &WSHub{lg: lg, cmdFn: cmdFn, clients: map[*wsClient]struct{}{}}
+	h.upgrader.CheckOrigin = func(r *http.Request) bool {
+		if !config.allowedOrigin(r) {
+			lg.Printf("websocket %s: origin %s not allowed", r.RemoteAddr, r.Header.Get("Origin"))
+			return false
+		}
+		return true
+	}
+	return h
+}
+
+type wsClient struct {
+	conn   *websocket.Conn
+	sendCh chan *Event
+	done   chan struct{}
+}
+
+// Publish sends an event to all connected clients (does not block - events are dropped for
+// clients not keeping up).
+func (h *WSHub) Publish(topic string, value any) {
+	event := &Event{Topic: topic, Value: value}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		select {
+		case c.sendCh <- event:
+		default:
+			h.lg.Printf("websocket %s: queue full - event %s dropped", c.conn.RemoteAddr(), topic)
+		}
+	}
+}
+
+// Close closes all client connections.
+func (h *WSHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.conn.Close()
+	}
+	return nil
+}
+
+func (h *WSHub) add(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+func (h *WSHub) remove(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// ServeHTTP implements the http.Handler interface upgrading the connection to WebSocket.
+func (h *WSHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // error response sent by upgrader
+	}
+	c := &wsClient{conn: conn, sendCh: make(chan *Event, wsQueueSize), done: make(chan struct{})}
+	h.add(c)
+	go h.write(c)
+	h.read(c)
+}
+
+func (h *WSHub) write(c *wsClient) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case event := <-c.sendCh:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteJSON(event); err != nil {
+				c.conn.Close() // terminates reader
+				return
+			}
+		}
+	}
+}
+
+func (h *WSHub) read(c *wsClient) {
+	defer func() {
+		h.remove(c)
+		close(c.done)
+		c.conn.Close()
+	}()
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				h.lg.Printf("websocket %s: %s", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		var cmd Command
+		if err := json.Unmarshal(b, &cmd); err != nil {
+			c.sendErr("", err)
+			continue
+		}
+		if err := h.cmdFn(&cmd); err != nil {
+			c.sendErr(cmd.Topic, err)
+		}
+	}
+}
+
+func (c *wsClient) sendErr(topic string, err error) {
+	select {
+	case c.sendCh <- &Event{Topic: topic, Error: err.Error()}:
+	default:
+	}
+}
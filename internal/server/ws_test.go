@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+func TestWSHub(t *testing.T) {
+	cmdCh := make(chan *Command, 1)
+	hub := NewWSHub(logger.Null, &Config{Origins: []string{"panel.example.com"}}, func(cmd *Command) error {
+		if cmd.Topic == "loco/br99/speed/set" {
+			return errors.New("loco br99 not found")
+		}
+		cmdCh <- cmd
+		return nil
+	})
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+	defer hub.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example.com"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin not rejected: %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://panel.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(&Command{Topic: "loco/br01/speed/set", Value: []byte("42")}); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-cmdCh; cmd.Topic != "loco/br01/speed/set" || string(cmd.Value) != "42" {
+		t.Fatalf("invalid command %s %s", cmd.Topic, cmd.Value)
+	}
+
+	if err := conn.WriteJSON(&Command{Topic: "loco/br99/speed/set", Value: []byte("42")}); err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Topic != "loco/br99/speed/set" || event.Error != "loco br99 not found" {
+		t.Fatalf("invalid error event %+v", event)
+	}
+}
@@ -0,0 +1,105 @@
+// Package devices provides the pico-cs devices (command stations and locos) for Go applications
+// embedding the gateway and adding devices programmatically.
+//
+// The types are aliases of the internal devices implementation. Like the gateway package the
+// API is experimental and might change with any release.
+package devices
+
+import (
+	"github.com/pico-cs/mqtt-gateway/internal/devices"
+	"github.com/pico-cs/mqtt-gateway/pkg/gateway"
+)
+
+// Configuration types.
+const (
+	CtCS   = devices.CtCS
+	CtLoco = devices.CtLoco
+)
+
+// Stop modes.
+const (
+	StopNone      = devices.StopNone
+	StopEmergency = devices.StopEmergency
+	StopRamp      = devices.StopRamp
+)
+
+// Default values.
+const (
+	DefaultStopRamp      = devices.DefaultStopRamp
+	DefaultPingInterval  = devices.DefaultPingInterval
+	DefaultStatsInterval = devices.DefaultStatsInterval
+
+	DefaultOdometerInterval = devices.DefaultOdometerInterval
+	DefaultAlertInterval    = devices.DefaultAlertInterval
+)
+
+type (
+	// Filter represents an including and excluding list of device names.
+	Filter = devices.Filter
+	// CSSetConfig represents the configuration data common to all command stations.
+	CSSetConfig = devices.CSSetConfig
+	// CSConfig represents the configuration data of a command station.
+	CSConfig = devices.CSConfig
+	// CSIOConfig represents the configuration data of a command station IO.
+	CSIOConfig = devices.CSIOConfig
+	// CSSolenoidConfig represents the configuration data of a solenoid turnout drive connected to command station outputs.
+	CSSolenoidConfig = devices.CSSolenoidConfig
+	// CSSignalConfig represents the configuration data of a light signal driven by command station output IOs.
+	CSSignalConfig = devices.CSSignalConfig
+	// CSPatternConfig represents the configuration data of a command station output pattern.
+	CSPatternConfig = devices.CSPatternConfig
+	// CSButtonConfig represents the configuration data of a panel button connected to a command station input IO.
+	CSButtonConfig = devices.CSButtonConfig
+	// CSPowerConfig represents the track power configuration of a command station.
+	CSPowerConfig = devices.CSPowerConfig
+	// LocoConfig represents the configuration data of a loco.
+	LocoConfig = devices.LocoConfig
+	// LocoFctConfig represents the configuration data of a loco function.
+	LocoFctConfig = devices.LocoFctConfig
+	// LocoMaintenanceConfig represents a maintenance interval of a loco.
+	LocoMaintenanceConfig = devices.LocoMaintenanceConfig
+	// LocoDirLightsConfig represents the configuration data of direction bound loco light functions.
+	LocoDirLightsConfig = devices.LocoDirLightsConfig
+	// CVCmd represents the payload of a CV programming on main command.
+	CVCmd = devices.CVCmd
+	// LocoPair represents a double heading pairing of a lead loco with a helper loco.
+	LocoPair = devices.LocoPair
+	// CSSet represents the set of command stations.
+	CSSet = devices.CSSet
+	// CS represents a command station.
+	CS = devices.CS
+	// LocoSet represents the set of locos.
+	LocoSet = devices.LocoSet
+	// Loco represents a loco.
+	Loco = devices.Loco
+	// LocoState represents the drive state of a loco.
+	LocoState = devices.LocoState
+	// Odometer represents the accumulated running time and estimated distance of a loco.
+	Odometer = devices.Odometer
+	// OdometerReading represents an odometer reading of a loco.
+	OdometerReading = devices.OdometerReading
+	// Latency represents the keepalive ping statistics of a command station.
+	Latency = devices.Latency
+	// CommandStats represents the execution duration statistics of a command.
+	CommandStats = devices.CommandStats
+)
+
+// NewFilter returns a new filter instance.
+func NewFilter() *Filter { return devices.NewFilter() }
+
+// NewCSSetConfig returns a command station set configuration with default values.
+func NewCSSetConfig() *CSSetConfig { return devices.NewCSSetConfig() }
+
+// NewCSConfig returns a command station configuration with default values.
+func NewCSConfig() *CSConfig { return devices.NewCSConfig() }
+
+// NewLocoConfig returns a loco configuration with default values.
+func NewLocoConfig() *LocoConfig { return devices.NewLocoConfig() }
+
+// NewCSSet returns a new command station set (nil config: default values).
+func NewCSSet(lg gateway.Logger, gw *gateway.Gateway, config *CSSetConfig) (*CSSet, error) {
+	return devices.NewCSSet(lg, gw, config)
+}
+
+// NewLocoSet returns a new loco set.
+func NewLocoSet(lg gateway.Logger, gw *gateway.Gateway) *LocoSet { return devices.NewLocoSet(lg, gw) }
@@ -0,0 +1,57 @@
+package devices_test
+
+import (
+	"log"
+	"os"
+
+	"github.com/pico-cs/mqtt-gateway/pkg/devices"
+	"github.com/pico-cs/mqtt-gateway/pkg/gateway"
+)
+
+// Example shows how to embed the gateway adding a command station and a loco programmatically
+// and registering event callback functions.
+func Example() {
+	lg := log.New(os.Stderr, "gateway ", log.LstdFlags)
+
+	gw, err := gateway.New(lg, &gateway.Config{TopicRoot: gateway.DefaultTopicRoot, Host: gateway.DefaultHost, Port: gateway.DefaultPort})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer gw.Close()
+	gw.OnError(func(topic string, err error) { lg.Printf("error on topic %s: %s", topic, err) })
+
+	csSet, err := devices.NewCSSet(lg, gw, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csSet.Close()
+	csSet.OnCSConnected(func(cs *devices.CS, connected bool) { lg.Printf("%s connected: %t", cs, connected) })
+	locoSet := devices.NewLocoSet(lg, gw)
+	defer locoSet.Close()
+
+	csConfig := devices.NewCSConfig()
+	csConfig.Name = "cs1"
+	csConfig.Port = "/dev/ttyACM0"
+	csConfig.Primary.Incls = []string{"br.*"}
+	cs, err := csSet.Add(csConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	locoConfig := devices.NewLocoConfig()
+	locoConfig.Name = "br01"
+	locoConfig.Addr = 1
+	locoConfig.Fcts = map[string]devices.LocoFctConfig{"light": {No: 0}}
+	loco, err := locoSet.Add(locoConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := cs.AddLoco(loco); err != nil {
+		log.Fatal(err)
+	}
+	cs.OnLocoSpeedChanged(func(loco *devices.Loco, speed uint) { lg.Printf("loco %s speed: %d", loco.Config().Name, speed) })
+
+	if err := gw.Listen(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package gateway provides the pico-cs MQTT broker gateway for Go applications embedding the gateway.
+//
+// The types are aliases of the internal gateway implementation, so that values can be used
+// interchangeably with the devices package. The API is experimental: as the aliases expose
+// the internal types including all of their exported fields and methods, it might change
+// with any release and is not covered by a compatibility promise.
+package gateway
+
+import (
+	"github.com/pico-cs/mqtt-gateway/internal/gateway"
+	"github.com/pico-cs/mqtt-gateway/internal/logger"
+)
+
+// Default values.
+const (
+	DefaultTopicRoot   = gateway.DefaultTopicRoot
+	DefaultHost        = gateway.DefaultHost
+	DefaultPort        = gateway.DefaultPort
+	DefaultChanSize    = gateway.DefChanSize
+	DefaultSASTokenTTL = gateway.DefaultSASTokenTTL
+)
+
+// Compatibility profiles.
+const (
+	ProfileNone  = gateway.ProfileNone
+	ProfileAWS   = gateway.ProfileAWS
+	ProfileAzure = gateway.ProfileAzure
+)
+
+// Alert severities.
+const (
+	SeverityInfo     = gateway.SeverityInfo
+	SeverityWarning  = gateway.SeverityWarning
+	SeverityCritical = gateway.SeverityCritical
+)
+
+// Alert states.
+const (
+	AlertRaised  = gateway.AlertRaised
+	AlertCleared = gateway.AlertCleared
+)
+
+// Topic classes with configurable QoS and retain behavior.
+const (
+	TopicClassCS    = gateway.TopicClassCS
+	TopicClassLoco  = gateway.TopicClassLoco
+	TopicClassError = gateway.TopicClassError
+	TopicClassIO    = gateway.TopicClassIO
+)
+
+// Gateway availability states.
+const (
+	StatusOnline  = gateway.StatusOnline
+	StatusOffline = gateway.StatusOffline
+)
+
+// ClassProto is the first topic level (below the topic root) of the protocol buffer topic tree.
+const ClassProto = gateway.ClassProto
+
+type (
+	// Logger defines the logging interface used by the gateway (e.g. *log.Logger).
+	Logger = logger.Logger
+	// Config represents the MQTT configuration of the gateway.
+	Config = gateway.Config
+	// Gateway represents a MQTT broker gateway.
+	Gateway = gateway.Gateway
+	// HndFn represents a handler function.
+	HndFn = gateway.HndFn
+	// HndMsg represents a message provided to a registered handler.
+	HndMsg = gateway.HndMsg
+	// RouteFn represents a routing function of a wildcard subscription.
+	RouteFn = gateway.RouteFn
+	// RetainedMsg represents a retained message.
+	RetainedMsg = gateway.RetainedMsg
+	// PayloadError is returned by typed handler functions for payloads of an unexpected type.
+	PayloadError = gateway.PayloadError
+	// ProtoPayload represents the raw payload of a message received on the protocol buffer topic tree.
+	ProtoPayload = gateway.ProtoPayload
+	// Alert represents a raised or cleared alert.
+	Alert = gateway.Alert
+	// SubscriptionInfo represents debugging information of a subscription.
+	SubscriptionInfo = gateway.SubscriptionInfo
+	// CorrelatedError is an error of a command sent with a correlation id.
+	CorrelatedError = gateway.CorrelatedError
+	// ClassConfig represents the QoS and retain configuration of a topic class.
+	ClassConfig = gateway.ClassConfig
+	// InboundFn represents a middleware function called for each message received by the broker.
+	InboundFn = gateway.InboundFn
+	// OutboundFn represents a middleware function called for each message before it is published.
+	OutboundFn = gateway.OutboundFn
+)
+
+// ErrSkip is returned by a middleware function to drop a message silently.
+var ErrSkip = gateway.ErrSkip
+
+// NullLogger is a discarding logger.
+var NullLogger Logger = logger.Null
+
+// New returns a new gateway instance connected to the MQTT broker. A nil logger discards all log output.
+func New(lg Logger, config *Config) (*Gateway, error) { return gateway.New(lg, config) }
+
+// CheckLevelName checks if topic level name consists of valid characters.
+func CheckLevelName(name string) error { return gateway.CheckLevelName(name) }
+
+// Typed returns a handler function decoding the JSON payload into a value of type T before calling fn.
+func Typed[T any](fn func(v T) (any, error)) HndFn { return gateway.Typed(fn) }
+
+// Decode decodes a handler payload into a value of type T.
+func Decode[T any](payload any) (T, error) { return gateway.Decode[T](payload) }